@@ -9,8 +9,10 @@ import (
 	"time"
 
 	"github.com/partadox/wags_queue/internal/api"
+	"github.com/partadox/wags_queue/internal/attachment"
 	"github.com/partadox/wags_queue/internal/config"
 	"github.com/partadox/wags_queue/internal/db"
+	"github.com/partadox/wags_queue/internal/jobs"
 	"github.com/partadox/wags_queue/internal/worker"
 )
 
@@ -28,16 +30,46 @@ func main() {
 	}
 	defer database.Close()
 
+	// Initialize attachment store
+	attachments, err := attachment.NewStore(cfg.Attachment)
+	if err != nil {
+		log.Fatalf("Failed to initialize attachment store: %v", err)
+	}
+
+	// Initialize job result store
+	jobStore, err := jobs.NewStore(cfg.Job)
+	if err != nil {
+		log.Fatalf("Failed to initialize job store: %v", err)
+	}
+
 	// Initialize worker
-	msgWorker := worker.NewMessageWorker(database, cfg.ExternalAPI)
+	msgWorker := worker.NewMessageWorker(database, cfg.ExternalAPI, attachment.NewSigner(cfg.Attachment))
 	go msgWorker.Run()
 
 	// Initialize bulk message processor
-	bulkProcessor := worker.NewBulkProcessor(database)
+	bulkProcessor := worker.NewBulkProcessor(database, cfg.Worker, cfg.Schedule)
 	go bulkProcessor.Run()
 
+	// Initialize broadcast completion tracker
+	broadcastTracker := worker.NewBroadcastTracker(database)
+	go broadcastTracker.Run()
+
+	// Initialize attachment cleaner
+	attachmentCleaner := worker.NewAttachmentCleaner(database, attachments)
+	go attachmentCleaner.Run()
+
+	// Initialize idempotency key cleaner
+	idempotencyCleaner := worker.NewIdempotencyCleaner(database)
+	go idempotencyCleaner.Run()
+
+	// Initialize background job runner and cleaner
+	jobRunner := worker.NewJobRunner(database, cfg.Job, cfg.Worker, jobStore)
+	go jobRunner.Run()
+	jobCleaner := worker.NewJobCleaner(database, jobStore, cfg.Job.ResultTTL)
+	go jobCleaner.Run()
+
 	// Start the API server
-	apiServer := api.NewServer(cfg, database)
+	apiServer := api.NewServer(cfg, database, attachments, jobStore)
 	go func() {
 		log.Printf("Starting server on port %s...", cfg.Server.Port)
 		if err := apiServer.Start(); err != nil && err != http.ErrServerClosed {
@@ -59,6 +91,11 @@ func main() {
 	// Stop workers first
 	msgWorker.Stop()
 	bulkProcessor.Stop()
+	broadcastTracker.Stop()
+	attachmentCleaner.Stop()
+	idempotencyCleaner.Stop()
+	jobRunner.Stop()
+	jobCleaner.Stop()
 	
 	// Then stop the API server
 	if err := apiServer.Stop(shutdownTimeout); err != nil {
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/attachment"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// multipartOverhead is the room left for multipart headers on top of the file size limit
+const multipartOverhead = 1 << 20
+
+// newAttachmentID generates a random attachment ID
+func newAttachmentID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// handleUploadAttachment stores a file sent as the "file" part of a multipart form
+func (s *Server) handleUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+	
+	r.Body = http.MaxBytesReader(w, r.Body, s.attachments.MaxBytes()+multipartOverhead)
+	
+	reader, err := r.MultipartReader()
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "Expected a multipart/form-data upload")
+		return
+	}
+	
+	// Stream the file part straight to disk instead of buffering the form
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "A file part is required")
+			return
+		}
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", err.Error())
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		
+		s.storeAttachment(w, username, part.FileName(), part.Header.Get("Content-Type"), part)
+		part.Close()
+		return
+	}
+}
+
+// storeAttachment saves an uploaded file and records it for the user
+func (s *Server) storeAttachment(w http.ResponseWriter, username, filename, declaredType string, body io.Reader) {
+	stored, err := s.attachments.Save(body, declaredType)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.Is(err, attachment.ErrTooLarge), errors.As(err, &maxBytesErr):
+			sendErrorResponse(w, http.StatusRequestEntityTooLarge, "File too large",
+				fmt.Sprintf("Maximum size is %d bytes", s.attachments.MaxBytes()))
+		case errors.Is(err, attachment.ErrTypeNotAllowed), errors.Is(err, attachment.ErrEmptyFile):
+			sendErrorResponse(w, http.StatusUnsupportedMediaType, "Invalid file", err.Error())
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Error storing file", err.Error())
+		}
+		return
+	}
+	
+	id, err := newAttachmentID()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error storing file", "")
+		return
+	}
+	
+	filename = filepath.Base(filename)
+	if filename == "." || filename == "/" || filename == "" {
+		filename = id
+	}
+	
+	now := time.Now()
+	att := models.Attachment{
+		ID:       id,
+		Filename: filename,
+		MimeType: stored.MimeType,
+		Size:     stored.Size,
+		SHA256:   stored.SHA256,
+		DTStore:  now,
+		DTExpire: now.Add(s.cfg.Attachment.TTL),
+	}
+	
+	tx, err := s.db.Begin()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting attachment: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	
+	_, err = tx.Exec(`
+		INSERT INTO attachment (
+			id, owner, sha256, size, mime_type, filename, dt_store, dt_expire
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?
+		)
+	`, att.ID, username, att.SHA256, att.Size, att.MimeType, att.Filename, att.DTStore, att.DTExpire)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting attachment: %v", err))
+		return
+	}
+	
+	// The insert waits for a concurrent removal of the last row of the same
+	// content, which may have deleted the file Save found in place
+	if !s.attachments.Exists(att.SHA256) {
+		sendErrorResponse(w, http.StatusServiceUnavailable, "Error storing file", "The file was removed while uploading, please upload it again")
+		return
+	}
+	
+	if err := tx.Commit(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting attachment: %v", err))
+		return
+	}
+	
+	sendJSONResponse(w, http.StatusCreated, att)
+}
+
+// handleDeleteAttachment removes an attachment before it expires
+func (s *Server) handleDeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	attachmentID := mux.Vars(r)["id"]
+	
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+	
+	tx, err := s.db.Begin()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error deleting attachment: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	
+	var sum string
+	err = tx.QueryRow("SELECT sha256 FROM attachment WHERE id = ? AND owner = ? FOR UPDATE", attachmentID, username).Scan(&sum)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			sendErrorResponse(w, http.StatusNotFound, "Attachment not found", "")
+		} else {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading attachment: %v", err))
+		}
+		return
+	}
+	
+	if _, err := tx.Exec("DELETE FROM attachment WHERE id = ?", attachmentID); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error deleting attachment: %v", err))
+		return
+	}
+	
+	// Other uploads of the same content keep the file alive
+	if err := attachment.RemoveUnused(tx, s.attachments, sum); err != nil {
+		log.Printf("Error removing attachment file %s: %v", sum, err)
+	}
+	
+	if err := tx.Commit(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error deleting attachment: %v", err))
+		return
+	}
+	
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDownloadAttachment serves an attachment to the gateway. It is not
+// behind the auth middleware; access is granted by an HMAC-signed, expiring URL.
+func (s *Server) handleDownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	attachmentID := mux.Vars(r)["id"]
+	query := r.URL.Query()
+	
+	if !s.signer.Verify(attachmentID, query.Get("expires"), query.Get("signature")) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	
+	var att models.Attachment
+	err := s.db.QueryRow(`
+		SELECT sha256, mime_type, filename, dt_store 
+		FROM attachment 
+		WHERE id = ? AND dt_expire > ?
+	`, attachmentID, time.Now()).Scan(&att.SHA256, &att.MimeType, &att.Filename, &att.DTStore)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	
+	file, err := s.attachments.Open(att.SHA256)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	
+	w.Header().Set("Content-Type", att.MimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename}))
+	http.ServeContent(w, r, att.Filename, att.DTStore, file)
+}
+
+// resolveAttachment fills in the details of media that refers to an uploaded
+// attachment and checks that it belongs to the user and suits the message type
+func (s *Server) resolveAttachment(username string, contentType models.ContentType, media *models.MediaPayload) error {
+	var mimeType, filename string
+	err := s.db.QueryRow(`
+		SELECT mime_type, filename 
+		FROM attachment 
+		WHERE id = ? AND owner = ? AND dt_expire > ?
+	`, media.AttachmentID, username, time.Now()).Scan(&mimeType, &filename)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("attachment %s not found or expired", media.AttachmentID)
+	}
+	if err != nil {
+		return fmt.Errorf("error loading attachment: %w", err)
+	}
+	
+	if prefix, ok := mediaMimePrefixes[contentType]; ok && !strings.HasPrefix(mimeType, prefix) {
+		return fmt.Errorf("attachment type %s does not match message type %s", mimeType, contentType)
+	}
+	
+	media.MimeType = mimeType
+	if media.Filename == "" {
+		media.Filename = filename
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/autoreply"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/msgtemplate"
+)
+
+// maxRuleNameLength matches the auto_reply_rule.name column
+const maxRuleNameLength = 100
+
+// maxRulePatternLength matches the auto_reply_rule.pattern column
+const maxRulePatternLength = 500
+
+// ruleColumns is the column list scanned by scanRule
+const ruleColumns = `id, name, match_type, pattern, reply_message, template_id, variables, active_from, active_until,
+	cooldown_seconds, position, enabled, dt_store, dt_update`
+
+// scanRule scans an auto-reply rule selected with ruleColumns
+func scanRule(row interface{ Scan(...interface{}) error }) (*models.AutoReplyRule, error) {
+	var rule models.AutoReplyRule
+	var replyMessage, activeFrom, activeUntil sql.NullString
+	var templateID sql.NullInt64
+	var variablesJSON []byte
+	var dtUpdate sql.NullTime
+
+	err := row.Scan(&rule.ID, &rule.Name, &rule.MatchType, &rule.Pattern, &replyMessage, &templateID, &variablesJSON,
+		&activeFrom, &activeUntil, &rule.CooldownSeconds, &rule.Position, &rule.Enabled, &rule.DTStore, &dtUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.ReplyMessage = replyMessage.String
+	rule.ActiveFrom = activeFrom.String
+	rule.ActiveUntil = activeUntil.String
+	if templateID.Valid {
+		id := int(templateID.Int64)
+		rule.TemplateID = &id
+	}
+	if variablesJSON != nil {
+		_ = json.Unmarshal(variablesJSON, &rule.Variables)
+	}
+	if dtUpdate.Valid {
+		rule.DTUpdate = &dtUpdate.Time
+	}
+
+	return &rule, nil
+}
+
+// decodeRuleRequest decodes and validates an auto-reply rule create or update request
+func (s *Server) decodeRuleRequest(w http.ResponseWriter, r *http.Request, username string) (*models.AutoReplyRuleRequest, bool) {
+	var ruleReq models.AutoReplyRuleRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&ruleReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return nil, false
+	}
+
+	if ruleReq.Name == "" || ruleReq.Pattern == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Name and pattern are required")
+		return nil, false
+	}
+
+	if len(ruleReq.Name) > maxRuleNameLength {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid name", fmt.Sprintf("Name must be at most %d characters", maxRuleNameLength))
+		return nil, false
+	}
+
+	if utf8.RuneCountInString(ruleReq.Pattern) > maxRulePatternLength {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid pattern", fmt.Sprintf("Pattern must be at most %d characters", maxRulePatternLength))
+		return nil, false
+	}
+
+	rule := autoreply.Rule{
+		MatchType:   ruleReq.MatchType,
+		Pattern:     ruleReq.Pattern,
+		ActiveFrom:  ruleReq.ActiveFrom,
+		ActiveUntil: ruleReq.ActiveUntil,
+	}
+	if err := rule.Compile(); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid rule", err.Error())
+		return nil, false
+	}
+
+	if ruleReq.CooldownSeconds < 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid rule", "cooldown_seconds cannot be negative")
+		return nil, false
+	}
+
+	// The reply is either a fixed message or a template
+	if (ruleReq.ReplyMessage == "") == (ruleReq.TemplateID == nil) {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid rule", "Exactly one of reply_message and template_id is required")
+		return nil, false
+	}
+	body := ruleReq.ReplyMessage
+	if ruleReq.TemplateID != nil {
+		tpl := s.templateFromRequest(w, username, *ruleReq.TemplateID)
+		if tpl == nil {
+			return nil, false
+		}
+		body = tpl.Body
+	}
+
+	// Every variable of the reply must come from the inbound message, the rule or a regex group
+	vars := msgtemplate.Merge(replyVariables("", ""), ruleReq.Variables)
+	for _, name := range rule.Variables() {
+		vars[name] = ""
+	}
+	if missing := msgtemplate.Missing(body, vars); len(missing) > 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing template variables", fmt.Sprintf("missing variables: %s", strings.Join(missing, ", ")))
+		return nil, false
+	}
+
+	if ruleReq.Enabled == nil {
+		enabled := true
+		ruleReq.Enabled = &enabled
+	}
+
+	return &ruleReq, true
+}
+
+// ruleIDFromPath parses the {id} route variable
+func ruleIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	ruleID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid rule id", "")
+		return 0, false
+	}
+	return ruleID, true
+}
+
+// nullString stores an empty string as NULL
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// handleListAutoReplies lists the auto-reply rules of the authenticated user
+func (s *Server) handleListAutoReplies(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	rows, err := s.db.Query("SELECT "+ruleColumns+" FROM auto_reply_rule WHERE owner = ? ORDER BY position, id", username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying auto-reply rules: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	rules := []*models.AutoReplyRule{}
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			continue // Skip this row and continue with the next
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating auto-reply rules: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, rules)
+}
+
+// handleGetAutoReply returns a single auto-reply rule
+func (s *Server) handleGetAutoReply(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	ruleID, ok := ruleIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	rule, err := scanRule(s.db.QueryRow("SELECT "+ruleColumns+" FROM auto_reply_rule WHERE id = ? AND owner = ?", ruleID, username))
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Auto-reply rule not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading auto-reply rule: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, rule)
+}
+
+// handleCreateAutoReply creates an auto-reply rule
+func (s *Server) handleCreateAutoReply(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	ruleReq, ok := s.decodeRuleRequest(w, r, username)
+	if !ok {
+		return
+	}
+
+	variablesJSON, err := json.Marshal(ruleReq.Variables)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error processing request", "")
+		return
+	}
+
+	res, err := s.db.Exec(`
+		INSERT INTO auto_reply_rule (
+			owner, name, match_type, pattern, reply_message, template_id, variables, active_from, active_until,
+			cooldown_seconds, position, enabled, dt_store
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		)
+	`, username, ruleReq.Name, ruleReq.MatchType, ruleReq.Pattern, nullString(ruleReq.ReplyMessage), ruleReq.TemplateID,
+		variablesJSON, nullString(ruleReq.ActiveFrom), nullString(ruleReq.ActiveUntil), ruleReq.CooldownSeconds,
+		ruleReq.Position, *ruleReq.Enabled, time.Now())
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting auto-reply rule: %v", err))
+		return
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", "Error retrieving auto-reply rule ID")
+		return
+	}
+
+	rule, err := scanRule(s.db.QueryRow("SELECT "+ruleColumns+" FROM auto_reply_rule WHERE id = ?", lastID))
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading auto-reply rule: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, rule)
+}
+
+// handleUpdateAutoReply replaces an auto-reply rule
+func (s *Server) handleUpdateAutoReply(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	ruleID, ok := ruleIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	ruleReq, ok := s.decodeRuleRequest(w, r, username)
+	if !ok {
+		return
+	}
+
+	variablesJSON, err := json.Marshal(ruleReq.Variables)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error processing request", "")
+		return
+	}
+
+	res, err := s.db.Exec(`
+		UPDATE auto_reply_rule
+		SET name = ?,
+			match_type = ?,
+			pattern = ?,
+			reply_message = ?,
+			template_id = ?,
+			variables = ?,
+			active_from = ?,
+			active_until = ?,
+			cooldown_seconds = ?,
+			position = ?,
+			enabled = ?,
+			dt_update = ?
+		WHERE id = ? AND owner = ?
+	`, ruleReq.Name, ruleReq.MatchType, ruleReq.Pattern, nullString(ruleReq.ReplyMessage), ruleReq.TemplateID,
+		variablesJSON, nullString(ruleReq.ActiveFrom), nullString(ruleReq.ActiveUntil), ruleReq.CooldownSeconds,
+		ruleReq.Position, *ruleReq.Enabled, time.Now(), ruleID, username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error updating auto-reply rule: %v", err))
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Auto-reply rule not found", "")
+		return
+	}
+
+	rule, err := scanRule(s.db.QueryRow("SELECT "+ruleColumns+" FROM auto_reply_rule WHERE id = ?", ruleID))
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading auto-reply rule: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, rule)
+}
+
+// handleDeleteAutoReply deletes an auto-reply rule
+func (s *Server) handleDeleteAutoReply(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	ruleID, ok := ruleIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec("DELETE FROM auto_reply_rule WHERE id = ? AND owner = ?", ruleID, username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error deleting auto-reply rule: %v", err))
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Auto-reply rule not found", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// autoReply answers an inbound text with the first enabled rule that matches,
+// is active now and is not cooling down for this recipient. The reply is
+// queued at high priority; the rule and message IDs are returned, or zeros
+// when no rule applies.
+func (s *Server) autoReply(event models.InboundEvent) (int, int, error) {
+	rows, err := s.db.Query("SELECT "+ruleColumns+" FROM auto_reply_rule WHERE owner = ? AND enabled = TRUE ORDER BY position, id", event.Sender)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error querying auto-reply rules: %w", err)
+	}
+
+	rules := make([]*models.AutoReplyRule, 0)
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			continue // Skip this row and continue with the next
+		}
+		rules = append(rules, rule)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("error iterating auto-reply rules: %w", err)
+	}
+
+	now := time.Now()
+	for _, rule := range rules {
+		matcher := autoreply.Rule{
+			MatchType:   rule.MatchType,
+			Pattern:     rule.Pattern,
+			ActiveFrom:  rule.ActiveFrom,
+			ActiveUntil: rule.ActiveUntil,
+		}
+		if err := matcher.Compile(); err != nil {
+			log.Printf("Skipping invalid auto-reply rule (ID: %d): %v", rule.ID, err)
+			continue
+		}
+
+		captured, ok := matcher.Match(event.Message)
+		if !ok || !matcher.ActiveAt(now) {
+			continue
+		}
+
+		if rule.CooldownSeconds > 0 {
+			var lastReply sql.NullTime
+			err := s.db.QueryRow(`
+				SELECT MAX(dt_store)
+				FROM auto_reply_log
+				WHERE rule_id = ? AND recipient = ?
+			`, rule.ID, event.From).Scan(&lastReply)
+			if err != nil {
+				return 0, 0, fmt.Errorf("error checking auto-reply cooldown: %w", err)
+			}
+			if lastReply.Valid && now.Sub(lastReply.Time) < time.Duration(rule.CooldownSeconds)*time.Second {
+				return 0, 0, nil // The matching rule is cooling down, don't fall through to others
+			}
+		}
+
+		messageID, err := s.queueAutoReply(rule, event, captured, now)
+		if err != nil {
+			return 0, 0, err
+		}
+		return rule.ID, messageID, nil
+	}
+
+	return 0, 0, nil
+}
+
+// replyVariables returns the built-in variables of an auto-reply
+func replyVariables(phone, message string) map[string]string {
+	return map[string]string{
+		"phone":   phone,
+		"message": message,
+	}
+}
+
+// queueAutoReply renders the reply of a rule and queues it at high priority
+func (s *Server) queueAutoReply(rule *models.AutoReplyRule, event models.InboundEvent, captured map[string]string, now time.Time) (int, error) {
+	body := rule.ReplyMessage
+	var templateID, templateVersion sql.NullInt64
+	if rule.TemplateID != nil {
+		tpl, err := s.loadTemplate(event.Sender, *rule.TemplateID)
+		if err != nil {
+			return 0, fmt.Errorf("error loading template of auto-reply rule %d: %w", rule.ID, err)
+		}
+		body = tpl.Body
+		templateID = sql.NullInt64{Int64: int64(tpl.ID), Valid: true}
+		templateVersion = sql.NullInt64{Int64: int64(tpl.Version), Valid: true}
+	}
+
+	// Built-in variables, then the rule's own, then the regex captures
+	vars := msgtemplate.Merge(replyVariables(event.From, event.Message), rule.Variables)
+	vars = msgtemplate.Merge(vars, captured)
+
+	content, err := msgtemplate.Render(body, vars)
+	if err != nil {
+		return 0, fmt.Errorf("error rendering auto-reply rule %d: %w", rule.ID, err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	messageID, err := insertReply(tx, event.Sender, event.From, content, templateID, templateVersion, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO auto_reply_log (
+			rule_id, sender, recipient, message_id, dt_store
+		) VALUES (
+			?, ?, ?, ?, ?
+		)
+	`, rule.ID, event.Sender, event.From, messageID, now); err != nil {
+		return 0, fmt.Errorf("error logging auto-reply: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing auto-reply: %w", err)
+	}
+
+	return messageID, nil
+}
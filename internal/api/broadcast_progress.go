@@ -0,0 +1,55 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/progress"
+)
+
+// newProgress starts the progress of a broadcast, to be filled by progress.Fill
+func newProgress(bulkID int, status models.BulkMessageStatus, dtComplete sql.NullTime) *models.BroadcastProgress {
+	p := &models.BroadcastProgress{BulkID: bulkID, Status: status}
+	if dtComplete.Valid {
+		p.DTComplete = &dtComplete.Time
+	}
+	return p
+}
+
+// handleGetBroadcastProgress returns how far the messages of a broadcast have got
+func (s *Server) handleGetBroadcastProgress(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	bulkID, err := strconv.Atoi(mux.Vars(r)["bulk_id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid bulk_id parameter", "")
+		return
+	}
+
+	var status models.BulkMessageStatus
+	var dtComplete sql.NullTime
+	err = s.db.QueryRow("SELECT status, dt_complete FROM message_bulk WHERE id = ? AND sender = ?", bulkID, username).
+		Scan(&status, &dtComplete)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Bulk message not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading bulk message: %v", err))
+		return
+	}
+
+	p := newProgress(bulkID, status, dtComplete)
+	if err := progress.Fill(s.db, username, []*models.BroadcastProgress{p}, time.Now()); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading broadcast progress: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, p)
+}
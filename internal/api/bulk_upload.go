@@ -0,0 +1,360 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/msgtemplate"
+	"github.com/partadox/wags_queue/internal/phone"
+	"github.com/partadox/wags_queue/internal/sheet"
+)
+
+// Limits of an uploaded recipient file
+const (
+	maxBulkUploadBytes = 10 << 20
+	maxBulkUploadRows  = 100000
+)
+
+// maxUploadErrorRows caps how many error rows a response lists
+const maxUploadErrorRows = 100
+
+// uploadSampleSize is how many recipients the upload preview shows
+const uploadSampleSize = 5
+
+// phoneHeaders are the header names picked as the phone column when none is given
+var phoneHeaders = []string{"phone", "phone_number", "number", "recipient", "whatsapp", "wa", "msisdn",
+	"nomor", "no_hp", "nomor_hp", "hp", "telepon", "no_telp", "no_wa"}
+
+// variableNameChars matches the runs of a header that become an underscore
+var variableNameChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// validVariable matches the names a {{placeholder}} can use
+var validVariable = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// variableName turns a column header into a template variable name, so a
+// "Nama Lengkap" column fills {{nama_lengkap}}
+func variableName(header string) string {
+	return strings.Trim(variableNameChars.ReplaceAllString(strings.ToLower(header), "_"), "_")
+}
+
+// bulkUpload is a recipient file read into a bulk message request
+type bulkUpload struct {
+	request     models.BulkMessageRequest
+	columns     []string
+	phoneColumn string
+	variables   map[string]string // Column name to template variable
+	rows        int
+	errors      []models.RecipientError // Index is the row number in the file
+}
+
+// parseBulkUpload reads the multipart form of an upload: the recipient file
+// in "file", the rest of the bulk message as JSON in "request", and
+// optionally "phone_column" and "variables" (a JSON object mapping column
+// names to template variables). Every other column is a variable named after
+// its header by default. Rows with an invalid number or a missing template
+// variable are reported as errors and left out of the recipients.
+func (s *Server) parseBulkUpload(w http.ResponseWriter, r *http.Request, username string) (*bulkUpload, bool) {
+	if !parseUploadForm(w, r, maxBulkUploadBytes) {
+		return nil, false
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	upload := &bulkUpload{}
+
+	requestJSON := r.FormValue("request")
+	if requestJSON == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "A request part with the bulk message is required")
+		return nil, false
+	}
+	if err := json.Unmarshal([]byte(requestJSON), &upload.request); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request part", err.Error())
+		return nil, false
+	}
+	upload.request.Recipients = nil // The file is the recipient list
+
+	// Variables are checked against the template the broadcast will use
+	body := upload.request.Message
+	if upload.request.TemplateID != nil {
+		tpl := s.templateFromRequest(w, username, *upload.request.TemplateID)
+		if tpl == nil {
+			return nil, false
+		}
+		body = tpl.Body
+	}
+
+	rows, headerRow, columns, ok := readUploadFile(w, r, maxBulkUploadRows, "recipients")
+	if !ok {
+		return nil, false
+	}
+	upload.columns = columns
+
+	phoneIndex, ok := pickPhoneColumn(w, upload.columns, r.FormValue("phone_column"))
+	if !ok {
+		return nil, false
+	}
+	upload.phoneColumn = upload.columns[phoneIndex]
+
+	variableIndex, ok := pickVariableColumns(w, upload.columns, phoneIndex, r.FormValue("variables"))
+	if !ok {
+		return nil, false
+	}
+	upload.variables = make(map[string]string, len(variableIndex))
+	for index, variable := range variableIndex {
+		upload.variables[upload.columns[index]] = variable
+	}
+
+	for i := headerRow + 1; i < len(rows); i++ {
+		row := rows[i]
+		if len(row) == 0 {
+			continue // Blank row
+		}
+		upload.rows++
+
+		raw := cell(row, phoneIndex)
+		normalized, err := phone.Normalize(raw, s.cfg.Phone.DefaultCountry)
+		if err != nil {
+			upload.errors = append(upload.errors, models.RecipientError{Index: i + 1, Phone: raw, Error: err.Error()})
+			continue
+		}
+
+		// An empty cell counts as a missing value
+		vars := make(map[string]string, len(variableIndex))
+		for index, variable := range variableIndex {
+			if value := cell(row, index); value != "" {
+				vars[variable] = value
+			}
+		}
+		if missing := msgtemplate.Missing(body, msgtemplate.Merge(upload.request.Variables, vars)); len(missing) > 0 {
+			upload.errors = append(upload.errors, models.RecipientError{
+				Index:   i + 1,
+				Phone:   raw,
+				Error:   "Missing template variables",
+				Missing: missing,
+			})
+			continue
+		}
+
+		recipient := models.Recipient{Phone: normalized}
+		if len(vars) > 0 {
+			recipient.Vars = vars
+		}
+		upload.request.Recipients = append(upload.request.Recipients, recipient)
+	}
+
+	return upload, true
+}
+
+// parseUploadForm parses a multipart upload of at most maxBytes. On error it
+// writes the response and returns false; otherwise the caller removes the
+// form's temp files.
+func parseUploadForm(w http.ResponseWriter, r *http.Request, maxBytes int64) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+multipartOverhead)
+	if err := r.ParseMultipartForm(multipartOverhead); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendErrorResponse(w, http.StatusRequestEntityTooLarge, "File too large",
+				fmt.Sprintf("Maximum size is %d bytes", maxBytes))
+			return false
+		}
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "Expected a multipart/form-data upload")
+		return false
+	}
+	return true
+}
+
+// readUploadFile reads the CSV or XLSX file in the "file" part of a parsed
+// upload. It returns the rows, the index of the header row, which is the
+// first non-blank one, and the column names; unnamed columns are called
+// column_N. what names the rows in the error for a file that is too long.
+func readUploadFile(w http.ResponseWriter, r *http.Request, maxRows int, what string) ([][]string, int, []string, bool) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "A file part is required")
+		return nil, 0, nil, false
+	}
+	defer file.Close()
+
+	// One extra row for the header
+	rows, err := sheet.Read(file, header.Size, maxRows+1)
+	if errors.Is(err, sheet.ErrTooManyRows) {
+		sendErrorResponse(w, http.StatusBadRequest, "Too many rows", fmt.Sprintf("A file can hold at most %d %s", maxRows, what))
+		return nil, 0, nil, false
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid file", err.Error())
+		return nil, 0, nil, false
+	}
+
+	headerRow := 0
+	for headerRow < len(rows) && len(rows[headerRow]) == 0 {
+		headerRow++
+	}
+	if headerRow == len(rows) {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid file", "The file is empty")
+		return nil, 0, nil, false
+	}
+
+	columns := make([]string, 0, len(rows[headerRow]))
+	for i, name := range rows[headerRow] {
+		if name == "" {
+			name = "column_" + strconv.Itoa(i+1)
+		}
+		columns = append(columns, name)
+	}
+	return rows, headerRow, columns, true
+}
+
+// pickPhoneColumn finds the phone column by name, or by a well-known header
+func pickPhoneColumn(w http.ResponseWriter, columns []string, name string) (int, bool) {
+	if name != "" {
+		for i, column := range columns {
+			if strings.EqualFold(column, name) {
+				return i, true
+			}
+		}
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid phone_column",
+			fmt.Sprintf("No column %q; the file has %s", name, strings.Join(columns, ", ")))
+		return 0, false
+	}
+
+	for i, column := range columns {
+		if containsString(phoneHeaders, variableName(column)) {
+			return i, true
+		}
+	}
+	sendErrorResponse(w, http.StatusBadRequest, "Missing phone_column",
+		fmt.Sprintf("No column looks like a phone number; pick one of %s", strings.Join(columns, ", ")))
+	return 0, false
+}
+
+// pickVariableColumns maps column positions to template variables. Without
+// a mapping every column but the phone column becomes a variable.
+func pickVariableColumns(w http.ResponseWriter, columns []string, phoneIndex int, mappingJSON string) (map[int]string, bool) {
+	variables := make(map[int]string)
+
+	if mappingJSON == "" {
+		for i, column := range columns {
+			if name := variableName(column); i != phoneIndex && name != "" {
+				variables[i] = name
+			}
+		}
+		return variables, true
+	}
+
+	var mapping map[string]string
+	if err := json.Unmarshal([]byte(mappingJSON), &mapping); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid variables", "Expected a JSON object mapping column names to variables")
+		return nil, false
+	}
+
+	for column, variable := range mapping {
+		index := -1
+		for i, name := range columns {
+			if strings.EqualFold(name, column) {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid variables",
+				fmt.Sprintf("No column %q; the file has %s", column, strings.Join(columns, ", ")))
+			return nil, false
+		}
+		if !validVariable.MatchString(variable) {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid variables",
+				fmt.Sprintf("%q is not a valid variable name", variable))
+			return nil, false
+		}
+		variables[index] = variable
+	}
+	return variables, true
+}
+
+// cell returns a value of a row, which may be shorter than the header
+func cell(row []string, index int) string {
+	if index < len(row) {
+		return row[index]
+	}
+	return ""
+}
+
+// firstErrorRows returns at most maxUploadErrorRows error rows
+func firstErrorRows(errs []models.RecipientError) []models.RecipientError {
+	if len(errs) > maxUploadErrorRows {
+		return errs[:maxUploadErrorRows]
+	}
+	return errs
+}
+
+// handlePreviewBulkUpload reads a recipient file and shows the columns it
+// found, the rows it rejected and how the broadcast would be scheduled,
+// without storing anything
+func (s *Server) handlePreviewBulkUpload(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	upload, ok := s.parseBulkUpload(w, r, username)
+	if !ok {
+		return
+	}
+
+	schedulePreview, ok := s.previewBulk(w, username, &upload.request)
+	if !ok {
+		return
+	}
+
+	sample := upload.request.Recipients
+	if len(sample) > uploadSampleSize {
+		sample = sample[:uploadSampleSize]
+	}
+
+	previewResp := models.BulkUploadPreviewResponse{
+		Columns:             upload.columns,
+		PhoneColumn:         upload.phoneColumn,
+		Variables:           upload.variables,
+		Rows:                upload.rows,
+		ErrorCount:          len(upload.errors),
+		ErrorRows:           firstErrorRows(upload.errors),
+		Sample:              sample,
+		BulkPreviewResponse: *schedulePreview,
+	}
+	if previewResp.ErrorRows == nil {
+		previewResp.ErrorRows = []models.RecipientError{}
+	}
+	if previewResp.Sample == nil {
+		previewResp.Sample = []models.Recipient{}
+	}
+
+	sendJSONResponse(w, http.StatusOK, previewResp)
+}
+
+// handleSendBulkUpload creates a bulk message from a recipient file. A file
+// with error rows is rejected unless skip_errors is true, in which case those
+// rows are left out. The rest goes through the same checks as send-bulk.
+func (s *Server) handleSendBulkUpload(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	upload, ok := s.parseBulkUpload(w, r, username)
+	if !ok {
+		return
+	}
+
+	skipErrors, _ := strconv.ParseBool(r.FormValue("skip_errors"))
+	if len(upload.errors) > 0 && !skipErrors {
+		sendJSONResponse(w, http.StatusBadRequest, models.ErrorResponse{
+			Error:           "Invalid rows",
+			Details:         fmt.Sprintf("%d row(s) rejected; fix them or set skip_errors to true", len(upload.errors)),
+			RecipientErrors: firstErrorRows(upload.errors),
+		})
+		return
+	}
+
+	s.createBulk(w, username, &upload.request)
+}
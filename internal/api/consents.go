@@ -0,0 +1,245 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/consent"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/phone"
+)
+
+// maxConsentSourceLength matches the consent.source column
+const maxConsentSourceLength = 100
+
+// normalizeCategory validates a message category, defaulting to transactional
+func normalizeCategory(category models.MessageCategory) (models.MessageCategory, error) {
+	switch category {
+	case "":
+		return models.CategoryTransactional, nil
+	case models.CategoryTransactional, models.CategoryMarketing:
+		return category, nil
+	}
+	return "", fmt.Errorf("unknown category %q, expected %q or %q", category, models.CategoryTransactional, models.CategoryMarketing)
+}
+
+// hasConsent reports whether a single recipient may receive marketing messages from the sender
+func (s *Server) hasConsent(sender, recipient string) (bool, error) {
+	consented, err := consent.Consented(s.db, sender, []string{recipient}, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return consented[recipient], nil
+}
+
+// consentColumns is the column list scanned by scanConsent
+const consentColumns = "id, recipient, source, evidence, dt_consent, dt_expire, dt_revoke, dt_store"
+
+// scanConsent scans a consent row selected with consentColumns
+func scanConsent(row interface{ Scan(...interface{}) error }, now time.Time) (*models.Consent, error) {
+	var c models.Consent
+	var evidence sql.NullString
+	var dtExpire, dtRevoke sql.NullTime
+
+	if err := row.Scan(&c.ID, &c.Recipient, &c.Source, &evidence, &c.DTConsent, &dtExpire, &dtRevoke, &c.DTStore); err != nil {
+		return nil, err
+	}
+
+	c.Evidence = evidence.String
+	if dtExpire.Valid {
+		c.DTExpire = &dtExpire.Time
+	}
+	if dtRevoke.Valid {
+		c.DTRevoke = &dtRevoke.Time
+	}
+	c.Valid = c.DTRevoke == nil && !c.DTConsent.After(now) && (c.DTExpire == nil || c.DTExpire.After(now))
+
+	return &c, nil
+}
+
+// consentIDFromPath parses the {id} route variable
+func consentIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	consentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid consent id", "")
+		return 0, false
+	}
+	return consentID, true
+}
+
+// handleListConsents lists the consent records of the authenticated user,
+// optionally for a single recipient
+func (s *Server) handleListConsents(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	query := "SELECT " + consentColumns + " FROM consent WHERE sender = ?"
+	args := []interface{}{username}
+
+	if raw := r.URL.Query().Get("recipient"); raw != "" {
+		recipient, err := phone.Normalize(raw, s.cfg.Phone.DefaultCountry)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid phone number", err.Error())
+			return
+		}
+		query += " AND recipient = ?"
+		args = append(args, recipient)
+	}
+	query += " ORDER BY dt_consent DESC"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying consents: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	consents := []*models.Consent{}
+	for rows.Next() {
+		c, err := scanConsent(rows, now)
+		if err != nil {
+			continue // Skip this row and continue with the next
+		}
+		consents = append(consents, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating consents: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, consents)
+}
+
+// handleGetConsent returns a single consent record
+func (s *Server) handleGetConsent(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	consentID, ok := consentIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	row := s.db.QueryRow("SELECT "+consentColumns+" FROM consent WHERE id = ? AND sender = ?", consentID, username)
+	c, err := scanConsent(row, time.Now())
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Consent not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading consent: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, c)
+}
+
+// handleCreateConsent records a recipient's opt-in to marketing messages
+func (s *Server) handleCreateConsent(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	var consentReq models.ConsentRequest
+	if err := json.NewDecoder(r.Body).Decode(&consentReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return
+	}
+
+	if consentReq.Recipient == "" || consentReq.Source == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Recipient and source are required")
+		return
+	}
+
+	if len(consentReq.Source) > maxConsentSourceLength {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid source", fmt.Sprintf("Source must be at most %d characters", maxConsentSourceLength))
+		return
+	}
+
+	recipient, err := phone.Normalize(consentReq.Recipient, s.cfg.Phone.DefaultCountry)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid phone number", err.Error())
+		return
+	}
+
+	now := time.Now()
+	dtConsent := now
+	if consentReq.DTConsent != nil {
+		dtConsent = *consentReq.DTConsent
+	}
+	if dtConsent.After(now) {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid consent time", "dt_consent cannot be in the future")
+		return
+	}
+	if consentReq.DTExpire != nil && !consentReq.DTExpire.After(dtConsent) {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid expiry", "dt_expire must be after dt_consent")
+		return
+	}
+
+	var evidence sql.NullString
+	if consentReq.Evidence != "" {
+		evidence = sql.NullString{String: consentReq.Evidence, Valid: true}
+	}
+
+	res, err := s.db.Exec(`
+		INSERT INTO consent (
+			sender, recipient, source, evidence, dt_consent, dt_expire, dt_store
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?
+		)
+	`, username, recipient, consentReq.Source, evidence, dtConsent, consentReq.DTExpire, now)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting consent: %v", err))
+		return
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", "Error retrieving consent ID")
+		return
+	}
+
+	row := s.db.QueryRow("SELECT "+consentColumns+" FROM consent WHERE id = ?", lastID)
+	c, err := scanConsent(row, now)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading consent: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, c)
+}
+
+// handleRevokeConsent revokes a consent record. The record is kept as proof
+// of what was consented to and when it ended.
+func (s *Server) handleRevokeConsent(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	consentID, ok := consentIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec(`
+		UPDATE consent
+		SET dt_revoke = ?
+		WHERE id = ? AND sender = ? AND dt_revoke IS NULL
+	`, time.Now(), consentID, username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error revoking consent: %v", err))
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Consent not found", "The consent does not exist or was already revoked")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
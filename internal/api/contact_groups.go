@@ -0,0 +1,418 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/contacts"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/phone"
+)
+
+// Lengths of the contact_group columns
+const (
+	maxGroupNameLength        = 100
+	maxGroupDescriptionLength = 255
+)
+
+// maxGroupMembersPerRequest caps how many contacts one request adds to a group
+const maxGroupMembersPerRequest = 1000
+
+// contactGroupColumns is the column list scanned by scanContactGroup
+const contactGroupColumns = `g.id, g.name, g.description,
+	(SELECT COUNT(*) FROM contact_group_member m WHERE m.group_id = g.id),
+	g.dt_store, g.dt_update`
+
+// scanContactGroup scans a group selected with contactGroupColumns
+func scanContactGroup(row interface{ Scan(...interface{}) error }) (*models.ContactGroup, error) {
+	var g models.ContactGroup
+	var description sql.NullString
+	var dtUpdate sql.NullTime
+
+	if err := row.Scan(&g.ID, &g.Name, &description, &g.MemberCount, &g.DTStore, &dtUpdate); err != nil {
+		return nil, err
+	}
+
+	g.Description = description.String
+	if dtUpdate.Valid {
+		g.DTUpdate = &dtUpdate.Time
+	}
+
+	return &g, nil
+}
+
+// loadContactGroup loads a group of the user
+func (s *Server) loadContactGroup(username string, groupID int) (*models.ContactGroup, error) {
+	return scanContactGroup(s.db.QueryRow("SELECT "+contactGroupColumns+" FROM contact_group g WHERE g.id = ? AND g.owner = ?",
+		groupID, username))
+}
+
+// groupIDFromPath parses the {id} route variable
+func groupIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	groupID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid group id", "")
+		return 0, false
+	}
+	return groupID, true
+}
+
+// checkGroups verifies that every group belongs to the user and returns how
+// many contacts they hold together. On error it writes the response.
+func (s *Server) checkGroups(w http.ResponseWriter, username string, groupIDs []int) (int, bool) {
+	if len(groupIDs) == 0 {
+		return 0, true
+	}
+
+	missing, err := contacts.MissingGroups(s.db, username, groupIDs)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return 0, false
+	}
+	if len(missing) > 0 {
+		ids := make([]string, len(missing))
+		for i, id := range missing {
+			ids[i] = strconv.Itoa(id)
+		}
+		sendErrorResponse(w, http.StatusBadRequest, "Contact group not found",
+			fmt.Sprintf("No contact group %s", strings.Join(ids, ", ")))
+		return 0, false
+	}
+
+	members, err := contacts.CountMembers(s.db, username, groupIDs)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return 0, false
+	}
+	return members, true
+}
+
+// decodeContactGroupRequest decodes and validates a group create or update request
+func decodeContactGroupRequest(w http.ResponseWriter, r *http.Request) (*models.ContactGroupRequest, bool) {
+	var groupReq models.ContactGroupRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&groupReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return nil, false
+	}
+
+	groupReq.Name = strings.TrimSpace(groupReq.Name)
+	if groupReq.Name == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Name is required")
+		return nil, false
+	}
+
+	if len(groupReq.Name) > maxGroupNameLength {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid name", fmt.Sprintf("Name must be at most %d characters", maxGroupNameLength))
+		return nil, false
+	}
+
+	if len(groupReq.Description) > maxGroupDescriptionLength {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid description",
+			fmt.Sprintf("Description must be at most %d characters", maxGroupDescriptionLength))
+		return nil, false
+	}
+
+	return &groupReq, true
+}
+
+// handleListContactGroups lists the contact groups of the authenticated user
+func (s *Server) handleListContactGroups(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	rows, err := s.db.Query("SELECT "+contactGroupColumns+" FROM contact_group g WHERE g.owner = ? ORDER BY g.name",
+		username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying contact groups: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	groups := []*models.ContactGroup{}
+	for rows.Next() {
+		g, err := scanContactGroup(rows)
+		if err != nil {
+			continue // Skip this row and continue with the next
+		}
+		groups = append(groups, g)
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating contact groups: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, groups)
+}
+
+// handleGetContactGroup returns a single contact group
+func (s *Server) handleGetContactGroup(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	groupID, ok := groupIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	g, err := s.loadContactGroup(username, groupID)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Contact group not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading contact group: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, g)
+}
+
+// handleCreateContactGroup creates an empty contact group
+func (s *Server) handleCreateContactGroup(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	groupReq, ok := decodeContactGroupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec(`
+		INSERT INTO contact_group (
+			owner, name, description, dt_store
+		) VALUES (
+			?, ?, ?, ?
+		)
+	`, username, groupReq.Name, nullString(groupReq.Description), time.Now())
+	if isDuplicateKey(err) {
+		sendErrorResponse(w, http.StatusConflict, "Contact group already exists", fmt.Sprintf("There is already a group named %q", groupReq.Name))
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting contact group: %v", err))
+		return
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", "Error retrieving contact group ID")
+		return
+	}
+
+	g, err := s.loadContactGroup(username, int(lastID))
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading contact group: %v", err))
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/contact-groups/%d", g.ID))
+	sendJSONResponse(w, http.StatusCreated, g)
+}
+
+// handleUpdateContactGroup renames a contact group or changes its description
+func (s *Server) handleUpdateContactGroup(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	groupID, ok := groupIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	groupReq, ok := decodeContactGroupRequest(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE contact_group
+		SET name = ?,
+			description = ?,
+			dt_update = ?
+		WHERE id = ? AND owner = ?
+	`, groupReq.Name, nullString(groupReq.Description), time.Now(), groupID, username)
+	if isDuplicateKey(err) {
+		sendErrorResponse(w, http.StatusConflict, "Contact group already exists", fmt.Sprintf("There is already a group named %q", groupReq.Name))
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error updating contact group: %v", err))
+		return
+	}
+
+	g, err := s.loadContactGroup(username, groupID)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Contact group not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading contact group: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, g)
+}
+
+// handleDeleteContactGroup deletes a contact group; its contacts are kept.
+// Broadcasts not yet expanded skip the group.
+func (s *Server) handleDeleteContactGroup(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	groupID, ok := groupIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec("DELETE FROM contact_group WHERE id = ? AND owner = ?", groupID, username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error deleting contact group: %v", err))
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Contact group not found", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAddContactGroupMembers adds existing contacts to a group, by id or by
+// phone number. Contacts that are not found are listed in the response.
+func (s *Server) handleAddContactGroupMembers(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	groupID, ok := groupIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var membersReq models.ContactGroupMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&membersReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return
+	}
+
+	if len(membersReq.ContactIDs) == 0 && len(membersReq.Phones) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "contact_ids or phones are required")
+		return
+	}
+	if len(membersReq.ContactIDs)+len(membersReq.Phones) > maxGroupMembersPerRequest {
+		sendErrorResponse(w, http.StatusBadRequest, "Too many contacts",
+			fmt.Sprintf("At most %d contacts can be added at once", maxGroupMembersPerRequest))
+		return
+	}
+
+	if _, err := s.loadContactGroup(username, groupID); err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Contact group not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading contact group: %v", err))
+		return
+	}
+
+	membersResp := models.ContactGroupMembersResponse{}
+
+	// Resolve every contact to an id of the user
+	contactIDs := make([]int, 0, len(membersReq.ContactIDs)+len(membersReq.Phones))
+	for _, contactID := range membersReq.ContactIDs {
+		var id int
+		err := s.db.QueryRow("SELECT id FROM contact WHERE id = ? AND owner = ?", contactID, username).Scan(&id)
+		if err == sql.ErrNoRows {
+			membersResp.NotFound = append(membersResp.NotFound, strconv.Itoa(contactID))
+			continue
+		} else if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading contact: %v", err))
+			return
+		}
+		contactIDs = append(contactIDs, id)
+	}
+	for _, raw := range membersReq.Phones {
+		normalized, err := phone.Normalize(raw, s.cfg.Phone.DefaultCountry)
+		if err != nil {
+			membersResp.NotFound = append(membersResp.NotFound, raw)
+			continue
+		}
+		var id int
+		err = s.db.QueryRow("SELECT id FROM contact WHERE owner = ? AND phone = ?", username, normalized).Scan(&id)
+		if err == sql.ErrNoRows {
+			membersResp.NotFound = append(membersResp.NotFound, raw)
+			continue
+		} else if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading contact: %v", err))
+			return
+		}
+		contactIDs = append(contactIDs, id)
+	}
+
+	now := time.Now()
+	for _, contactID := range contactIDs {
+		res, err := s.db.Exec(`
+			INSERT IGNORE INTO contact_group_member (
+				group_id, contact_id, dt_store
+			) VALUES (
+				?, ?, ?
+			)
+		`, groupID, contactID, now)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error adding group member: %v", err))
+			return
+		}
+		if affected, _ := res.RowsAffected(); affected == 1 {
+			membersResp.Added++
+		} else {
+			membersResp.Existing++
+		}
+	}
+
+	g, err := s.loadContactGroup(username, groupID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading contact group: %v", err))
+		return
+	}
+	membersResp.MemberCount = g.MemberCount
+
+	sendJSONResponse(w, http.StatusOK, membersResp)
+}
+
+// handleRemoveContactGroupMember removes a contact from a group; the contact is kept
+func (s *Server) handleRemoveContactGroupMember(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	groupID, ok := groupIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	contactID, err := strconv.Atoi(mux.Vars(r)["contact_id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid contact id", "")
+		return
+	}
+
+	res, err := s.db.Exec(`
+		DELETE m
+		FROM contact_group_member m
+		JOIN contact_group g ON g.id = m.group_id
+		WHERE m.group_id = ? AND m.contact_id = ? AND g.owner = ?
+	`, groupID, contactID, username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error removing group member: %v", err))
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Group member not found", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
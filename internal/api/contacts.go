@@ -0,0 +1,644 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/phone"
+)
+
+// maxContactNameLength matches the contact.name column
+const maxContactNameLength = 100
+
+// Limits of the attributes of a contact
+const (
+	maxContactAttributes    = 50
+	maxAttributeValueLength = 500
+)
+
+// Limits of an imported contact file
+const (
+	maxContactImportBytes = 10 << 20
+	maxContactImportRows  = 100000
+)
+
+// duplicateKeyError is the MySQL error number of a unique key violation
+const duplicateKeyError = 1062
+
+// nameHeaders are the header names picked as the name column of an import
+var nameHeaders = []string{"name", "nama", "full_name", "nama_lengkap"}
+
+// contactColumns is the column list scanned by scanContact
+const contactColumns = `c.id, c.phone, c.name, c.attributes, c.dt_store, c.dt_update`
+
+// scanContact scans a contact selected with contactColumns
+func scanContact(row interface{ Scan(...interface{}) error }) (*models.Contact, error) {
+	var c models.Contact
+	var name sql.NullString
+	var attributesJSON []byte
+	var dtUpdate sql.NullTime
+
+	if err := row.Scan(&c.ID, &c.Phone, &name, &attributesJSON, &c.DTStore, &dtUpdate); err != nil {
+		return nil, err
+	}
+
+	c.Name = name.String
+	if len(attributesJSON) > 0 {
+		if err := json.Unmarshal(attributesJSON, &c.Attributes); err != nil {
+			return nil, fmt.Errorf("error decoding contact attributes: %w", err)
+		}
+	}
+	if c.Attributes == nil {
+		c.Attributes = map[string]string{}
+	}
+	c.GroupIDs = []int{}
+	if dtUpdate.Valid {
+		c.DTUpdate = &dtUpdate.Time
+	}
+
+	return &c, nil
+}
+
+// isDuplicateKey reports whether an insert or update failed on a unique key
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == duplicateKeyError
+}
+
+// fillContactGroups sets the group ids of the listed contacts
+func (s *Server) fillContactGroups(contactList []*models.Contact) error {
+	if len(contactList) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*models.Contact, len(contactList))
+	args := make([]interface{}, 0, len(contactList))
+	for _, c := range contactList {
+		byID[c.ID] = c
+		args = append(args, c.ID)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT contact_id, group_id
+		FROM contact_group_member
+		WHERE contact_id IN (?`+strings.Repeat(", ?", len(args)-1)+`)
+		ORDER BY group_id
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("error querying contact groups: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var contactID, groupID int
+		if err := rows.Scan(&contactID, &groupID); err != nil {
+			return fmt.Errorf("error scanning contact group row: %w", err)
+		}
+		if c, ok := byID[contactID]; ok {
+			c.GroupIDs = append(c.GroupIDs, groupID)
+		}
+	}
+	return rows.Err()
+}
+
+// loadContact loads a contact of the user with its groups
+func (s *Server) loadContact(username string, contactID int) (*models.Contact, error) {
+	c, err := scanContact(s.db.QueryRow("SELECT "+contactColumns+" FROM contact c WHERE c.id = ? AND c.owner = ?",
+		contactID, username))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.fillContactGroups([]*models.Contact{c}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// contactIDFromPath parses the {id} route variable
+func contactIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	contactID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid contact id", "")
+		return 0, false
+	}
+	return contactID, true
+}
+
+// validateAttributes checks that every attribute can be used as a template variable
+func validateAttributes(attributes map[string]string) error {
+	if len(attributes) > maxContactAttributes {
+		return fmt.Errorf("a contact can have at most %d attributes", maxContactAttributes)
+	}
+	for key, value := range attributes {
+		if !validVariable.MatchString(key) {
+			return fmt.Errorf("%q is not a valid attribute name", key)
+		}
+		if len(value) > maxAttributeValueLength {
+			return fmt.Errorf("attribute %q must be at most %d characters", key, maxAttributeValueLength)
+		}
+	}
+	return nil
+}
+
+// decodeContactRequest decodes and validates a contact create or update
+// request and normalizes its phone number
+func (s *Server) decodeContactRequest(w http.ResponseWriter, r *http.Request) (*models.ContactRequest, bool) {
+	var contactReq models.ContactRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&contactReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return nil, false
+	}
+
+	if contactReq.Phone == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Phone is required")
+		return nil, false
+	}
+
+	normalized, err := phone.Normalize(contactReq.Phone, s.cfg.Phone.DefaultCountry)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid phone number", err.Error())
+		return nil, false
+	}
+	contactReq.Phone = normalized
+
+	contactReq.Name = strings.TrimSpace(contactReq.Name)
+	if len(contactReq.Name) > maxContactNameLength {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid name", fmt.Sprintf("Name must be at most %d characters", maxContactNameLength))
+		return nil, false
+	}
+
+	if err := validateAttributes(contactReq.Attributes); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid attributes", err.Error())
+		return nil, false
+	}
+
+	return &contactReq, true
+}
+
+// setContactGroups replaces the groups of a contact. The groups were checked
+// to belong to the user beforehand.
+func setContactGroups(tx *sql.Tx, contactID int, groupIDs []int, now time.Time) error {
+	if _, err := tx.Exec("DELETE FROM contact_group_member WHERE contact_id = ?", contactID); err != nil {
+		return fmt.Errorf("error removing contact from groups: %w", err)
+	}
+	for _, groupID := range groupIDs {
+		_, err := tx.Exec(`
+			INSERT IGNORE INTO contact_group_member (
+				group_id, contact_id, dt_store
+			) VALUES (
+				?, ?, ?
+			)
+		`, groupID, contactID, now)
+		if err != nil {
+			return fmt.Errorf("error adding contact to group %d: %w", groupID, err)
+		}
+	}
+	return nil
+}
+
+// handleListContacts lists the contacts of the authenticated user in the order
+// they were created. Filters: phone (start of the number), name (part of the
+// name) and group_id. Pages continue after the id given in "after".
+func (s *Server) handleListContacts(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	query := r.URL.Query()
+	filter := listFilter{}
+	filter.add("c.owner = ?", username)
+
+	if prefix := strings.TrimPrefix(query.Get("phone"), "+"); prefix != "" {
+		filter.add("c.phone LIKE ?", prefix+"%")
+	}
+	if name := query.Get("name"); name != "" {
+		filter.add("c.name LIKE ?", "%"+name+"%")
+	}
+	if groupIDStr := query.Get("group_id"); groupIDStr != "" {
+		groupID, err := strconv.Atoi(groupIDStr)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid group_id parameter", "")
+			return
+		}
+		filter.add("c.id IN (SELECT contact_id FROM contact_group_member WHERE group_id = ?)", groupID)
+	}
+	if afterStr := query.Get("after"); afterStr != "" {
+		after, err := strconv.Atoi(afterStr)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid after parameter", "")
+			return
+		}
+		filter.add("c.id > ?", after)
+	}
+
+	limit := defaultListLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid limit parameter",
+				fmt.Sprintf("Limit must be between 1 and %d", maxListLimit))
+			return
+		}
+	}
+
+	rows, err := s.db.Query("SELECT "+contactColumns+" FROM contact c"+filter.where()+" ORDER BY c.id LIMIT ?",
+		append(filter.args, limit)...)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying contacts: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	contactList := []*models.Contact{}
+	for rows.Next() {
+		c, err := scanContact(rows)
+		if err != nil {
+			continue // Skip this row and continue with the next
+		}
+		contactList = append(contactList, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating contacts: %v", err))
+		return
+	}
+
+	if err := s.fillContactGroups(contactList); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, contactList)
+}
+
+// handleGetContact returns a single contact
+func (s *Server) handleGetContact(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	contactID, ok := contactIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	c, err := s.loadContact(username, contactID)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Contact not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading contact: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, c)
+}
+
+// handleCreateContact stores a new contact, optionally in some groups
+func (s *Server) handleCreateContact(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	contactReq, ok := s.decodeContactRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if _, ok := s.checkGroups(w, username, contactReq.GroupIDs); !ok {
+		return
+	}
+
+	attributesJSON, err := json.Marshal(contactReq.Attributes)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error processing request", "")
+		return
+	}
+
+	now := time.Now()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error beginning transaction: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO contact (
+			owner, phone, name, attributes, dt_store
+		) VALUES (
+			?, ?, ?, ?, ?
+		)
+	`, username, contactReq.Phone, nullString(contactReq.Name), attributesJSON, now)
+	if isDuplicateKey(err) {
+		sendErrorResponse(w, http.StatusConflict, "Contact already exists", fmt.Sprintf("There is already a contact for %s", contactReq.Phone))
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting contact: %v", err))
+		return
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", "Error retrieving contact ID")
+		return
+	}
+
+	if err := setContactGroups(tx, int(lastID), contactReq.GroupIDs, now); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error committing contact: %v", err))
+		return
+	}
+
+	c, err := s.loadContact(username, int(lastID))
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading contact: %v", err))
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/contacts/%d", c.ID))
+	sendJSONResponse(w, http.StatusCreated, c)
+}
+
+// handleUpdateContact replaces the phone, name and attributes of a contact.
+// Its groups are replaced when group_ids is given and kept otherwise.
+func (s *Server) handleUpdateContact(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	contactID, ok := contactIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	contactReq, ok := s.decodeContactRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if _, ok := s.checkGroups(w, username, contactReq.GroupIDs); !ok {
+		return
+	}
+
+	attributesJSON, err := json.Marshal(contactReq.Attributes)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error processing request", "")
+		return
+	}
+
+	now := time.Now()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error beginning transaction: %v", err))
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the contact so its groups are replaced by one update at a time
+	var exists int
+	err = tx.QueryRow("SELECT 1 FROM contact WHERE id = ? AND owner = ? FOR UPDATE", contactID, username).Scan(&exists)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Contact not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading contact: %v", err))
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE contact
+		SET phone = ?,
+			name = ?,
+			attributes = ?,
+			dt_update = ?
+		WHERE id = ?
+	`, contactReq.Phone, nullString(contactReq.Name), attributesJSON, now, contactID)
+	if isDuplicateKey(err) {
+		sendErrorResponse(w, http.StatusConflict, "Contact already exists", fmt.Sprintf("There is already a contact for %s", contactReq.Phone))
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error updating contact: %v", err))
+		return
+	}
+
+	if contactReq.GroupIDs != nil {
+		if err := setContactGroups(tx, contactID, contactReq.GroupIDs, now); err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error committing contact: %v", err))
+		return
+	}
+
+	c, err := s.loadContact(username, contactID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading contact: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, c)
+}
+
+// handleDeleteContact deletes a contact and removes it from its groups.
+// Broadcasts already expanded keep their messages.
+func (s *Server) handleDeleteContact(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	contactID, ok := contactIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec("DELETE FROM contact WHERE id = ? AND owner = ?", contactID, username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error deleting contact: %v", err))
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Contact not found", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleImportContacts creates or updates contacts from a CSV or XLSX file in
+// the "file" part of a multipart form. The phone column is picked as for a
+// recipient upload, or named by "phone_column"; a column called name (or
+// "name_column") holds the name and every other column becomes an attribute
+// named after its header. An existing contact gets the new name and its
+// attributes merged with the file's. With "group_id" every imported contact
+// is also added to that group.
+func (s *Server) handleImportContacts(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	if !parseUploadForm(w, r, maxContactImportBytes) {
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	var groupID int
+	if groupIDStr := r.FormValue("group_id"); groupIDStr != "" {
+		var err error
+		if groupID, err = strconv.Atoi(groupIDStr); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid group_id", "")
+			return
+		}
+		if _, ok := s.checkGroups(w, username, []int{groupID}); !ok {
+			return
+		}
+	}
+
+	rows, headerRow, columns, ok := readUploadFile(w, r, maxContactImportRows, "contacts")
+	if !ok {
+		return
+	}
+
+	phoneIndex, ok := pickPhoneColumn(w, columns, r.FormValue("phone_column"))
+	if !ok {
+		return
+	}
+
+	nameIndex := -1
+	nameColumn := r.FormValue("name_column")
+	for i, column := range columns {
+		if i == phoneIndex {
+			continue
+		}
+		if (nameColumn != "" && strings.EqualFold(column, nameColumn)) ||
+			(nameColumn == "" && containsString(nameHeaders, variableName(column))) {
+			nameIndex = i
+			break
+		}
+	}
+	if nameColumn != "" && nameIndex == -1 {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid name_column",
+			fmt.Sprintf("No column %q; the file has %s", nameColumn, strings.Join(columns, ", ")))
+		return
+	}
+
+	attributeNames := make(map[int]string)
+	for i, column := range columns {
+		if name := variableName(column); i != phoneIndex && i != nameIndex && name != "" {
+			attributeNames[i] = name
+		}
+	}
+	if len(attributeNames) > maxContactAttributes {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid file",
+			fmt.Sprintf("A contact can have at most %d attributes", maxContactAttributes))
+		return
+	}
+
+	now := time.Now()
+	var errorRows []models.RecipientError
+	importResp := models.ContactImportResponse{}
+	for i := headerRow + 1; i < len(rows); i++ {
+		row := rows[i]
+		if len(row) == 0 {
+			continue // Blank row
+		}
+
+		raw := cell(row, phoneIndex)
+		normalized, err := phone.Normalize(raw, s.cfg.Phone.DefaultCountry)
+		if err != nil {
+			errorRows = append(errorRows, models.RecipientError{Index: i + 1, Phone: raw, Error: err.Error()})
+			continue
+		}
+
+		var name string
+		if nameIndex != -1 {
+			name = cell(row, nameIndex)
+			if len(name) > maxContactNameLength {
+				errorRows = append(errorRows, models.RecipientError{Index: i + 1, Phone: raw,
+					Error: fmt.Sprintf("Name must be at most %d characters", maxContactNameLength)})
+				continue
+			}
+		}
+
+		// An empty cell leaves the attribute as it was
+		attributes := make(map[string]string, len(attributeNames))
+		for index, attribute := range attributeNames {
+			if value := cell(row, index); value != "" {
+				attributes[attribute] = value
+			}
+		}
+		if err := validateAttributes(attributes); err != nil {
+			errorRows = append(errorRows, models.RecipientError{Index: i + 1, Phone: raw, Error: err.Error()})
+			continue
+		}
+		attributesJSON, err := json.Marshal(attributes)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Error processing request", "")
+			return
+		}
+
+		// LAST_INSERT_ID(id) makes the id of an updated row available too
+		res, err := s.db.Exec(`
+			INSERT INTO contact (
+				owner, phone, name, attributes, dt_store
+			) VALUES (
+				?, ?, ?, ?, ?
+			) ON DUPLICATE KEY UPDATE
+				id = LAST_INSERT_ID(id),
+				name = COALESCE(VALUES(name), name),
+				attributes = JSON_MERGE_PATCH(COALESCE(attributes, JSON_OBJECT()), VALUES(attributes)),
+				dt_update = VALUES(dt_store)
+		`, username, normalized, nullString(name), attributesJSON, now)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error importing contact: %v", err))
+			return
+		}
+
+		// One row affected for an insert, two for an update
+		if affected, _ := res.RowsAffected(); affected == 1 {
+			importResp.Created++
+		} else {
+			importResp.Updated++
+		}
+
+		if groupID != 0 {
+			contactID, err := res.LastInsertId()
+			if err != nil {
+				sendErrorResponse(w, http.StatusInternalServerError, "Database error", "Error retrieving contact ID")
+				return
+			}
+			_, err = s.db.Exec(`
+				INSERT IGNORE INTO contact_group_member (
+					group_id, contact_id, dt_store
+				) VALUES (
+					?, ?, ?
+				)
+			`, groupID, contactID, now)
+			if err != nil {
+				sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error adding contact to group: %v", err))
+				return
+			}
+		}
+	}
+
+	importResp.ErrorCount = len(errorRows)
+	importResp.ErrorRows = firstErrorRows(errorRows)
+
+	sendJSONResponse(w, http.StatusOK, importResp)
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// Gateway limits for message content
+const (
+	maxFilenameLength = 240
+	
+	maxInteractiveHeader = 60
+	maxInteractiveFooter = 60
+	maxButtons           = 3
+	maxButtonTitle       = 20
+	maxButtonID          = 256
+	maxListButtonText    = 20
+	maxListSections      = 10
+	maxListRows          = 10 // Across all sections
+	maxSectionTitle      = 24
+	maxRowTitle          = 24
+	maxRowDescription    = 72
+	maxRowID             = 200
+)
+
+// mediaMimePrefixes lists the MIME type families accepted per media type.
+// Documents accept any MIME type.
+var mediaMimePrefixes = map[models.ContentType]string{
+	models.ContentImage: "image/",
+	models.ContentAudio: "audio/",
+	models.ContentVideo: "video/",
+}
+
+// normalizeContent validates the content of a send request. It returns the
+// content type, the message text to store and the payload to store. The
+// caption of a media message is moved into the message text so it can be
+// personalized like any other message.
+func normalizeContent(contentType models.ContentType, message string, media *models.MediaPayload, location *models.LocationPayload, interactive *models.InteractivePayload) (models.ContentType, string, *models.MessagePayload, error) {
+	if contentType == "" {
+		contentType = models.ContentText
+	}
+	
+	if interactive != nil && contentType != models.ContentInteractive {
+		return "", "", nil, fmt.Errorf("%s messages cannot carry interactive content", contentType)
+	}
+	
+	switch contentType {
+	case models.ContentText:
+		if media != nil || location != nil {
+			return "", "", nil, fmt.Errorf("text messages cannot carry media or location")
+		}
+		if message == "" {
+			return "", "", nil, fmt.Errorf("message is required for text messages")
+		}
+		return contentType, message, nil, nil
+		
+	case models.ContentImage, models.ContentDocument, models.ContentAudio, models.ContentVideo:
+		if location != nil {
+			return "", "", nil, fmt.Errorf("%s messages cannot carry a location", contentType)
+		}
+		if media == nil {
+			return "", "", nil, fmt.Errorf("media is required for %s messages", contentType)
+		}
+		
+		stored := *media
+		if err := validateMedia(contentType, &stored); err != nil {
+			return "", "", nil, err
+		}
+		
+		if message == "" {
+			message = stored.Caption
+		}
+		stored.Caption = ""
+		
+		if contentType == models.ContentAudio && message != "" {
+			return "", "", nil, fmt.Errorf("audio messages cannot have a caption")
+		}
+		if err := contentType.CheckText(message); err != nil {
+			return "", "", nil, err
+		}
+		
+		return contentType, message, &models.MessagePayload{Media: &stored}, nil
+		
+	case models.ContentLocation:
+		if media != nil {
+			return "", "", nil, fmt.Errorf("location messages cannot carry media")
+		}
+		if location == nil {
+			return "", "", nil, fmt.Errorf("location is required for location messages")
+		}
+		if location.Latitude < -90 || location.Latitude > 90 {
+			return "", "", nil, fmt.Errorf("latitude must be between -90 and 90")
+		}
+		if location.Longitude < -180 || location.Longitude > 180 {
+			return "", "", nil, fmt.Errorf("longitude must be between -180 and 180")
+		}
+		
+		stored := *location
+		return contentType, message, &models.MessagePayload{Location: &stored}, nil
+		
+	case models.ContentInteractive:
+		if media != nil || location != nil {
+			return "", "", nil, fmt.Errorf("interactive messages cannot carry media or location")
+		}
+		if interactive == nil {
+			return "", "", nil, fmt.Errorf("interactive is required for interactive messages")
+		}
+		if message == "" {
+			return "", "", nil, fmt.Errorf("message is required as the body of interactive messages")
+		}
+		if err := contentType.CheckText(message); err != nil {
+			return "", "", nil, err
+		}
+		if err := validateInteractive(interactive); err != nil {
+			return "", "", nil, err
+		}
+		
+		stored := *interactive
+		return contentType, message, &models.MessagePayload{Interactive: &stored}, nil
+	}
+	
+	return "", "", nil, fmt.Errorf("unknown message type %q", contentType)
+}
+
+// validateMedia checks the media part of an image, document, audio or video message
+func validateMedia(contentType models.ContentType, media *models.MediaPayload) error {
+	if media.AttachmentID != "" {
+		if media.URL != "" {
+			return fmt.Errorf("media.url and media.attachment_id are mutually exclusive")
+		}
+		// Type and filename are checked against the stored attachment by the handler
+		return nil
+	}
+	
+	if media.URL == "" {
+		return fmt.Errorf("media.url or media.attachment_id is required")
+	}
+	
+	parsed, err := url.Parse(media.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("media.url must be an absolute http(s) URL")
+	}
+	
+	if media.MimeType != "" {
+		media.MimeType = strings.ToLower(media.MimeType)
+		if prefix, ok := mediaMimePrefixes[contentType]; ok && !strings.HasPrefix(media.MimeType, prefix) {
+			return fmt.Errorf("mime_type %q does not match message type %s", media.MimeType, contentType)
+		}
+	}
+	
+	if contentType == models.ContentDocument && media.Filename == "" {
+		// Fall back to the last path segment so the recipient sees a sensible name
+		segments := strings.Split(strings.TrimRight(parsed.Path, "/"), "/")
+		media.Filename = segments[len(segments)-1]
+	}
+	if len(media.Filename) > maxFilenameLength {
+		return fmt.Errorf("filename must be at most %d characters", maxFilenameLength)
+	}
+	
+	return nil
+}
+
+// validateInteractive checks buttons and list menus against the gateway limits
+func validateInteractive(interactive *models.InteractivePayload) error {
+	if err := maxLength("interactive.header", interactive.Header, maxInteractiveHeader); err != nil {
+		return err
+	}
+	if err := maxLength("interactive.footer", interactive.Footer, maxInteractiveFooter); err != nil {
+		return err
+	}
+	
+	ids := make(map[string]bool)
+	uniqueID := func(field, id string, limit int) error {
+		if id == "" {
+			return fmt.Errorf("%s.id is required", field)
+		}
+		if err := maxLength(field+".id", id, limit); err != nil {
+			return err
+		}
+		if ids[id] {
+			return fmt.Errorf("%s.id %q is used more than once", field, id)
+		}
+		ids[id] = true
+		return nil
+	}
+	
+	switch interactive.Kind {
+	case models.InteractiveButtons:
+		if len(interactive.Sections) > 0 || interactive.ButtonText != "" {
+			return fmt.Errorf("button messages cannot have sections or button_text")
+		}
+		if len(interactive.Buttons) == 0 || len(interactive.Buttons) > maxButtons {
+			return fmt.Errorf("button messages need 1 to %d buttons", maxButtons)
+		}
+		for i, button := range interactive.Buttons {
+			field := fmt.Sprintf("interactive.buttons[%d]", i)
+			if err := uniqueID(field, button.ID, maxButtonID); err != nil {
+				return err
+			}
+			if err := requiredMaxLength(field+".title", button.Title, maxButtonTitle); err != nil {
+				return err
+			}
+		}
+		return nil
+		
+	case models.InteractiveList:
+		if len(interactive.Buttons) > 0 {
+			return fmt.Errorf("list messages cannot have buttons")
+		}
+		if err := requiredMaxLength("interactive.button_text", interactive.ButtonText, maxListButtonText); err != nil {
+			return err
+		}
+		if len(interactive.Sections) == 0 || len(interactive.Sections) > maxListSections {
+			return fmt.Errorf("list messages need 1 to %d sections", maxListSections)
+		}
+		
+		rows := 0
+		for i, section := range interactive.Sections {
+			field := fmt.Sprintf("interactive.sections[%d]", i)
+			if len(interactive.Sections) > 1 && section.Title == "" {
+				return fmt.Errorf("%s.title is required when there is more than one section", field)
+			}
+			if err := maxLength(field+".title", section.Title, maxSectionTitle); err != nil {
+				return err
+			}
+			if len(section.Rows) == 0 {
+				return fmt.Errorf("%s needs at least one row", field)
+			}
+			
+			for j, row := range section.Rows {
+				rowField := fmt.Sprintf("%s.rows[%d]", field, j)
+				if err := uniqueID(rowField, row.ID, maxRowID); err != nil {
+					return err
+				}
+				if err := requiredMaxLength(rowField+".title", row.Title, maxRowTitle); err != nil {
+					return err
+				}
+				if err := maxLength(rowField+".description", row.Description, maxRowDescription); err != nil {
+					return err
+				}
+			}
+			rows += len(section.Rows)
+		}
+		
+		if rows > maxListRows {
+			return fmt.Errorf("list messages can have at most %d rows in total", maxListRows)
+		}
+		return nil
+	}
+	
+	return fmt.Errorf("interactive.kind must be %q or %q", models.InteractiveButtons, models.InteractiveList)
+}
+
+// maxLength checks the length of an optional text field in characters
+func maxLength(field, value string, limit int) error {
+	if len([]rune(value)) > limit {
+		return fmt.Errorf("%s must be at most %d characters", field, limit)
+	}
+	return nil
+}
+
+// requiredMaxLength checks a required text field
+func requiredMaxLength(field, value string, limit int) error {
+	if value == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+	return maxLength(field, value, limit)
+}
+
+// encodePayload converts a payload to the value stored in the payload column
+func encodePayload(payload *models.MessagePayload) ([]byte, error) {
+	if payload == nil {
+		return nil, nil
+	}
+	return json.Marshal(payload)
+}
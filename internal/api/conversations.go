@@ -0,0 +1,185 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/phone"
+)
+
+// Page sizes of the conversation timeline
+const (
+	defaultConversationLimit = 50
+	maxConversationLimit     = 200
+)
+
+// timelineCursor is the oldest item of a conversation page; the next page
+// starts right before it. The timeline is ordered newest first by
+// (timestamp, direction, id), as ids of the two tables can be equal.
+type timelineCursor struct {
+	Timestamp time.Time
+	Direction string
+	ID        int
+}
+
+// encodeTimelineCursor turns a cursor into an opaque query parameter value
+func encodeTimelineCursor(c timelineCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d,%s,%d", c.Timestamp.Unix(), c.Direction, c.ID)))
+}
+
+// decodeTimelineCursor parses a cursor produced by encodeTimelineCursor
+func decodeTimelineCursor(value string) (*timelineCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(raw), ",", 3)
+	if len(parts) != 3 || (parts[1] != models.DirectionOutbound && parts[1] != models.DirectionInbound) {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	return &timelineCursor{Timestamp: time.Unix(unix, 0), Direction: parts[1], ID: id}, nil
+}
+
+// before returns the filter and arguments that keep the items of one
+// direction that come after the cursor, newest first
+func (c *timelineCursor) before(direction, column string) (string, []interface{}) {
+	switch {
+	case direction == c.Direction:
+		return " AND (" + column + " < ? OR (" + column + " = ? AND id < ?))", []interface{}{c.Timestamp, c.Timestamp, c.ID}
+	case direction < c.Direction:
+		// Sorted after the cursor's direction at the same time
+		return " AND " + column + " <= ?", []interface{}{c.Timestamp}
+	}
+	return " AND " + column + " < ?", []interface{}{c.Timestamp}
+}
+
+// handleGetConversation merges the messages sent to a recipient and the
+// messages received from them into one timeline. The newest page is returned
+// first; older pages are fetched with the next_cursor of the previous one.
+func (s *Server) handleGetConversation(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	recipient, err := phone.Normalize(mux.Vars(r)["phone"], s.cfg.Phone.DefaultCountry)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid phone number", err.Error())
+		return
+	}
+
+	limit := defaultConversationLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxConversationLimit {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid limit parameter",
+				fmt.Sprintf("Limit must be between 1 and %d", maxConversationLimit))
+			return
+		}
+	}
+
+	outboundArgs := []interface{}{username, recipient}
+	inboundArgs := []interface{}{username, recipient}
+	outboundFilter, inboundFilter := "", ""
+
+	// Without a cursor the page ends at the newest item
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		cursor, err := decodeTimelineCursor(cursorStr)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid cursor parameter", "")
+			return
+		}
+		var filterArgs []interface{}
+		outboundFilter, filterArgs = cursor.before(models.DirectionOutbound, "COALESCE(dt_send, dt_queue)")
+		outboundArgs = append(outboundArgs, filterArgs...)
+		inboundFilter, filterArgs = cursor.before(models.DirectionInbound, "dt_receive")
+		inboundArgs = append(inboundArgs, filterArgs...)
+	}
+
+	args := append(outboundArgs, inboundArgs...)
+	args = append(args, limit+1)
+
+	// Outbound messages are placed at their send time, or their queue time while not sent yet
+	rows, err := s.db.Query(`
+		SELECT direction, id, type, message, status, payload, reply_id, reply_title, ts
+		FROM (
+			SELECT 'outbound' AS direction, id, content_type AS type, message, status, payload,
+				NULL AS reply_id, NULL AS reply_title, COALESCE(dt_send, dt_queue) AS ts
+			FROM message
+			WHERE sender = ? AND recipient = ?`+outboundFilter+`
+			UNION ALL
+			SELECT 'inbound' AS direction, id, type, message, NULL AS status, NULL AS payload,
+				reply_id, reply_title, dt_receive AS ts
+			FROM inbound_message
+			WHERE sender = ? AND recipient = ?`+inboundFilter+`
+		) timeline
+		ORDER BY ts DESC, direction DESC, id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying conversation: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	items := make([]*models.ConversationItem, 0, limit+1)
+	for rows.Next() {
+		var item models.ConversationItem
+		var message, status, replyID, replyTitle sql.NullString
+		var payloadJSON []byte
+
+		if err := rows.Scan(&item.Direction, &item.ID, &item.Type, &message, &status, &payloadJSON,
+			&replyID, &replyTitle, &item.Timestamp); err != nil {
+			continue // Skip this row and continue with the next
+		}
+
+		item.Message = message.String
+		item.Status = models.MessageStatus(status.String)
+		if payloadJSON != nil {
+			_ = json.Unmarshal(payloadJSON, &item.Payload)
+		}
+		if replyID.Valid {
+			item.Reply = &models.InteractiveReply{ID: replyID.String, Title: replyTitle.String}
+		}
+
+		items = append(items, &item)
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating conversation: %v", err))
+		return
+	}
+
+	convResp := models.ConversationResponse{
+		Recipient: recipient,
+		HasMore:   len(items) > limit,
+	}
+	if convResp.HasMore {
+		items = items[:limit]
+		last := items[limit-1]
+		convResp.NextCursor = encodeTimelineCursor(timelineCursor{Timestamp: last.Timestamp, Direction: last.Direction, ID: last.ID})
+	}
+
+	// Oldest first, the way a chat is read
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	convResp.Items = items
+
+	sendJSONResponse(w, http.StatusOK, convResp)
+}
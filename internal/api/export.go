@@ -0,0 +1,145 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/export"
+)
+
+// parseExportOptions reads format, columns and tz
+func parseExportOptions(w http.ResponseWriter, query url.Values) (*export.Options, bool) {
+	opts := &export.Options{
+		Format: query.Get("format"),
+		TZ:     query.Get("tz"),
+	}
+	if columnsStr := query.Get("columns"); columnsStr != "" {
+		opts.Columns = strings.Split(columnsStr, ",")
+	}
+
+	if err := opts.Validate(); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid export parameters", err.Error())
+		return nil, false
+	}
+	return opts, true
+}
+
+// exportFilename names an export after what it holds and when it was made
+func exportFilename(prefix string, opts *export.Options) string {
+	loc, err := opts.Location()
+	if err != nil {
+		loc = time.Local // Validate rejects unknown zones
+	}
+	return prefix + "-" + time.Now().In(loc).Format("20060102-150405") + "." + opts.Format
+}
+
+// startedWriter remembers whether anything was written to the response
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (sw *startedWriter) Write(p []byte) (int, error) {
+	sw.started = true
+	return sw.ResponseWriter.Write(p)
+}
+
+// streamExport writes the messages of q to the response as they are read.
+// Once the first byte is sent the status cannot change anymore, so later
+// errors are logged and the download ends early.
+func (s *Server) streamExport(w http.ResponseWriter, r *http.Request, q export.MessageQuery, opts *export.Options, filename string) {
+	// Large exports take longer than the server write timeout allows
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Error lifting write deadline for export %s: %v", filename, err)
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(opts.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	// Hand rows to the client as they come instead of buffering the file
+	out := &startedWriter{ResponseWriter: w}
+	_, err := export.WriteMessages(r.Context(), s.db, out, q, *opts, func(int) error {
+		return rc.Flush()
+	})
+	if err == nil {
+		return
+	}
+
+	if !out.started {
+		w.Header().Del("Content-Disposition")
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	}
+	log.Printf("Error writing export %s: %v", filename, err)
+}
+
+// handleExportMessages exports the message history with the listing filters,
+// oldest first and without a row limit
+func (s *Server) handleExportMessages(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	opts, ok := parseExportOptions(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	_, q, ok := s.parseMessageFilters(w, username, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	s.streamExport(w, r, q, opts, exportFilename("messages", opts))
+}
+
+// handleExportBroadcastDetails exports the messages of one bulk message
+func (s *Server) handleExportBroadcastDetails(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	bulkID, err := strconv.Atoi(mux.Vars(r)["bulk_id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid bulk_id parameter", "")
+		return
+	}
+
+	opts, ok := parseExportOptions(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	if !s.checkBulkOwner(w, bulkID, username) {
+		return
+	}
+
+	q := export.MessageQuery{Sender: username, BulkID: bulkID}
+	s.streamExport(w, r, q, opts, fmt.Sprintf("broadcast-%d.%s", bulkID, opts.Format))
+}
+
+// checkBulkOwner makes sure a bulk message exists and belongs to username
+func (s *Server) checkBulkOwner(w http.ResponseWriter, bulkID int, username string) bool {
+	var bulkSender string
+	err := s.db.QueryRow("SELECT sender FROM message_bulk WHERE id = ?", bulkID).Scan(&bulkSender)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Bulk message not found", "")
+		return false
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error checking bulk message: %v", err))
+		return false
+	}
+
+	if bulkSender != username {
+		sendErrorResponse(w, http.StatusForbidden, "Access denied", "You can only view your own bulk messages")
+		return false
+	}
+	return true
+}
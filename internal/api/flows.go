@@ -0,0 +1,370 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/flow"
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// maxFlowNameLength matches the flow.name column
+const maxFlowNameLength = 100
+
+// flowColumns is the column list scanned by scanFlow
+const flowColumns = `id, name, definition, position, enabled, dt_store, dt_update`
+
+// scanFlow scans a flow selected with flowColumns
+func scanFlow(row interface{ Scan(...interface{}) error }) (*models.Flow, error) {
+	var f models.Flow
+	var definitionJSON []byte
+	var dtUpdate sql.NullTime
+
+	if err := row.Scan(&f.ID, &f.Name, &definitionJSON, &f.Position, &f.Enabled, &f.DTStore, &dtUpdate); err != nil {
+		return nil, err
+	}
+
+	f.Definition = json.RawMessage(definitionJSON)
+	if dtUpdate.Valid {
+		f.DTUpdate = &dtUpdate.Time
+	}
+
+	return &f, nil
+}
+
+// decodeFlowRequest decodes and validates a flow create or update request
+func decodeFlowRequest(w http.ResponseWriter, r *http.Request) (*models.FlowRequest, bool) {
+	var flowReq models.FlowRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&flowReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return nil, false
+	}
+
+	if flowReq.Name == "" || len(flowReq.Definition) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Name and definition are required")
+		return nil, false
+	}
+
+	if len(flowReq.Name) > maxFlowNameLength {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid name", fmt.Sprintf("Name must be at most %d characters", maxFlowNameLength))
+		return nil, false
+	}
+
+	if _, err := flow.Parse(flowReq.Definition); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid flow", err.Error())
+		return nil, false
+	}
+
+	if flowReq.Enabled == nil {
+		enabled := true
+		flowReq.Enabled = &enabled
+	}
+
+	return &flowReq, true
+}
+
+// flowIDFromPath parses the {id} route variable
+func flowIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	flowID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid flow id", "")
+		return 0, false
+	}
+	return flowID, true
+}
+
+// handleListFlows lists the flows of the authenticated user
+func (s *Server) handleListFlows(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	rows, err := s.db.Query("SELECT "+flowColumns+" FROM flow WHERE owner = ? ORDER BY position, id", username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying flows: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	flows := []*models.Flow{}
+	for rows.Next() {
+		f, err := scanFlow(rows)
+		if err != nil {
+			continue // Skip this row and continue with the next
+		}
+		flows = append(flows, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating flows: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, flows)
+}
+
+// handleGetFlow returns a single flow
+func (s *Server) handleGetFlow(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	flowID, ok := flowIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := scanFlow(s.db.QueryRow("SELECT "+flowColumns+" FROM flow WHERE id = ? AND owner = ?", flowID, username))
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Flow not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading flow: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, f)
+}
+
+// handleCreateFlow creates a flow
+func (s *Server) handleCreateFlow(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	flowReq, ok := decodeFlowRequest(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec(`
+		INSERT INTO flow (
+			owner, name, definition, position, enabled, dt_store
+		) VALUES (
+			?, ?, ?, ?, ?, ?
+		)
+	`, username, flowReq.Name, []byte(flowReq.Definition), flowReq.Position, *flowReq.Enabled, time.Now())
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting flow: %v", err))
+		return
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", "Error retrieving flow ID")
+		return
+	}
+
+	f, err := scanFlow(s.db.QueryRow("SELECT "+flowColumns+" FROM flow WHERE id = ?", lastID))
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading flow: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, f)
+}
+
+// handleUpdateFlow replaces a flow. Sessions already running carry on in the
+// new definition; a session whose state no longer exists starts over.
+func (s *Server) handleUpdateFlow(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	flowID, ok := flowIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	flowReq, ok := decodeFlowRequest(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec(`
+		UPDATE flow
+		SET name = ?,
+			definition = ?,
+			position = ?,
+			enabled = ?,
+			dt_update = ?
+		WHERE id = ? AND owner = ?
+	`, flowReq.Name, []byte(flowReq.Definition), flowReq.Position, *flowReq.Enabled, time.Now(), flowID, username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error updating flow: %v", err))
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Flow not found", "")
+		return
+	}
+
+	f, err := scanFlow(s.db.QueryRow("SELECT "+flowColumns+" FROM flow WHERE id = ?", flowID))
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading flow: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, f)
+}
+
+// handleDeleteFlow deletes a flow together with its sessions
+func (s *Server) handleDeleteFlow(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	flowID, ok := flowIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec("DELETE FROM flow WHERE id = ? AND owner = ?", flowID, username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error deleting flow: %v", err))
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Flow not found", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runFlow feeds an inbound message to the recipient's open flow session, or
+// starts the first enabled flow it triggers. The flow's messages are queued at
+// high priority, one second apart so they arrive in order. The flow ID and the
+// first queued message ID are returned; handled is false when no flow applies.
+func (s *Server) runFlow(event models.InboundEvent, input string) (flowID int, messageID int, handled bool, err error) {
+	now := time.Now()
+
+	var result *flow.Result
+	var dtStart time.Time
+
+	// A session still open takes the message
+	var definitionJSON, varsJSON []byte
+	var session flow.Session
+	err = s.db.QueryRow(`
+		SELECT fs.flow_id, fs.state, fs.vars, fs.dt_start, f.definition
+		FROM flow_session fs
+		JOIN flow f ON f.id = fs.flow_id
+		WHERE fs.sender = ? AND fs.recipient = ? AND fs.dt_expire > ? AND f.enabled = TRUE
+	`, event.Sender, event.From, now).Scan(&flowID, &session.State, &varsJSON, &dtStart, &definitionJSON)
+	switch {
+	case err == nil:
+		def, err := flow.Parse(definitionJSON)
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("error loading flow %d: %w", flowID, err)
+		}
+		if varsJSON != nil {
+			_ = json.Unmarshal(varsJSON, &session.Vars)
+		}
+		result, err = def.Step(session, input)
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("error running flow %d: %w", flowID, err)
+		}
+
+	case err == sql.ErrNoRows:
+		flowID, result, err = s.startFlow(event, input)
+		if err != nil || result == nil {
+			return 0, 0, false, err
+		}
+		dtStart = now
+
+	default:
+		return 0, 0, false, fmt.Errorf("error looking up flow session: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, content := range result.Messages {
+		id, err := insertReply(tx, event.Sender, event.From, content, sql.NullInt64{}, sql.NullInt64{},
+			now.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			return 0, 0, false, err
+		}
+		if messageID == 0 {
+			messageID = id
+		}
+	}
+
+	if result.Ended {
+		if _, err := tx.Exec("DELETE FROM flow_session WHERE sender = ? AND recipient = ?", event.Sender, event.From); err != nil {
+			return 0, 0, false, fmt.Errorf("error ending flow session: %w", err)
+		}
+	} else {
+		varsJSON, err := json.Marshal(result.Session.Vars)
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("error encoding flow variables: %w", err)
+		}
+
+		// Expired sessions of the recipient are replaced in place
+		if _, err := tx.Exec(`
+			INSERT INTO flow_session (
+				flow_id, sender, recipient, state, vars, dt_start, dt_update, dt_expire
+			) VALUES (
+				?, ?, ?, ?, ?, ?, ?, ?
+			)
+			ON DUPLICATE KEY UPDATE
+				flow_id = VALUES(flow_id),
+				state = VALUES(state),
+				vars = VALUES(vars),
+				dt_start = VALUES(dt_start),
+				dt_update = VALUES(dt_update),
+				dt_expire = VALUES(dt_expire)
+		`, flowID, event.Sender, event.From, result.Session.State, varsJSON, dtStart, now,
+			now.Add(result.Timeout)); err != nil {
+			return 0, 0, false, fmt.Errorf("error saving flow session: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, false, fmt.Errorf("error committing flow step: %w", err)
+	}
+
+	return flowID, messageID, true, nil
+}
+
+// startFlow begins the first enabled flow of the sender that the input
+// triggers. A nil result means no flow was triggered.
+func (s *Server) startFlow(event models.InboundEvent, input string) (int, *flow.Result, error) {
+	rows, err := s.db.Query("SELECT id, definition FROM flow WHERE owner = ? AND enabled = TRUE ORDER BY position, id", event.Sender)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error querying flows: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var flowID int
+		var definitionJSON []byte
+		if err := rows.Scan(&flowID, &definitionJSON); err != nil {
+			continue // Skip this row and continue with the next
+		}
+
+		def, err := flow.Parse(definitionJSON)
+		if err != nil || !def.Triggered(input) {
+			continue
+		}
+
+		result, err := def.Begin(map[string]string{flow.PhoneVariable: event.From})
+		if err != nil {
+			return 0, nil, fmt.Errorf("error starting flow %d: %w", flowID, err)
+		}
+		return flowID, result, nil
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error iterating flows: %w", err)
+	}
+
+	return 0, nil, nil
+}
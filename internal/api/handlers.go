@@ -10,7 +10,11 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/contacts"
 	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/msgtemplate"
+	"github.com/partadox/wags_queue/internal/phone"
+	"github.com/partadox/wags_queue/internal/schedule"
 )
 
 // sendJSONResponse sends a JSON response
@@ -32,6 +36,50 @@ func sendErrorResponse(w http.ResponseWriter, statusCode int, message string, de
 	sendJSONResponse(w, statusCode, errorResp)
 }
 
+// sendRecipientErrorResponse rejects a request with a per-recipient error report
+func sendRecipientErrorResponse(w http.ResponseWriter, recipientErrors []models.RecipientError) {
+	errorResp := models.ErrorResponse{
+		Error:           "Invalid recipients",
+		Details:         fmt.Sprintf("%d recipient(s) rejected", len(recipientErrors)),
+		RecipientErrors: recipientErrors,
+	}
+	sendJSONResponse(w, http.StatusBadRequest, errorResp)
+}
+
+// validateRecipients checks that every recipient has a value for each
+// variable used in the message template, either its own or one of the shared
+// defaults, and that its rendered message stays within the gateway limits.
+// Phone numbers are checked separately by cleanRecipients.
+func validateRecipients(contentType models.ContentType, message string, defaults map[string]string, recipients []models.Recipient) []models.RecipientError {
+	recipientErrors := make([]models.RecipientError, 0)
+	for i, recipient := range recipients {
+		vars := msgtemplate.Merge(defaults, recipient.Vars)
+		if missing := msgtemplate.Missing(message, vars); len(missing) > 0 {
+			recipientErrors = append(recipientErrors, models.RecipientError{
+				Index:   i,
+				Phone:   recipient.Phone,
+				Error:   "Missing template variables",
+				Missing: missing,
+			})
+			continue
+		}
+		
+		// Variables can push the rendered text past the gateway limits
+		content, err := msgtemplate.Render(message, vars)
+		if err == nil {
+			err = contentType.CheckText(content)
+		}
+		if err != nil {
+			recipientErrors = append(recipientErrors, models.RecipientError{
+				Index: i,
+				Phone: recipient.Phone,
+				Error: err.Error(),
+			})
+		}
+	}
+	return recipientErrors
+}
+
 // handleLogin handles user authentication
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var loginReq models.LoginRequest
@@ -85,8 +133,82 @@ func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Validate request
-	if msgReq.Recipient == "" || msgReq.Message == "" {
-		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Recipient and message are required")
+	if msgReq.Recipient == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Recipient is required")
+		return
+	}
+	
+	// Store every recipient in the same E.164 form
+	recipient, err := phone.Normalize(msgReq.Recipient, s.cfg.Phone.DefaultCountry)
+	if err != nil {
+		sendRecipientErrorResponse(w, []models.RecipientError{{
+			Index: 0,
+			Phone: msgReq.Recipient,
+			Error: err.Error(),
+		}})
+		return
+	}
+	msgReq.Recipient = recipient
+	
+	// Marketing messages need the recipient's consent
+	category, err := normalizeCategory(msgReq.Category)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid category", err.Error())
+		return
+	}
+	if category == models.CategoryMarketing {
+		consented, err := s.hasConsent(username, recipient)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if !consented {
+			sendRecipientErrorResponse(w, []models.RecipientError{{
+				Index: 0,
+				Phone: recipient,
+				Error: "No valid marketing consent",
+			}})
+			return
+		}
+	}
+	
+	// Render the template when one is referenced
+	var templateID, templateVersion sql.NullInt64
+	if msgReq.TemplateID != nil {
+		tpl := s.templateFromRequest(w, username, *msgReq.TemplateID)
+		if tpl == nil {
+			return
+		}
+		
+		rendered, err := msgtemplate.Render(tpl.Body, msgReq.Variables)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Missing template variables", err.Error())
+			return
+		}
+		
+		msgReq.Message = rendered
+		templateID = sql.NullInt64{Int64: int64(tpl.ID), Valid: true}
+		templateVersion = sql.NullInt64{Int64: int64(tpl.Version), Valid: true}
+	}
+	
+	// Validate the message content and build the stored payload
+	contentType, message, payload, err := normalizeContent(msgReq.ContentType, msgReq.Message, msgReq.Media, msgReq.Location, msgReq.Interactive)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid message content", err.Error())
+		return
+	}
+	msgReq.Message = message
+	
+	if payload != nil && payload.Media != nil && payload.Media.AttachmentID != "" {
+		if err := s.resolveAttachment(username, contentType, payload.Media); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid message content", err.Error())
+			return
+		}
+	}
+	
+	payloadJSON, err := encodePayload(payload)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error processing request", "")
 		return
 	}
 	
@@ -98,11 +220,12 @@ func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
 	
 	// Insert message into the database
 	var messageID int
-	err := s.db.QueryRow(`
+	err = s.db.QueryRow(`
 		INSERT INTO message (
-			sender, recipient, status, dt_store, dt_queue, message
+			sender, recipient, status, dt_store, dt_queue, message, template_id, template_version, content_type, payload, 
+			category
 		) VALUES (
-			?, ?, ?, ?, ?, ?
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 		) RETURNING id
 	`,
 		msgReq.Sender,
@@ -111,15 +234,21 @@ func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
 		msgReq.DTStore,
 		dtQueue,
 		msgReq.Message,
+		templateID,
+		templateVersion,
+		contentType,
+		payloadJSON,
+		category,
 	).Scan(&messageID)
 	
 	// If database doesn't support RETURNING, use this alternative:
 	if err != nil {
 		res, err := s.db.Exec(`
 			INSERT INTO message (
-				sender, recipient, status, dt_store, dt_queue, message
+				sender, recipient, status, dt_store, dt_queue, message, template_id, template_version, content_type, payload, 
+				category
 			) VALUES (
-				?, ?, ?, ?, ?, ?
+				?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 			)
 		`,
 			msgReq.Sender,
@@ -128,6 +257,11 @@ func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
 			msgReq.DTStore,
 			dtQueue,
 			msgReq.Message,
+			templateID,
+			templateVersion,
+			contentType,
+			payloadJSON,
+			category,
 		)
 		
 		if err != nil {
@@ -171,9 +305,100 @@ func (s *Server) handleSendBulkMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	s.createBulk(w, username, &bulkReq)
+}
+
+// createBulk validates a bulk message, cleans its recipient list and stores
+// it for the bulk processor. It writes the response itself.
+func (s *Server) createBulk(w http.ResponseWriter, username string, bulkReq *models.BulkMessageRequest) {
 	// Validate request
-	if len(bulkReq.Recipients) == 0 || bulkReq.Message == "" {
-		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Recipients and message are required")
+	if len(bulkReq.Recipients) == 0 && len(bulkReq.GroupIDs) == 0 && len(bulkReq.SegmentIDs) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Recipients, group_ids or segment_ids are required")
+		return
+	}
+	
+	// Group members and segments are resolved when the broadcast is expanded; only check them now
+	members, ok := s.checkGroups(w, username, bulkReq.GroupIDs)
+	if !ok {
+		return
+	}
+	matched, ok := s.checkSegments(w, username, bulkReq.SegmentIDs)
+	if !ok {
+		return
+	}
+	members += matched
+	
+	category, err := normalizeCategory(bulkReq.Category)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid category", err.Error())
+		return
+	}
+	
+	// Snapshot the template body so later edits do not change this broadcast
+	var templateVersion int
+	if bulkReq.TemplateID != nil {
+		tpl := s.templateFromRequest(w, username, *bulkReq.TemplateID)
+		if tpl == nil {
+			return
+		}
+		bulkReq.Message = tpl.Body
+		templateVersion = tpl.Version
+	}
+	
+	// Validate the message content shared by every recipient
+	contentType, message, payload, err := normalizeContent(bulkReq.ContentType, bulkReq.Message, bulkReq.Media, bulkReq.Location, bulkReq.Interactive)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid message content", err.Error())
+		return
+	}
+	bulkReq.Message = message
+	
+	if payload != nil && payload.Media != nil && payload.Media.AttachmentID != "" {
+		if err := s.resolveAttachment(username, contentType, payload.Media); err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid message content", err.Error())
+			return
+		}
+	}
+	
+	// Validate every recipient against the message template
+	if recipientErrors := validateRecipients(contentType, bulkReq.Message, bulkReq.Variables, bulkReq.Recipients); len(recipientErrors) > 0 {
+		sendRecipientErrorResponse(w, recipientErrors)
+		return
+	}
+	
+	// Normalize and de-duplicate the list, dropping invalid and blocked numbers
+	recipients, hygiene := cleanRecipients(bulkReq.Recipients, s.cfg.Phone.DefaultCountry)
+	recipients, err = s.dropBlocked(username, recipients, hygiene)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	}
+	if category == models.CategoryMarketing {
+		recipients, err = s.dropWithoutConsent(username, recipients, hygiene)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+	}
+	if len(recipients) == 0 && members == 0 {
+		sendJSONResponse(w, http.StatusBadRequest, models.BulkMessageResponse{
+			Status:  models.BulkStatusFailed,
+			Info:    "No valid recipients left after removing invalid, duplicate, blocked and non-consenting numbers, and no group or segment members",
+			Hygiene: hygiene,
+		})
+		return
+	}
+	bulkReq.Recipients = recipients
+	
+	hygieneJSON, err := json.Marshal(hygiene)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error processing request", "")
+		return
+	}
+	
+	// Validate pacing options
+	if _, err := schedule.FromOptions(bulkReq.Pacing); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid pacing options", err.Error())
 		return
 	}
 	
@@ -182,8 +407,17 @@ func (s *Server) handleSendBulkMessage(w http.ResponseWriter, r *http.Request) {
 	
 	// Convert bulk data to JSON
 	bulkJSON, err := json.Marshal(map[string]interface{}{
-		"recipients": bulkReq.Recipients,
-		"message":    bulkReq.Message,
+		"recipients":       bulkReq.Recipients,
+		"group_ids":        bulkReq.GroupIDs,
+		"segment_ids":      bulkReq.SegmentIDs,
+		"message":          bulkReq.Message,
+		"variables":        bulkReq.Variables,
+		"template_id":      bulkReq.TemplateID,
+		"template_version": templateVersion,
+		"type":             contentType,
+		"payload":          payload,
+		"category":         category,
+		"pacing":           bulkReq.Pacing,
 	})
 	if err != nil {
 		sendErrorResponse(w, http.StatusInternalServerError, "Error processing request", "")
@@ -194,30 +428,34 @@ func (s *Server) handleSendBulkMessage(w http.ResponseWriter, r *http.Request) {
 	var bulkID int
 	err = s.db.QueryRow(`
 		INSERT INTO message_bulk (
-			sender, status, dt_store, bulk
+			sender, status, dt_store, bulk, hygiene, category
 		) VALUES (
-			?, ?, ?, ?
+			?, ?, ?, ?, ?, ?
 		) RETURNING id
 	`,
 		bulkReq.Sender,
 		models.BulkStatusProcess,
 		bulkReq.DTStore,
 		bulkJSON,
+		hygieneJSON,
+		category,
 	).Scan(&bulkID)
 	
 	// If database doesn't support RETURNING, use this alternative:
 	if err != nil {
 		res, err := s.db.Exec(`
 			INSERT INTO message_bulk (
-				sender, status, dt_store, bulk
+				sender, status, dt_store, bulk, hygiene, category
 			) VALUES (
-				?, ?, ?, ?
+				?, ?, ?, ?, ?, ?
 			)
 		`,
 			bulkReq.Sender,
 			models.BulkStatusProcess,
 			bulkReq.DTStore,
 			bulkJSON,
+			hygieneJSON,
+			category,
 		)
 		
 		if err != nil {
@@ -239,178 +477,119 @@ func (s *Server) handleSendBulkMessage(w http.ResponseWriter, r *http.Request) {
 		BulkMessageID: bulkID,
 		Status:        models.BulkStatusProcess,
 		Info:          "Bulk message received and is being processed",
+		Hygiene:       hygiene,
 	}
 	
 	sendJSONResponse(w, http.StatusAccepted, bulkResp)
 }
 
-// handleGetMessages handles retrieving messages for UI
-func (s *Server) handleGetMessages(w http.ResponseWriter, r *http.Request) {
-	// Get query parameters
-	queryValues := r.URL.Query()
-	year := queryValues.Get("year")
-	month := queryValues.Get("month")
-	senderFilter := queryValues.Get("sender_filter")
+// handlePreviewBulkMessage shows how a bulk message would be spread over days
+// without storing it
+func (s *Server) handlePreviewBulkMessage(w http.ResponseWriter, r *http.Request) {
+	var bulkReq models.BulkMessageRequest
 	
-	// Validate year parameter
-	if year == "" {
-		sendErrorResponse(w, http.StatusBadRequest, "Missing year parameter", "")
+	if err := json.NewDecoder(r.Body).Decode(&bulkReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
 		return
 	}
 	
 	// Get username from context (set by auth middleware)
-	username, _ := auth.GetUsername(r.Context())
-	
-	// Build query based on parameters
-	query := `
-		SELECT 
-			id, recipient, status, 
-			CASE WHEN type IS NULL OR type = '' THEN 'NO' ELSE 'YES' END AS broadcast_message,
-			DATE_FORMAT(dt_store, '%d-%m-%y %H:%i:%s') AS dt_store_fmt,
-			DATE_FORMAT(dt_queue, '%d-%m-%y %H:%i:%s') AS dt_queue_fmt,
-			CASE WHEN dt_send IS NULL THEN NULL ELSE DATE_FORMAT(dt_send, '%d-%m-%y %H:%i:%s') END AS dt_send_fmt,
-			message
-		FROM message
-		WHERE sender = ? AND YEAR(dt_store) = ?
-	`
-	
-	args := []interface{}{username, year}
-	
-	// Add month filter if specified
-	if month != "" && month != "all" {
-		query += " AND MONTH(dt_store) = ?"
-		args = append(args, month)
-	}
-	
-	// Add sender filter if specified (for admin users)
-	if senderFilter != "" {
-		// Replace username with senderFilter in the args slice
-		args[0] = senderFilter
-	}
-	
-	// Add order by clause
-	query += " ORDER BY dt_store DESC LIMIT 1000"
-	
-	// Execute query
-	rows, err := s.db.Query(query, args...)
-	if err != nil {
-		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying messages: %v", err))
+	username, ok := auth.GetUsername(r.Context())
+	if !ok {
+		sendErrorResponse(w, http.StatusUnauthorized, "Authentication required", "")
 		return
 	}
-	defer rows.Close()
 	
-	// Build response
-	var messages []*models.MessageView
-	for rows.Next() {
-		var msg models.MessageView
-		var dtSendFmt sql.NullString
-		
-		err := rows.Scan(
-			&msg.ID,
-			&msg.Recipient,
-			&msg.Status,
-			&msg.BroadcastMessage,
-			&msg.DTStore,
-			&msg.DTQueue,
-			&dtSendFmt,
-			&msg.Message,
-		)
-		
-		if err != nil {
-			continue // Skip this row and continue with the next
-		}
-		
-		if dtSendFmt.Valid {
-			msg.DTSend = &dtSendFmt.String
-		}
-		
-		messages = append(messages, &msg)
+	// Validate request
+	if len(bulkReq.Recipients) == 0 && len(bulkReq.GroupIDs) == 0 && len(bulkReq.SegmentIDs) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Recipients, group_ids or segment_ids are required")
+		return
 	}
 	
-	if err := rows.Err(); err != nil {
-		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating messages: %v", err))
+	previewResp, ok := s.previewBulk(w, username, &bulkReq)
+	if !ok {
 		return
 	}
 	
-	sendJSONResponse(w, http.StatusOK, messages)
+	sendJSONResponse(w, http.StatusOK, previewResp)
 }
 
-// handleGetBroadcasts handles retrieving bulk messages for UI
-func (s *Server) handleGetBroadcasts(w http.ResponseWriter, r *http.Request) {
-	// Get query parameters
-	queryValues := r.URL.Query()
-	year := queryValues.Get("year")
-	month := queryValues.Get("month")
-	
-	// Validate year parameter
-	if year == "" {
-		sendErrorResponse(w, http.StatusBadRequest, "Missing year parameter", "")
-		return
+// previewBulk plans the schedule of a bulk message. On error it writes the
+// response and returns false.
+func (s *Server) previewBulk(w http.ResponseWriter, username string, bulkReq *models.BulkMessageRequest) (*models.BulkPreviewResponse, bool) {
+	strategy, err := schedule.FromOptions(bulkReq.Pacing)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid pacing options", err.Error())
+		return nil, false
 	}
 	
-	// Get username from context (set by auth middleware)
-	username, _ := auth.GetUsername(r.Context())
+	category, err := normalizeCategory(bulkReq.Category)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid category", err.Error())
+		return nil, false
+	}
 	
-	// Build query based on parameters
-	query := `
-		SELECT 
-			id, sender, status, 
-			DATE_FORMAT(dt_store, '%d-%m-%y %H:%i:%s') AS dt_store_fmt,
-			CASE WHEN dt_convert IS NULL THEN NULL ELSE DATE_FORMAT(dt_convert, '%d-%m-%y %H:%i:%s') END AS dt_convert_fmt
-		FROM message_bulk
-		WHERE sender = ? AND YEAR(dt_store) = ?
-	`
+	if _, ok := s.checkGroups(w, username, bulkReq.GroupIDs); !ok {
+		return nil, false
+	}
+	if _, ok := s.checkSegments(w, username, bulkReq.SegmentIDs); !ok {
+		return nil, false
+	}
 	
-	args := []interface{}{username, year}
+	// Group and segment members are previewed as they would be resolved right now
+	members, err := contacts.Recipients(s.db, username, bulkReq.GroupIDs)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return nil, false
+	}
+	matched, err := contacts.SegmentRecipients(s.db, username, bulkReq.SegmentIDs, time.Now())
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return nil, false
+	}
+	members = contacts.Merge(members, matched)
 	
-	// Add month filter if specified
-	if month != "" && month != "all" {
-		query += " AND MONTH(dt_store) = ?"
-		args = append(args, month)
+	// Only recipients that survive the clean-up are scheduled
+	recipients, hygiene := cleanRecipients(contacts.Merge(bulkReq.Recipients, members), s.cfg.Phone.DefaultCountry)
+	recipients, err = s.dropBlocked(username, recipients, hygiene)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return nil, false
+	}
+	if category == models.CategoryMarketing {
+		recipients, err = s.dropWithoutConsent(username, recipients, hygiene)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+			return nil, false
+		}
 	}
 	
-	// Add order by clause
-	query += " ORDER BY dt_store DESC LIMIT 1000"
+	// Plan from now when the client does not say when the broadcast is stored
+	start := bulkReq.DTStore
+	if start.IsZero() {
+		start = time.Now()
+	}
 	
-	// Execute query
-	rows, err := s.db.Query(query, args...)
+	limit, err := schedule.LoadDailyLimit(s.db, username, start, schedule.LimitFromConfig(s.cfg.Schedule))
 	if err != nil {
-		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying broadcasts: %v", err))
-		return
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return nil, false
 	}
-	defer rows.Close()
 	
-	// Build response
-	var broadcasts []*models.MessageBulkView
-	for rows.Next() {
-		var bulk models.MessageBulkView
-		var dtConvertFmt sql.NullString
-		
-		err := rows.Scan(
-			&bulk.ID,
-			&bulk.Sender,
-			&bulk.Status,
-			&bulk.DTStore,
-			&dtConvertFmt,
-		)
-		
-		if err != nil {
-			continue // Skip this row and continue with the next
-		}
-		
-		if dtConvertFmt.Valid {
-			bulk.DTConvert = &dtConvertFmt.String
-		}
-		
-		broadcasts = append(broadcasts, &bulk)
+	planner := schedule.Planner{Strategy: strategy}
+	if bulkReq.Pacing != nil {
+		planner.Seed = bulkReq.Pacing.Seed
 	}
+	queueTimes := planner.PlanDaily(start, len(recipients), limit)
 	
-	if err := rows.Err(); err != nil {
-		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating broadcasts: %v", err))
-		return
+	previewResp := &models.BulkPreviewResponse{
+		TotalRecipients: len(recipients),
+		DailyCap:        limit.Cap,
+		Days:            schedule.Distribution(queueTimes),
+		Hygiene:         hygiene,
 	}
 	
-	sendJSONResponse(w, http.StatusOK, broadcasts)
+	return previewResp, true
 }
 
 // handleGetBroadcastDetails handles retrieving details of a bulk message
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/consent"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/phone"
+	"github.com/partadox/wags_queue/internal/suppression"
+)
+
+// maxHygieneExamples caps how many invalid, duplicate and blocked recipients
+// the report lists. Recipients without consent are all listed, as the
+// sender's record of who was excluded.
+const maxHygieneExamples = 5
+
+// cleanRecipients normalizes a broadcast recipient list, drops invalid numbers
+// and duplicates (keeping the first occurrence) and reports what was dropped
+func cleanRecipients(recipients []models.Recipient, defaultCountry string) ([]models.Recipient, *models.HygieneReport) {
+	report := &models.HygieneReport{Submitted: len(recipients)}
+	accepted := make([]models.Recipient, 0, len(recipients))
+	seen := make(map[string]bool, len(recipients))
+	
+	for i, recipient := range recipients {
+		normalized, err := phone.Normalize(recipient.Phone, defaultCountry)
+		if err != nil {
+			report.Invalid++
+			if len(report.InvalidExamples) < maxHygieneExamples {
+				report.InvalidExamples = append(report.InvalidExamples, models.RecipientError{
+					Index: i,
+					Phone: recipient.Phone,
+					Error: err.Error(),
+				})
+			}
+			continue
+		}
+		
+		if seen[normalized] {
+			report.Duplicate++
+			if len(report.DuplicateExamples) < maxHygieneExamples {
+				report.DuplicateExamples = append(report.DuplicateExamples, normalized)
+			}
+			continue
+		}
+		seen[normalized] = true
+		
+		recipient.Phone = normalized
+		accepted = append(accepted, recipient)
+	}
+	
+	report.Accepted = len(accepted)
+	return accepted, report
+}
+
+// dropBlocked removes recipients on the sender's suppression list and adds
+// them to the report
+func (s *Server) dropBlocked(sender string, recipients []models.Recipient, report *models.HygieneReport) ([]models.Recipient, error) {
+	phones := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		phones[i] = recipient.Phone
+	}
+	
+	blocked, err := suppression.Blocked(s.db, sender, phones)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocked) == 0 {
+		return recipients, nil
+	}
+	
+	kept := make([]models.Recipient, 0, len(recipients)-len(blocked))
+	for _, recipient := range recipients {
+		if !blocked[recipient.Phone] {
+			kept = append(kept, recipient)
+			continue
+		}
+		report.Blocked++
+		if len(report.BlockedExamples) < maxHygieneExamples {
+			report.BlockedExamples = append(report.BlockedExamples, recipient.Phone)
+		}
+	}
+	
+	report.Accepted = len(kept)
+	return kept, nil
+}
+
+// dropWithoutConsent removes recipients that hold no valid marketing consent
+// from the sender. Every excluded number is listed in the report.
+func (s *Server) dropWithoutConsent(sender string, recipients []models.Recipient, report *models.HygieneReport) ([]models.Recipient, error) {
+	phones := make([]string, len(recipients))
+	for i, recipient := range recipients {
+		phones[i] = recipient.Phone
+	}
+	
+	consented, err := consent.Consented(s.db, sender, phones, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	
+	kept := make([]models.Recipient, 0, len(consented))
+	for _, recipient := range recipients {
+		if consented[recipient.Phone] {
+			kept = append(kept, recipient)
+			continue
+		}
+		report.NoConsent++
+		report.NoConsentList = append(report.NoConsentList, recipient.Phone)
+	}
+	
+	report.Accepted = len(kept)
+	return kept, nil
+}
+
+// handleGetBroadcastHygiene returns the hygiene report stored on a bulk
+// message, including the group and segment members left out when it was
+// expanded
+func (s *Server) handleGetBroadcastHygiene(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+	
+	bulkID, err := strconv.Atoi(mux.Vars(r)["bulk_id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid bulk_id parameter", "")
+		return
+	}
+	
+	var hygieneJSON []byte
+	err = s.db.QueryRow("SELECT hygiene FROM message_bulk WHERE id = ? AND sender = ?", bulkID, username).Scan(&hygieneJSON)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Bulk message not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading bulk message: %v", err))
+		return
+	}
+	
+	report := &models.HygieneReport{}
+	if len(hygieneJSON) > 0 {
+		if err := json.Unmarshal(hygieneJSON, report); err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Internal error", "Error decoding hygiene report")
+			return
+		}
+	}
+	
+	sendJSONResponse(w, http.StatusOK, report)
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/auth"
+)
+
+// maxIdempotencyKeyLength matches the idem_key column
+const maxIdempotencyKeyLength = 255
+
+// idempotencyLease is how long a key stays reserved for a request in
+// progress. A reservation older than this was left by a replica that died
+// mid-request and is free to be taken again.
+const idempotencyLease = 5 * time.Minute
+
+// responseRecorder captures what a handler writes so it can be stored
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotent makes a handler safe to retry. When the request carries an
+// Idempotency-Key header, the first response is stored per user and replayed
+// for every repeat within the configured window. Reusing a key with a
+// different body, or while the first request is still running, is a conflict.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid Idempotency-Key", "Key must be at most 255 characters")
+			return
+		}
+
+		username, ok := auth.GetUsername(r.Context())
+		if !ok {
+			sendErrorResponse(w, http.StatusUnauthorized, "Unauthorized", "")
+			return
+		}
+
+		// Hash the endpoint together with the body so a key can't be reused across endpoints
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(r.URL.Path+"\n"), body...))
+		requestHash := hex.EncodeToString(sum[:])
+
+		now := time.Now()
+
+		// An expired key, or a reservation past its lease, is free to be used again
+		_, err = s.db.Exec(`
+			DELETE FROM idempotency_key
+			WHERE username = ? AND idem_key = ? AND (dt_expire <= ? OR (status_code = 0 AND dt_store <= ?))
+		`, username, key, now, now.Add(-idempotencyLease))
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		// Reserve the key; status_code 0 marks a request still in progress
+		res, err := s.db.Exec(`
+			INSERT IGNORE INTO idempotency_key (
+				username, idem_key, request_hash, status_code, dt_store, dt_expire
+			) VALUES (
+				?, ?, ?, 0, ?, ?
+			)
+		`, username, key, requestHash, now, now.Add(s.cfg.Idempotency.TTL))
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if affected, _ := res.RowsAffected(); affected == 0 {
+			s.replayIdempotent(w, username, key, requestHash)
+			return
+		}
+
+		// A panicking handler must not leave the key reserved
+		defer func() {
+			if p := recover(); p != nil {
+				s.releaseIdempotencyKey(username, key)
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		// Server errors are not stored so the client can retry them
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			s.releaseIdempotencyKey(username, key)
+			return
+		}
+
+		if _, err := s.db.Exec(`
+			UPDATE idempotency_key
+			SET status_code = ?,
+				response = ?
+			WHERE username = ? AND idem_key = ?
+		`, rec.status, rec.body.Bytes(), username, key); err != nil {
+			log.Printf("Error storing response for idempotency key %q: %v", key, err)
+			s.releaseIdempotencyKey(username, key)
+		}
+	}
+}
+
+// releaseIdempotencyKey frees a reserved key so the request can be retried
+func (s *Server) releaseIdempotencyKey(username, key string) {
+	if _, err := s.db.Exec(`
+		DELETE FROM idempotency_key
+		WHERE username = ? AND idem_key = ? AND status_code = 0
+	`, username, key); err != nil {
+		log.Printf("Error releasing idempotency key %q: %v", key, err)
+	}
+}
+
+// replayIdempotent answers a repeated request from the stored response
+func (s *Server) replayIdempotent(w http.ResponseWriter, username, key, requestHash string) {
+	var storedHash string
+	var statusCode int
+	var response []byte
+
+	err := s.db.QueryRow(`
+		SELECT request_hash, status_code, response
+		FROM idempotency_key
+		WHERE username = ? AND idem_key = ?
+	`, username, key).Scan(&storedHash, &statusCode, &response)
+
+	if err == sql.ErrNoRows {
+		// The first request failed and released the key in the meantime
+		sendErrorResponse(w, http.StatusConflict, "Idempotency-Key conflict", "The original request did not complete, retry the request")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	}
+
+	if storedHash != requestHash {
+		sendErrorResponse(w, http.StatusConflict, "Idempotency-Key conflict", "The key was already used with a different request body")
+		return
+	}
+
+	if statusCode == 0 {
+		sendErrorResponse(w, http.StatusConflict, "Idempotency-Key conflict", "A request with this key is still being processed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(statusCode)
+	w.Write(response)
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/export"
+	"github.com/partadox/wags_queue/internal/jobs"
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// maxPendingJobs is how many queued and running jobs a user may have at once
+const maxPendingJobs = 20
+
+// maxListedJobs is how many of the newest jobs the listing returns
+const maxListedJobs = 100
+
+// jobColumns is the column list scanned by scanJob
+const jobColumns = `id, kind, status, format, row_count, result_size, error, dt_store, dt_start, dt_finish, dt_expire`
+
+// scanJob scans a job selected with jobColumns
+func scanJob(row interface{ Scan(...interface{}) error }) (*models.Job, error) {
+	var job models.Job
+	var resultSize sql.NullInt64
+	var jobError sql.NullString
+	var dtStart, dtFinish, dtExpire sql.NullTime
+
+	if err := row.Scan(&job.ID, &job.Kind, &job.Status, &job.Format, &job.Rows, &resultSize, &jobError,
+		&job.DTStore, &dtStart, &dtFinish, &dtExpire); err != nil {
+		return nil, err
+	}
+
+	if resultSize.Valid {
+		job.ResultSize = &resultSize.Int64
+	}
+	job.Error = jobError.String
+	if dtStart.Valid {
+		job.DTStart = &dtStart.Time
+	}
+	if dtFinish.Valid {
+		job.DTFinish = &dtFinish.Time
+	}
+	if dtExpire.Valid {
+		job.DTExpire = &dtExpire.Time
+	}
+	if job.Status == models.JobStatusDone {
+		job.DownloadURL = fmt.Sprintf("/api/jobs/%d/download", job.ID)
+	}
+
+	return &job, nil
+}
+
+// jobIDFromPath parses the {id} route variable
+func jobIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	jobID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid job id", "")
+		return 0, false
+	}
+	return jobID, true
+}
+
+// loadJob returns a job of the user, answering 404 when there is none
+func (s *Server) loadJob(w http.ResponseWriter, jobID int, username string) (*models.Job, bool) {
+	job, err := scanJob(s.db.QueryRow("SELECT "+jobColumns+" FROM job WHERE id = ? AND owner = ?", jobID, username))
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Job not found", "")
+		return nil, false
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading job: %v", err))
+		return nil, false
+	}
+	return job, true
+}
+
+// handleCreateJob queues an export or report. It answers right away; the
+// client polls the job until it is DONE and then downloads the result.
+func (s *Server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	var jobReq models.JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&jobReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return
+	}
+
+	if !containsString(jobs.Kinds, jobReq.Kind) {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid kind",
+			fmt.Sprintf("Kind must be one of %s", strings.Join(jobs.Kinds, ", ")))
+		return
+	}
+
+	params := jobs.Params{
+		Options: export.Options{Format: jobReq.Format, Columns: jobReq.Columns, TZ: jobReq.TZ},
+	}
+	if err := params.Options.Validate(); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid export parameters", err.Error())
+		return
+	}
+
+	// The filters are the listing's query parameters
+	query := url.Values{}
+	for key, value := range jobReq.Filters {
+		query.Set(key, value)
+	}
+	_, q, ok := s.parseMessageFilters(w, username, query)
+	if !ok {
+		return
+	}
+	params.Query = q
+
+	if jobReq.Kind == jobs.KindExportBroadcast {
+		if q.BulkID == 0 {
+			sendErrorResponse(w, http.StatusBadRequest, "Missing bulk_id filter", "A broadcast export needs filters.bulk_id")
+			return
+		}
+		if !s.checkBulkOwner(w, q.BulkID, username) {
+			return
+		}
+	}
+
+	var pending int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM job WHERE owner = ? AND status IN (?, ?)",
+		username, models.JobStatusQueued, models.JobStatusRunning).Scan(&pending)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error counting jobs: %v", err))
+		return
+	}
+	if pending >= maxPendingJobs {
+		sendErrorResponse(w, http.StatusTooManyRequests, "Too many jobs",
+			fmt.Sprintf("At most %d jobs can be queued or running at once", maxPendingJobs))
+		return
+	}
+
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Internal error", "Error encoding job parameters")
+		return
+	}
+
+	res, err := s.db.Exec(`
+		INSERT INTO job (
+			owner, kind, status, params, format, dt_store
+		) VALUES (
+			?, ?, ?, ?, ?, ?
+		)
+	`, username, jobReq.Kind, models.JobStatusQueued, paramsJSON, params.Options.Format, time.Now())
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting job: %v", err))
+		return
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", "Error retrieving job ID")
+		return
+	}
+
+	job, err := scanJob(s.db.QueryRow("SELECT "+jobColumns+" FROM job WHERE id = ?", lastID))
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading job: %v", err))
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/jobs/%d", job.ID))
+	sendJSONResponse(w, http.StatusAccepted, job)
+}
+
+// handleListJobs lists the newest jobs of the authenticated user
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	rows, err := s.db.Query("SELECT "+jobColumns+" FROM job WHERE owner = ? ORDER BY id DESC LIMIT ?",
+		username, maxListedJobs)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying jobs: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	jobList := []*models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			continue // Skip this row and continue with the next
+		}
+		jobList = append(jobList, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating jobs: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, jobList)
+}
+
+// handleGetJob returns the status and progress of a job
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	jobID, ok := jobIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	job, ok := s.loadJob(w, jobID, username)
+	if !ok {
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, job)
+}
+
+// handleCancelJob cancels a queued or running job. A running job stops at its
+// next heartbeat and its partial result is discarded.
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	jobID, ok := jobIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	res, err := s.db.Exec(`
+		UPDATE job
+		SET status = ?,
+			dt_finish = ?,
+			dt_expire = ?
+		WHERE id = ? AND owner = ? AND status IN (?, ?)
+	`, models.JobStatusCancelled, now, now.Add(s.cfg.Job.ResultTTL), jobID, username,
+		models.JobStatusQueued, models.JobStatusRunning)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error cancelling job: %v", err))
+		return
+	}
+
+	job, ok := s.loadJob(w, jobID, username)
+	if !ok {
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusConflict, "Job already finished", fmt.Sprintf("The job is %s", job.Status))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, job)
+}
+
+// handleDownloadJob serves the result file of a finished job
+func (s *Server) handleDownloadJob(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	jobID, ok := jobIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var kind, format string
+	var status models.JobStatus
+	var paramsJSON []byte
+	var dtFinish sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT kind, status, format, params, dt_finish
+		FROM job
+		WHERE id = ? AND owner = ?
+	`, jobID, username).Scan(&kind, &status, &format, &paramsJSON, &dtFinish)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Job not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading job: %v", err))
+		return
+	}
+
+	if status != models.JobStatusDone {
+		sendErrorResponse(w, http.StatusConflict, "Job result not ready", fmt.Sprintf("The job is %s", status))
+		return
+	}
+
+	var params jobs.Params
+	if err := json.Unmarshal(paramsJSON, &params); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Internal error", "Error decoding job parameters")
+		return
+	}
+
+	file, err := s.jobs.Open(jobID, format)
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Job result not found", "The result file was removed")
+		return
+	}
+	defer file.Close()
+
+	// Large results take longer than the server write timeout allows
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Error lifting write deadline for job %d: %v", jobID, err)
+	}
+
+	filename := jobs.Filename(jobID, kind, params)
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	http.ServeContent(w, r, filename, dtFinish.Time, file)
+}
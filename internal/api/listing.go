@@ -0,0 +1,521 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/export"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/phone"
+	"github.com/partadox/wags_queue/internal/progress"
+)
+
+// Page sizes of the message and broadcast listings
+const (
+	defaultListLimit = 100
+	maxListLimit     = 500
+)
+
+// uiTimeFormat is how the listings show timestamps
+const uiTimeFormat = "02-01-06 15:04:05"
+
+// Statuses accepted by the status filter of each listing
+var (
+	messageStatuses = []string{
+		string(models.StatusPending), string(models.StatusProcessing), string(models.StatusSent),
+		string(models.StatusFailed), string(models.StatusSuppressed),
+	}
+	broadcastStatuses = []string{
+		string(models.BulkStatusProcess), string(models.BulkStatusExpanding), string(models.BulkStatusDone),
+		string(models.BulkStatusCompleted), string(models.BulkStatusFailed),
+	}
+)
+
+// listFilter collects the WHERE conditions of a listing
+type listFilter struct {
+	conds []string
+	args  []interface{}
+}
+
+// add appends a condition and its arguments
+func (f *listFilter) add(cond string, args ...interface{}) {
+	f.conds = append(f.conds, cond)
+	f.args = append(f.args, args...)
+}
+
+// where returns the WHERE clause
+func (f *listFilter) where() string {
+	return " WHERE " + strings.Join(f.conds, " AND ")
+}
+
+// listParams are the filters and the page position of a listing request
+type listParams struct {
+	filter listFilter
+	limit  int
+	after  *pageCursor // Nil on the first page
+
+	// The shared filters, also found in filter
+	sender   string
+	statuses []string
+	from     *time.Time
+	until    *time.Time // Exclusive
+}
+
+// pageCursor is the last row of a page; the next page starts right after it.
+// Listings are ordered newest first by (dt_store, id).
+type pageCursor struct {
+	DTStore time.Time
+	ID      int
+}
+
+// encodeCursor turns a cursor into an opaque query parameter value
+func encodeCursor(c pageCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d,%d", c.DTStore.Unix(), c.ID)))
+}
+
+// decodeCursor parses a cursor produced by encodeCursor
+func decodeCursor(value string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(raw), ",", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &pageCursor{DTStore: time.Unix(unix, 0), ID: id}, nil
+}
+
+// parseTimeParam accepts a date (YYYY-MM-DD, server time) or an RFC 3339
+// timestamp. dateOnly tells which one it was.
+func parseTimeParam(value string) (t time.Time, dateOnly bool, err error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+// parseListParams reads the filters shared by the listings: sender, status,
+// from/to (or the older year/month), limit and cursor. The listing's own
+// filters are added by the caller.
+func parseListParams(w http.ResponseWriter, r *http.Request, statuses []string) (*listParams, bool) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	return parseListQuery(w, username, r.URL.Query(), statuses)
+}
+
+// parseListQuery is parseListParams for query values that do not come from
+// the request URL, such as the filters of a background job
+func parseListQuery(w http.ResponseWriter, username string, query url.Values, statuses []string) (*listParams, bool) {
+	params := &listParams{limit: defaultListLimit, sender: username}
+
+	// There are no admin users yet, so the sender filter can only name yourself
+	sender := query.Get("sender")
+	if sender == "" {
+		sender = query.Get("sender_filter")
+	}
+	if sender != "" && sender != username {
+		sendErrorResponse(w, http.StatusForbidden, "Access denied", "You can only view your own messages")
+		return nil, false
+	}
+
+	if statusStr := query.Get("status"); statusStr != "" {
+		for _, status := range strings.Split(statusStr, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if !containsString(statuses, status) {
+				sendErrorResponse(w, http.StatusBadRequest, "Invalid status parameter",
+					fmt.Sprintf("Status must be one of %s", strings.Join(statuses, ", ")))
+				return nil, false
+			}
+			params.statuses = append(params.statuses, status)
+		}
+	}
+
+	// year and month are kept for older clients; from and to take precedence
+	fromStr, toStr := query.Get("from"), query.Get("to")
+	if yearStr := query.Get("year"); yearStr != "" && fromStr == "" && toStr == "" {
+		year, err := strconv.Atoi(yearStr)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid year parameter", "")
+			return nil, false
+		}
+		start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local)
+		end := start.AddDate(1, 0, 0)
+		if monthStr := query.Get("month"); monthStr != "" && monthStr != "all" {
+			month, err := strconv.Atoi(monthStr)
+			if err != nil || month < 1 || month > 12 {
+				sendErrorResponse(w, http.StatusBadRequest, "Invalid month parameter", "")
+				return nil, false
+			}
+			start = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
+			end = start.AddDate(0, 1, 0)
+		}
+		params.from, params.until = &start, &end
+	}
+
+	if fromStr != "" {
+		from, _, err := parseTimeParam(fromStr)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid from parameter", "Expected YYYY-MM-DD or an RFC 3339 timestamp")
+			return nil, false
+		}
+		params.from = &from
+	}
+
+	if toStr != "" {
+		to, dateOnly, err := parseTimeParam(toStr)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid to parameter", "Expected YYYY-MM-DD or an RFC 3339 timestamp")
+			return nil, false
+		}
+		// A date includes the whole day and a timestamp its whole second;
+		// dt_store has no fractional seconds
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		} else {
+			to = to.Truncate(time.Second).Add(time.Second)
+		}
+		params.until = &to
+	}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid limit parameter",
+				fmt.Sprintf("Limit must be between 1 and %d", maxListLimit))
+			return nil, false
+		}
+		params.limit = limit
+	}
+
+	if cursorStr := query.Get("cursor"); cursorStr != "" {
+		after, err := decodeCursor(cursorStr)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid cursor parameter", "")
+			return nil, false
+		}
+		params.after = after
+	}
+
+	params.filter.add("sender = ?", params.sender)
+	if len(params.statuses) > 0 {
+		params.filter.add("status IN (?"+strings.Repeat(", ?", len(params.statuses)-1)+")", stringArgs(params.statuses)...)
+	}
+	if params.from != nil {
+		params.filter.add("dt_store >= ?", *params.from)
+	}
+	if params.until != nil {
+		params.filter.add("dt_store < ?", *params.until)
+	}
+
+	return params, true
+}
+
+// stringArgs turns strings into query arguments
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
+
+// parseMessageFilters reads the filters of the message history: the shared
+// listing filters plus recipient and bulk_id. Besides the listing
+// parameters it returns them as an export query.
+func (s *Server) parseMessageFilters(w http.ResponseWriter, username string, query url.Values) (*listParams, export.MessageQuery, bool) {
+	params, ok := parseListQuery(w, username, query, messageStatuses)
+	if !ok {
+		return nil, export.MessageQuery{}, false
+	}
+
+	recipient, ok := s.recipientParam(w, query)
+	if !ok {
+		return nil, export.MessageQuery{}, false
+	}
+	if recipient != "" {
+		params.filter.add("recipient = ?", recipient)
+	}
+
+	bulkID, ok := bulkIDParam(w, query)
+	if !ok {
+		return nil, export.MessageQuery{}, false
+	}
+	if bulkID != 0 {
+		params.filter.add("type = ?", strconv.Itoa(bulkID))
+	}
+
+	return params, export.MessageQuery{
+		Sender:    params.sender,
+		Statuses:  params.statuses,
+		Recipient: recipient,
+		BulkID:    bulkID,
+		From:      params.from,
+		Until:     params.until,
+	}, true
+}
+
+// recipientParam normalizes the recipient filter; an empty string means no filter
+func (s *Server) recipientParam(w http.ResponseWriter, query url.Values) (string, bool) {
+	raw := query.Get("recipient")
+	if raw == "" {
+		return "", true
+	}
+	recipient, err := phone.Normalize(raw, s.cfg.Phone.DefaultCountry)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid recipient parameter", err.Error())
+		return "", false
+	}
+	return recipient, true
+}
+
+// bulkIDParam parses the bulk_id filter; zero means no filter
+func bulkIDParam(w http.ResponseWriter, query url.Values) (int, bool) {
+	raw := query.Get("bulk_id")
+	if raw == "" {
+		return 0, true
+	}
+	bulkID, err := strconv.Atoi(raw)
+	if err != nil || bulkID < 1 {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid bulk_id parameter", "")
+		return 0, false
+	}
+	return bulkID, true
+}
+
+// countByStatus counts the rows of a listing per status, over all pages
+func (s *Server) countByStatus(table string, filter listFilter) (int, map[string]int, error) {
+	rows, err := s.db.Query("SELECT status, COUNT(*) FROM "+table+filter.where()+" GROUP BY status", filter.args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	total := 0
+	counts := map[string]int{}
+	for rows.Next() {
+		var status sql.NullString
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return 0, nil, err
+		}
+		counts[status.String] += count
+		total += count
+	}
+
+	return total, counts, rows.Err()
+}
+
+// containsString reports whether a list holds a value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// pageQuery returns the ORDER BY and LIMIT of a page and adds the cursor
+// condition. One extra row is fetched to tell whether another page follows.
+func (p *listParams) pageQuery() (string, []interface{}) {
+	filter := p.filter
+	if p.after != nil {
+		filter.add("(dt_store < ? OR (dt_store = ? AND id < ?))", p.after.DTStore, p.after.DTStore, p.after.ID)
+	}
+	args := append(append([]interface{}{}, filter.args...), p.limit+1)
+	return filter.where() + " ORDER BY dt_store DESC, id DESC LIMIT ?", args
+}
+
+// handleGetMessages lists the messages of the authenticated user, newest
+// first, one page at a time
+func (s *Server) handleGetMessages(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	params, _, ok := s.parseMessageFilters(w, username, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	total, counts, err := s.countByStatus("message", params.filter)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error counting messages: %v", err))
+		return
+	}
+
+	clause, args := params.pageQuery()
+	rows, err := s.db.Query(`
+		SELECT id, recipient, status, type, dt_store, dt_queue, dt_send, message
+		FROM message`+clause, args...)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying messages: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	listResp := models.MessageListResponse{
+		Messages:     []*models.MessageView{},
+		Total:        total,
+		StatusCounts: counts,
+	}
+
+	var last pageCursor
+	for rows.Next() {
+		var msg models.MessageView
+		var bulkRef sql.NullString
+		var dtStore, dtQueue time.Time
+		var dtSend sql.NullTime
+
+		if err := rows.Scan(&msg.ID, &msg.Recipient, &msg.Status, &bulkRef, &dtStore, &dtQueue, &dtSend, &msg.Message); err != nil {
+			continue // Skip this row and continue with the next
+		}
+
+		if len(listResp.Messages) == params.limit {
+			listResp.NextCursor = encodeCursor(last)
+			break
+		}
+
+		msg.BroadcastMessage = "NO"
+		if bulkRef.String != "" {
+			msg.BroadcastMessage = "YES"
+		}
+		msg.DTStore = dtStore.Format(uiTimeFormat)
+		msg.DTQueue = dtQueue.Format(uiTimeFormat)
+		if dtSend.Valid {
+			formatted := dtSend.Time.Format(uiTimeFormat)
+			msg.DTSend = &formatted
+		}
+
+		listResp.Messages = append(listResp.Messages, &msg)
+		last = pageCursor{DTStore: dtStore, ID: msg.ID}
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating messages: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, listResp)
+}
+
+// handleGetBroadcasts lists the bulk messages of the authenticated user,
+// newest first, one page at a time
+func (s *Server) handleGetBroadcasts(w http.ResponseWriter, r *http.Request) {
+	params, ok := parseListParams(w, r, broadcastStatuses)
+	if !ok {
+		return
+	}
+
+	// Broadcasts that were sent to a recipient, once expanded
+	recipient, ok := s.recipientParam(w, r.URL.Query())
+	if !ok {
+		return
+	}
+	if recipient != "" {
+		params.filter.add(`EXISTS (
+			SELECT 1 FROM message m
+			WHERE m.sender = message_bulk.sender AND m.recipient = ? AND m.type = CAST(message_bulk.id AS CHAR)
+		)`, recipient)
+	}
+
+	bulkID, ok := bulkIDParam(w, r.URL.Query())
+	if !ok {
+		return
+	}
+	if bulkID != 0 {
+		params.filter.add("id = ?", bulkID)
+	}
+
+	total, counts, err := s.countByStatus("message_bulk", params.filter)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error counting broadcasts: %v", err))
+		return
+	}
+
+	clause, args := params.pageQuery()
+	rows, err := s.db.Query(`
+		SELECT id, sender, status, dt_store, dt_convert, dt_complete, failure_reason
+		FROM message_bulk`+clause, args...)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying broadcasts: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	listResp := models.BroadcastListResponse{
+		Broadcasts:   []*models.MessageBulkView{},
+		Total:        total,
+		StatusCounts: counts,
+	}
+
+	var last pageCursor
+	var progresses []*models.BroadcastProgress
+	for rows.Next() {
+		var bulk models.MessageBulkView
+		var dtStore time.Time
+		var dtConvert, dtComplete sql.NullTime
+		var failureReason sql.NullString
+
+		if err := rows.Scan(&bulk.ID, &bulk.Sender, &bulk.Status, &dtStore, &dtConvert, &dtComplete, &failureReason); err != nil {
+			continue // Skip this row and continue with the next
+		}
+
+		if len(listResp.Broadcasts) == params.limit {
+			listResp.NextCursor = encodeCursor(last)
+			break
+		}
+
+		bulk.DTStore = dtStore.Format(uiTimeFormat)
+		if dtConvert.Valid {
+			formatted := dtConvert.Time.Format(uiTimeFormat)
+			bulk.DTConvert = &formatted
+		}
+		if dtComplete.Valid {
+			formatted := dtComplete.Time.Format(uiTimeFormat)
+			bulk.DTComplete = &formatted
+		}
+		if failureReason.Valid {
+			bulk.FailureReason = &failureReason.String
+		}
+
+		// Only expanded broadcasts have messages to follow
+		status := models.BulkMessageStatus(bulk.Status)
+		if status == models.BulkStatusDone || status == models.BulkStatusCompleted {
+			bulk.Progress = newProgress(bulk.ID, status, dtComplete)
+			progresses = append(progresses, bulk.Progress)
+		}
+
+		listResp.Broadcasts = append(listResp.Broadcasts, &bulk)
+		last = pageCursor{DTStore: dtStore, ID: bulk.ID}
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating broadcasts: %v", err))
+		return
+	}
+
+	if err := progress.Fill(s.db, params.sender, progresses, time.Now()); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading broadcast progress: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, listResp)
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// maxStatusBatch is the number of IDs accepted by the batch status endpoint
+const maxStatusBatch = 100
+
+// messageStatusColumns is the column list scanned by scanMessageStatus
+const messageStatusColumns = `id, recipient, status, type, content_type, category, attempts, failure_reason,
+	external_api_response, dt_store, dt_queue, dt_send`
+
+// scanMessageStatus scans a message selected with messageStatusColumns
+func scanMessageStatus(row interface{ Scan(...interface{}) error }) (*models.MessageStatusView, error) {
+	var view models.MessageStatusView
+	var bulkID, failureReason, apiResponse sql.NullString
+	var dtSend sql.NullTime
+
+	err := row.Scan(&view.ID, &view.Recipient, &view.Status, &bulkID, &view.ContentType, &view.Category,
+		&view.Attempts, &failureReason, &apiResponse, &view.DTStore, &view.DTQueue, &dtSend)
+	if err != nil {
+		return nil, err
+	}
+
+	if id, err := strconv.Atoi(bulkID.String); err == nil {
+		view.BulkID = &id
+	}
+	view.FailureReason = failureReason.String
+	if dtSend.Valid {
+		view.DTSend = &dtSend.Time
+	}
+
+	// The gateway normally answers JSON; anything else is passed on as a string
+	if apiResponse.String != "" {
+		if json.Valid([]byte(apiResponse.String)) {
+			view.GatewayResponse = json.RawMessage(apiResponse.String)
+		} else {
+			view.GatewayResponse, _ = json.Marshal(apiResponse.String)
+		}
+	}
+
+	return &view, nil
+}
+
+// handleGetMessageStatus returns the delivery state of one message
+func (s *Server) handleGetMessageStatus(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	messageID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid message id", "")
+		return
+	}
+
+	view, err := scanMessageStatus(s.db.QueryRow(
+		"SELECT "+messageStatusColumns+" FROM message WHERE id = ? AND sender = ?", messageID, username))
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Message not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading message: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, view)
+}
+
+// handleBatchMessageStatus returns the delivery state of several messages.
+// Unknown IDs, and IDs of other users' messages, are listed in not_found.
+func (s *Server) handleBatchMessageStatus(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	var statusReq models.MessageStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&statusReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return
+	}
+
+	if len(statusReq.IDs) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "At least one id is required")
+		return
+	}
+	if len(statusReq.IDs) > maxStatusBatch {
+		sendErrorResponse(w, http.StatusBadRequest, "Too many ids", fmt.Sprintf("At most %d ids per request", maxStatusBatch))
+		return
+	}
+
+	// Ask for each ID once, but answer in request order
+	ids := make([]int, 0, len(statusReq.IDs))
+	seen := make(map[int]bool, len(statusReq.IDs))
+	for _, id := range statusReq.IDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, username)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := s.db.Query("SELECT "+messageStatusColumns+" FROM message WHERE sender = ? AND id IN (?"+
+		strings.Repeat(", ?", len(ids)-1)+")", args...)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying messages: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	found := make(map[int]*models.MessageStatusView, len(ids))
+	for rows.Next() {
+		view, err := scanMessageStatus(rows)
+		if err != nil {
+			continue // Skip this row and continue with the next
+		}
+		found[view.ID] = view
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating messages: %v", err))
+		return
+	}
+
+	statusResp := models.MessageStatusResponse{
+		Messages: make([]*models.MessageStatusView, 0, len(found)),
+		NotFound: []int{},
+	}
+	for _, id := range ids {
+		if view, ok := found[id]; ok {
+			statusResp.Messages = append(statusResp.Messages, view)
+		} else {
+			statusResp.NotFound = append(statusResp.NotFound, id)
+		}
+	}
+
+	sendJSONResponse(w, http.StatusOK, statusResp)
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// snippetRadius is how many characters of context a snippet keeps on each
+// side of the first match
+const snippetRadius = 60
+
+// minTermLength matches the default innodb_ft_min_token_size
+const minTermLength = 3
+
+// minRecipientDigits is the shortest query that is also matched against recipients
+const minRecipientDigits = 4
+
+// phoneQuery matches queries that look like (part of) a phone number
+var phoneQuery = regexp.MustCompile(`^[+\d\s\-()]+$`)
+
+// searchTerms splits a query into words, dropping the characters that have a
+// meaning in MySQL boolean full-text mode
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// indexedTerms drops the words too short for the full-text index
+// (innodb_ft_min_token_size); requiring them would match nothing
+func indexedTerms(terms []string) []string {
+	var indexed []string
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) >= minTermLength {
+			indexed = append(indexed, term)
+		}
+	}
+	return indexed
+}
+
+// booleanQuery requires every term, allowing words that start with it
+func booleanQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = "+" + term + "*"
+	}
+	return strings.Join(parts, " ")
+}
+
+// highlightSnippet cuts the part of a message around the first match and
+// wraps every term in <mark>. The rest of the text is HTML-escaped, so the
+// snippet can be shown as is. Without a match the start of the message is used.
+func highlightSnippet(text string, terms []string) string {
+	// Lower-case rune by rune so positions line up with the original text
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// Find where each term occurs
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		needle := []rune(term)
+		for i, r := range needle {
+			needle[i] = unicode.ToLower(r)
+		}
+		for i := 0; i+len(needle) <= len(lower); i++ {
+			if string(lower[i:i+len(needle)]) != string(needle) {
+				continue
+			}
+			for j := i; j < i+len(needle); j++ {
+				marked[j] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+	if first == -1 {
+		first = 0
+	}
+
+	start := first - snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	end := first + snippetRadius
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; i++ {
+		if marked[i] && (i == start || !marked[i-1]) {
+			b.WriteString("<mark>")
+		}
+		b.WriteString(html.EscapeString(string(runes[i])))
+		if marked[i] && (i == end-1 || !marked[i+1]) {
+			b.WriteString("</mark>")
+		}
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+
+	return b.String()
+}
+
+// handleSearchMessages finds messages by their text, or by recipient when the
+// query looks like a phone number. It takes the listing filters (status,
+// year/month, from/to, recipient, bulk_id) and ranks results by relevance,
+// newest first among equals.
+func (s *Server) handleSearchMessages(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	terms := searchTerms(q)
+	if len(terms) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing q parameter", "Search needs at least one word or number")
+		return
+	}
+
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	params, _, ok := s.parseMessageFilters(w, username, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	// Results are ranked by relevance, which the (dt_store, id) cursor of the
+	// listings cannot follow; search pages with offset instead
+	if params.after != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid cursor parameter", "Search results are paged with offset, not cursor")
+		return
+	}
+
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid offset parameter", "")
+			return
+		}
+	}
+
+	// The text must contain every indexed term; a number can also be part of
+	// the recipient, which is stored without the leading zero
+	var conds []string
+	var matchArgs []interface{}
+	if indexed := indexedTerms(terms); len(indexed) > 0 {
+		conds = append(conds, "MATCH(message) AGAINST(? IN BOOLEAN MODE)")
+		matchArgs = append(matchArgs, booleanQuery(indexed))
+	}
+	if digits := strings.TrimLeft(strings.Map(keepDigits, q), "0"); phoneQuery.MatchString(q) && len(digits) >= minRecipientDigits {
+		conds = append(conds, "recipient LIKE ?")
+		matchArgs = append(matchArgs, "%"+digits+"%")
+	}
+	if len(conds) == 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid q parameter",
+			fmt.Sprintf("Search words must be at least %d characters long", minTermLength))
+		return
+	}
+	params.filter.add("("+strings.Join(conds, " OR ")+")", matchArgs...)
+
+	args := append([]interface{}{strings.Join(terms, " ")}, params.filter.args...)
+	args = append(args, params.limit+1, offset)
+
+	rows, err := s.db.Query(`
+		SELECT id, recipient, status, type, dt_store, dt_queue, dt_send, message,
+			MATCH(message) AGAINST(? IN NATURAL LANGUAGE MODE) AS score
+		FROM message`+params.filter.where()+`
+		ORDER BY score DESC, dt_store DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, args...)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error searching messages: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	searchResp := models.MessageSearchResponse{
+		Query:   q,
+		Results: []*models.MessageSearchResult{},
+	}
+
+	for rows.Next() {
+		var result models.MessageSearchResult
+		var bulkRef sql.NullString
+		var dtStore, dtQueue time.Time
+		var dtSend sql.NullTime
+
+		if err := rows.Scan(&result.ID, &result.Recipient, &result.Status, &bulkRef, &dtStore, &dtQueue, &dtSend,
+			&result.Message, &result.Score); err != nil {
+			continue // Skip this row and continue with the next
+		}
+
+		if len(searchResp.Results) == params.limit {
+			searchResp.HasMore = true
+			break
+		}
+
+		result.BroadcastMessage = "NO"
+		if bulkRef.String != "" {
+			result.BroadcastMessage = "YES"
+		}
+		result.DTStore = dtStore.Format(uiTimeFormat)
+		result.DTQueue = dtQueue.Format(uiTimeFormat)
+		if dtSend.Valid {
+			formatted := dtSend.Time.Format(uiTimeFormat)
+			result.DTSend = &formatted
+		}
+		result.Snippet = highlightSnippet(result.Message, terms)
+
+		searchResp.Results = append(searchResp.Results, &result)
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating search results: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, searchResp)
+}
+
+// keepDigits is a strings.Map function that drops everything but digits
+func keepDigits(r rune) rune {
+	if r >= '0' && r <= '9' {
+		return r
+	}
+	return -1
+}
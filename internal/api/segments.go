@@ -0,0 +1,359 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/contacts"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/segment"
+	"github.com/partadox/wags_queue/internal/suppression"
+)
+
+// Lengths of the segment columns
+const (
+	maxSegmentNameLength        = 100
+	maxSegmentDescriptionLength = 255
+)
+
+// segmentColumns is the column list scanned by scanSegment
+const segmentColumns = `id, name, description, expression, dt_store, dt_update`
+
+// scanSegment scans a segment selected with segmentColumns
+func scanSegment(row interface{ Scan(...interface{}) error }) (*models.Segment, error) {
+	var seg models.Segment
+	var description sql.NullString
+	var dtUpdate sql.NullTime
+
+	if err := row.Scan(&seg.ID, &seg.Name, &description, &seg.Expression, &seg.DTStore, &dtUpdate); err != nil {
+		return nil, err
+	}
+
+	seg.Description = description.String
+	if dtUpdate.Valid {
+		seg.DTUpdate = &dtUpdate.Time
+	}
+
+	return &seg, nil
+}
+
+// loadSegment loads a segment of the user
+func (s *Server) loadSegment(username string, segmentID int) (*models.Segment, error) {
+	return scanSegment(s.db.QueryRow("SELECT "+segmentColumns+" FROM segment WHERE id = ? AND owner = ?",
+		segmentID, username))
+}
+
+// segmentIDFromPath parses the {id} route variable
+func segmentIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	segmentID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid segment id", "")
+		return 0, false
+	}
+	return segmentID, true
+}
+
+// checkSegments verifies that every segment belongs to the user and returns
+// how many contacts they match together. On error it writes the response.
+func (s *Server) checkSegments(w http.ResponseWriter, username string, segmentIDs []int) (int, bool) {
+	if len(segmentIDs) == 0 {
+		return 0, true
+	}
+
+	missing, err := contacts.MissingSegments(s.db, username, segmentIDs)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return 0, false
+	}
+	if len(missing) > 0 {
+		ids := make([]string, len(missing))
+		for i, id := range missing {
+			ids[i] = strconv.Itoa(id)
+		}
+		sendErrorResponse(w, http.StatusBadRequest, "Segment not found",
+			fmt.Sprintf("No segment %s", strings.Join(ids, ", ")))
+		return 0, false
+	}
+
+	matched, err := contacts.SegmentRecipients(s.db, username, segmentIDs, time.Now())
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return 0, false
+	}
+	return len(matched), true
+}
+
+// decodeSegmentRequest decodes and validates a segment create or update request
+func decodeSegmentRequest(w http.ResponseWriter, r *http.Request) (*models.SegmentRequest, bool) {
+	var segReq models.SegmentRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&segReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return nil, false
+	}
+
+	segReq.Name = strings.TrimSpace(segReq.Name)
+	if segReq.Name == "" || strings.TrimSpace(segReq.Expression) == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Name and expression are required")
+		return nil, false
+	}
+
+	if len(segReq.Name) > maxSegmentNameLength {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid name", fmt.Sprintf("Name must be at most %d characters", maxSegmentNameLength))
+		return nil, false
+	}
+
+	if len(segReq.Description) > maxSegmentDescriptionLength {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid description",
+			fmt.Sprintf("Description must be at most %d characters", maxSegmentDescriptionLength))
+		return nil, false
+	}
+
+	if _, err := segment.Parse(segReq.Expression); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid expression", err.Error())
+		return nil, false
+	}
+
+	return &segReq, true
+}
+
+// countAudience answers with how many contacts an expression matches right now
+func (s *Server) countAudience(w http.ResponseWriter, username string, expr *segment.Expr) {
+	matched, total, err := contacts.Matching(s.db, username, expr, time.Now())
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	}
+
+	phones := make([]string, len(matched))
+	for i, recipient := range matched {
+		phones[i] = recipient.Phone
+	}
+	blocked, err := suppression.Blocked(s.db, username, phones)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, models.SegmentCountResponse{
+		Matched:  len(matched),
+		Total:    total,
+		Eligible: len(matched) - len(blocked),
+	})
+}
+
+// handleListSegments lists the segments of the authenticated user
+func (s *Server) handleListSegments(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	rows, err := s.db.Query("SELECT "+segmentColumns+" FROM segment WHERE owner = ? ORDER BY name", username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying segments: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	segments := []*models.Segment{}
+	for rows.Next() {
+		seg, err := scanSegment(rows)
+		if err != nil {
+			continue // Skip this row and continue with the next
+		}
+		segments = append(segments, seg)
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating segments: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, segments)
+}
+
+// handleGetSegment returns a single segment
+func (s *Server) handleGetSegment(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	segmentID, ok := segmentIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	seg, err := s.loadSegment(username, segmentID)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Segment not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading segment: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, seg)
+}
+
+// handleCreateSegment saves a segment after checking its expression
+func (s *Server) handleCreateSegment(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	segReq, ok := decodeSegmentRequest(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec(`
+		INSERT INTO segment (
+			owner, name, description, expression, dt_store
+		) VALUES (
+			?, ?, ?, ?, ?
+		)
+	`, username, segReq.Name, nullString(segReq.Description), segReq.Expression, time.Now())
+	if isDuplicateKey(err) {
+		sendErrorResponse(w, http.StatusConflict, "Segment already exists", fmt.Sprintf("There is already a segment named %q", segReq.Name))
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting segment: %v", err))
+		return
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", "Error retrieving segment ID")
+		return
+	}
+
+	seg, err := s.loadSegment(username, int(lastID))
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading segment: %v", err))
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/segments/%d", seg.ID))
+	sendJSONResponse(w, http.StatusCreated, seg)
+}
+
+// handleUpdateSegment replaces a segment. Broadcasts not yet expanded pick up
+// the new expression.
+func (s *Server) handleUpdateSegment(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	segmentID, ok := segmentIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	segReq, ok := decodeSegmentRequest(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE segment
+		SET name = ?,
+			description = ?,
+			expression = ?,
+			dt_update = ?
+		WHERE id = ? AND owner = ?
+	`, segReq.Name, nullString(segReq.Description), segReq.Expression, time.Now(), segmentID, username)
+	if isDuplicateKey(err) {
+		sendErrorResponse(w, http.StatusConflict, "Segment already exists", fmt.Sprintf("There is already a segment named %q", segReq.Name))
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error updating segment: %v", err))
+		return
+	}
+
+	seg, err := s.loadSegment(username, segmentID)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Segment not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading segment: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, seg)
+}
+
+// handleDeleteSegment deletes a segment. Broadcasts not yet expanded skip it.
+func (s *Server) handleDeleteSegment(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	segmentID, ok := segmentIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec("DELETE FROM segment WHERE id = ? AND owner = ?", segmentID, username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error deleting segment: %v", err))
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Segment not found", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCountSegment returns the audience size of a saved segment
+func (s *Server) handleCountSegment(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	segmentID, ok := segmentIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	seg, err := s.loadSegment(username, segmentID)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Segment not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading segment: %v", err))
+		return
+	}
+
+	expr, err := segment.Parse(seg.Expression)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Invalid expression", err.Error())
+		return
+	}
+
+	s.countAudience(w, username, expr)
+}
+
+// handleCountExpression returns the audience size of an expression before it
+// is saved
+func (s *Server) handleCountExpression(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	var countReq models.SegmentCountRequest
+	if err := json.NewDecoder(r.Body).Decode(&countReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return
+	}
+
+	expr, err := segment.Parse(countReq.Expression)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid expression", err.Error())
+		return
+	}
+
+	s.countAudience(w, username, expr)
+}
@@ -7,8 +7,10 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/attachment"
 	"github.com/partadox/wags_queue/internal/auth"
 	"github.com/partadox/wags_queue/internal/config"
+	"github.com/partadox/wags_queue/internal/jobs"
 )
 
 // Server represents the API server
@@ -17,10 +19,15 @@ type Server struct {
 	router *mux.Router
 	db     *sql.DB
 	auth   *auth.Authenticator
+	cfg    *config.Config
+
+	attachments *attachment.Store
+	signer      *attachment.Signer
+	jobs        *jobs.Store
 }
 
 // NewServer creates a new API server
-func NewServer(cfg *config.Config, db *sql.DB) *Server {
+func NewServer(cfg *config.Config, db *sql.DB, attachments *attachment.Store, jobStore *jobs.Store) *Server {
 	router := mux.NewRouter()
 	
 	server := &Server{
@@ -34,6 +41,11 @@ func NewServer(cfg *config.Config, db *sql.DB) *Server {
 		router: router,
 		db:     db,
 		auth:   auth.NewAuthenticator(db, cfg.Auth),
+		cfg:    cfg,
+
+		attachments: attachments,
+		signer:      attachment.NewSigner(cfg.Attachment),
+		jobs:        jobStore,
 	}
 
 	// Set up routes
@@ -54,15 +66,131 @@ func (s *Server) setupRoutes() {
 	// Message routes (authentication required)
 	messageRoutes := api.PathPrefix("/messages").Subrouter()
 	messageRoutes.Use(s.auth.Middleware)
-	messageRoutes.HandleFunc("/send", s.handleSendMessage).Methods("POST")
-	messageRoutes.HandleFunc("/send-bulk", s.handleSendBulkMessage).Methods("POST")
+	messageRoutes.HandleFunc("/send", s.idempotent(s.handleSendMessage)).Methods("POST")
+	messageRoutes.HandleFunc("/send-bulk", s.idempotent(s.handleSendBulkMessage)).Methods("POST")
+	messageRoutes.HandleFunc("/send-bulk/preview", s.handlePreviewBulkMessage).Methods("POST")
+	messageRoutes.HandleFunc("/send-bulk/upload", s.handleSendBulkUpload).Methods("POST")
+	messageRoutes.HandleFunc("/send-bulk/upload/preview", s.handlePreviewBulkUpload).Methods("POST")
+	messageRoutes.HandleFunc("/status", s.handleBatchMessageStatus).Methods("POST")
+	messageRoutes.HandleFunc("/{id:[0-9]+}", s.handleGetMessageStatus).Methods("GET")
+	
+	// Template routes (authentication required)
+	templateRoutes := api.PathPrefix("/templates").Subrouter()
+	templateRoutes.Use(s.auth.Middleware)
+	templateRoutes.HandleFunc("", s.handleListTemplates).Methods("GET")
+	templateRoutes.HandleFunc("", s.handleCreateTemplate).Methods("POST")
+	templateRoutes.HandleFunc("/{id:[0-9]+}", s.handleGetTemplate).Methods("GET")
+	templateRoutes.HandleFunc("/{id:[0-9]+}", s.handleUpdateTemplate).Methods("PUT")
+	templateRoutes.HandleFunc("/{id:[0-9]+}", s.handleDeleteTemplate).Methods("DELETE")
+	templateRoutes.HandleFunc("/{id:[0-9]+}/preview", s.handlePreviewTemplate).Methods("POST")
+	
+	// Auto-reply rule routes (authentication required)
+	autoReplyRoutes := api.PathPrefix("/auto-replies").Subrouter()
+	autoReplyRoutes.Use(s.auth.Middleware)
+	autoReplyRoutes.HandleFunc("", s.handleListAutoReplies).Methods("GET")
+	autoReplyRoutes.HandleFunc("", s.handleCreateAutoReply).Methods("POST")
+	autoReplyRoutes.HandleFunc("/{id:[0-9]+}", s.handleGetAutoReply).Methods("GET")
+	autoReplyRoutes.HandleFunc("/{id:[0-9]+}", s.handleUpdateAutoReply).Methods("PUT")
+	autoReplyRoutes.HandleFunc("/{id:[0-9]+}", s.handleDeleteAutoReply).Methods("DELETE")
+	
+	// Flow routes (authentication required)
+	flowRoutes := api.PathPrefix("/flows").Subrouter()
+	flowRoutes.Use(s.auth.Middleware)
+	flowRoutes.HandleFunc("", s.handleListFlows).Methods("GET")
+	flowRoutes.HandleFunc("", s.handleCreateFlow).Methods("POST")
+	flowRoutes.HandleFunc("/{id:[0-9]+}", s.handleGetFlow).Methods("GET")
+	flowRoutes.HandleFunc("/{id:[0-9]+}", s.handleUpdateFlow).Methods("PUT")
+	flowRoutes.HandleFunc("/{id:[0-9]+}", s.handleDeleteFlow).Methods("DELETE")
+	
+	// Conversation routes (authentication required)
+	conversationRoutes := api.PathPrefix("/conversations").Subrouter()
+	conversationRoutes.Use(s.auth.Middleware)
+	conversationRoutes.HandleFunc("/{phone}", s.handleGetConversation).Methods("GET")
+	
+	// Suppression list routes (authentication required)
+	suppressionRoutes := api.PathPrefix("/suppressions").Subrouter()
+	suppressionRoutes.Use(s.auth.Middleware)
+	suppressionRoutes.HandleFunc("", s.handleListSuppressions).Methods("GET")
+	suppressionRoutes.HandleFunc("", s.handleCreateSuppression).Methods("POST")
+	suppressionRoutes.HandleFunc("/import", s.handleImportSuppressions).Methods("POST")
+	suppressionRoutes.HandleFunc("/{recipient}", s.handleGetSuppression).Methods("GET")
+	suppressionRoutes.HandleFunc("/{recipient}", s.handleUpdateSuppression).Methods("PUT")
+	suppressionRoutes.HandleFunc("/{recipient}", s.handleDeleteSuppression).Methods("DELETE")
+	
+	// Consent routes (authentication required)
+	consentRoutes := api.PathPrefix("/consents").Subrouter()
+	consentRoutes.Use(s.auth.Middleware)
+	consentRoutes.HandleFunc("", s.handleListConsents).Methods("GET")
+	consentRoutes.HandleFunc("", s.handleCreateConsent).Methods("POST")
+	consentRoutes.HandleFunc("/{id:[0-9]+}", s.handleGetConsent).Methods("GET")
+	consentRoutes.HandleFunc("/{id:[0-9]+}", s.handleRevokeConsent).Methods("DELETE")
+	
+	// Contact routes (authentication required)
+	contactRoutes := api.PathPrefix("/contacts").Subrouter()
+	contactRoutes.Use(s.auth.Middleware)
+	contactRoutes.HandleFunc("", s.handleListContacts).Methods("GET")
+	contactRoutes.HandleFunc("", s.handleCreateContact).Methods("POST")
+	contactRoutes.HandleFunc("/import", s.handleImportContacts).Methods("POST")
+	contactRoutes.HandleFunc("/{id:[0-9]+}", s.handleGetContact).Methods("GET")
+	contactRoutes.HandleFunc("/{id:[0-9]+}", s.handleUpdateContact).Methods("PUT")
+	contactRoutes.HandleFunc("/{id:[0-9]+}", s.handleDeleteContact).Methods("DELETE")
+	
+	// Contact group routes (authentication required)
+	contactGroupRoutes := api.PathPrefix("/contact-groups").Subrouter()
+	contactGroupRoutes.Use(s.auth.Middleware)
+	contactGroupRoutes.HandleFunc("", s.handleListContactGroups).Methods("GET")
+	contactGroupRoutes.HandleFunc("", s.handleCreateContactGroup).Methods("POST")
+	contactGroupRoutes.HandleFunc("/{id:[0-9]+}", s.handleGetContactGroup).Methods("GET")
+	contactGroupRoutes.HandleFunc("/{id:[0-9]+}", s.handleUpdateContactGroup).Methods("PUT")
+	contactGroupRoutes.HandleFunc("/{id:[0-9]+}", s.handleDeleteContactGroup).Methods("DELETE")
+	contactGroupRoutes.HandleFunc("/{id:[0-9]+}/members", s.handleAddContactGroupMembers).Methods("POST")
+	contactGroupRoutes.HandleFunc("/{id:[0-9]+}/members/{contact_id:[0-9]+}", s.handleRemoveContactGroupMember).Methods("DELETE")
+	
+	// Segment routes (authentication required)
+	segmentRoutes := api.PathPrefix("/segments").Subrouter()
+	segmentRoutes.Use(s.auth.Middleware)
+	segmentRoutes.HandleFunc("", s.handleListSegments).Methods("GET")
+	segmentRoutes.HandleFunc("", s.handleCreateSegment).Methods("POST")
+	segmentRoutes.HandleFunc("/count", s.handleCountExpression).Methods("POST")
+	segmentRoutes.HandleFunc("/{id:[0-9]+}", s.handleGetSegment).Methods("GET")
+	segmentRoutes.HandleFunc("/{id:[0-9]+}", s.handleUpdateSegment).Methods("PUT")
+	segmentRoutes.HandleFunc("/{id:[0-9]+}", s.handleDeleteSegment).Methods("DELETE")
+	segmentRoutes.HandleFunc("/{id:[0-9]+}/count", s.handleCountSegment).Methods("GET")
+	
+	// Attachment routes (authentication required)
+	attachmentRoutes := api.PathPrefix("/attachments").Subrouter()
+	attachmentRoutes.Use(s.auth.Middleware)
+	attachmentRoutes.HandleFunc("", s.handleUploadAttachment).Methods("POST")
+	attachmentRoutes.HandleFunc("/{id}", s.handleDeleteAttachment).Methods("DELETE")
+	
+	// Background export and report jobs (authentication required)
+	jobRoutes := api.PathPrefix("/jobs").Subrouter()
+	jobRoutes.Use(s.auth.Middleware)
+	jobRoutes.HandleFunc("", s.handleListJobs).Methods("GET")
+	jobRoutes.HandleFunc("", s.handleCreateJob).Methods("POST")
+	jobRoutes.HandleFunc("/{id:[0-9]+}", s.handleGetJob).Methods("GET")
+	jobRoutes.HandleFunc("/{id:[0-9]+}/cancel", s.handleCancelJob).Methods("POST")
+	jobRoutes.HandleFunc("/{id:[0-9]+}/download", s.handleDownloadJob).Methods("GET")
+	
+	// Signed attachment downloads for the gateway (no API key, URL is signed)
+	api.HandleFunc("/files/{id}", s.handleDownloadAttachment).Methods("GET")
+	
+	// Gateway webhooks (shared secret required)
+	webhookRoutes := api.PathPrefix("/webhooks").Subrouter()
+	webhookRoutes.Use(s.webhookMiddleware)
+	webhookRoutes.HandleFunc("/inbound", s.handleInboundWebhook).Methods("POST")
 	
 	// UI data routes (authentication required)
 	uiRoutes := api.PathPrefix("/ui").Subrouter()
 	uiRoutes.Use(s.auth.Middleware)
 	uiRoutes.HandleFunc("/messages", s.handleGetMessages).Methods("GET")
+	uiRoutes.HandleFunc("/messages/search", s.handleSearchMessages).Methods("GET")
+	uiRoutes.HandleFunc("/messages/export", s.handleExportMessages).Methods("GET")
 	uiRoutes.HandleFunc("/broadcasts", s.handleGetBroadcasts).Methods("GET")
 	uiRoutes.HandleFunc("/broadcasts/{bulk_id}/details", s.handleGetBroadcastDetails).Methods("GET")
+	uiRoutes.HandleFunc("/broadcasts/{bulk_id}/details/export", s.handleExportBroadcastDetails).Methods("GET")
+	uiRoutes.HandleFunc("/broadcasts/{bulk_id}/progress", s.handleGetBroadcastProgress).Methods("GET")
+	uiRoutes.HandleFunc("/broadcasts/{bulk_id}/hygiene", s.handleGetBroadcastHygiene).Methods("GET")
 	uiRoutes.HandleFunc("/years", s.handleGetAvailableYears).Methods("GET")
 	
 	// Static files for UI
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/phone"
+	"github.com/partadox/wags_queue/internal/suppression"
+)
+
+// maxSuppressionImportBytes limits the size of an uploaded suppression CSV
+const maxSuppressionImportBytes = 10 << 20
+
+// maxReasonLength matches the suppression.reason column
+const maxReasonLength = 255
+
+// recipientFromPath parses and normalizes the {recipient} route variable
+func (s *Server) recipientFromPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	recipient, err := phone.Normalize(mux.Vars(r)["recipient"], s.cfg.Phone.DefaultCountry)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid phone number", err.Error())
+		return "", false
+	}
+	return recipient, true
+}
+
+// loadSuppression loads a suppression entry of the user
+func (s *Server) loadSuppression(username, recipient string) (*models.Suppression, error) {
+	var entry models.Suppression
+	var reason sql.NullString
+
+	err := s.db.QueryRow(`
+		SELECT id, recipient, reason, source, dt_store
+		FROM suppression
+		WHERE sender = ? AND recipient = ?
+	`, username, recipient).Scan(&entry.ID, &entry.Recipient, &reason, &entry.Source, &entry.DTStore)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Reason = reason.String
+	return &entry, nil
+}
+
+// handleListSuppressions lists the suppression list of the authenticated user
+func (s *Server) handleListSuppressions(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	query := `
+		SELECT id, recipient, reason, source, dt_store
+		FROM suppression
+		WHERE sender = ?
+	`
+	args := []interface{}{username}
+
+	// Optional filter on the start of the number
+	if prefix := strings.TrimPrefix(r.URL.Query().Get("recipient"), "+"); prefix != "" {
+		query += " AND recipient LIKE ?"
+		args = append(args, prefix+"%")
+	}
+	query += " ORDER BY dt_store DESC"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying suppressions: %v", err))
+		return
+	}
+	defer rows.Close()
+
+	entries := []*models.Suppression{}
+	for rows.Next() {
+		var entry models.Suppression
+		var reason sql.NullString
+
+		if err := rows.Scan(&entry.ID, &entry.Recipient, &reason, &entry.Source, &entry.DTStore); err != nil {
+			continue // Skip this row and continue with the next
+		}
+
+		entry.Reason = reason.String
+		entries = append(entries, &entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating suppressions: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, entries)
+}
+
+// handleGetSuppression returns a single suppression entry
+func (s *Server) handleGetSuppression(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	recipient, ok := s.recipientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := s.loadSuppression(username, recipient)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Suppression not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading suppression: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, entry)
+}
+
+// handleCreateSuppression adds a recipient to the suppression list
+func (s *Server) handleCreateSuppression(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	var supReq models.SuppressionRequest
+	if err := json.NewDecoder(r.Body).Decode(&supReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return
+	}
+
+	if supReq.Recipient == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Recipient is required")
+		return
+	}
+
+	if len(supReq.Reason) > maxReasonLength {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid reason", fmt.Sprintf("Reason must be at most %d characters", maxReasonLength))
+		return
+	}
+
+	recipient, err := phone.Normalize(supReq.Recipient, s.cfg.Phone.DefaultCountry)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid phone number", err.Error())
+		return
+	}
+
+	created, err := suppression.Add(s.db, username, recipient, supReq.Reason, models.SuppressionSourceAPI)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	}
+
+	entry, err := s.loadSuppression(username, recipient)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading suppression: %v", err))
+		return
+	}
+
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK
+	}
+	sendJSONResponse(w, status, entry)
+}
+
+// handleUpdateSuppression changes the reason recorded for a suppression
+func (s *Server) handleUpdateSuppression(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	recipient, ok := s.recipientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var supReq models.SuppressionRequest
+	if err := json.NewDecoder(r.Body).Decode(&supReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return
+	}
+
+	if len(supReq.Reason) > maxReasonLength {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid reason", fmt.Sprintf("Reason must be at most %d characters", maxReasonLength))
+		return
+	}
+
+	if _, err := s.db.Exec(`
+		UPDATE suppression
+		SET reason = ?
+		WHERE sender = ? AND recipient = ?
+	`, supReq.Reason, username, recipient); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error updating suppression: %v", err))
+		return
+	}
+
+	entry, err := s.loadSuppression(username, recipient)
+	if err == sql.ErrNoRows {
+		sendErrorResponse(w, http.StatusNotFound, "Suppression not found", "")
+		return
+	} else if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading suppression: %v", err))
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, entry)
+}
+
+// handleDeleteSuppression removes a recipient from the suppression list
+func (s *Server) handleDeleteSuppression(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	recipient, ok := s.recipientFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := s.db.Exec(`
+		DELETE FROM suppression
+		WHERE sender = ? AND recipient = ?
+	`, username, recipient)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error deleting suppression: %v", err))
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Suppression not found", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleImportSuppressions adds every number of a CSV file to the suppression
+// list. The first column holds the number and an optional second column the
+// reason; a header row is skipped. The file is sent either as the "file" part
+// of a multipart form or as a text/csv body.
+func (s *Server) handleImportSuppressions(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSuppressionImportBytes)
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "A file part is required")
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	importResp := models.SuppressionImportResponse{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid CSV file", err.Error())
+			return
+		}
+
+		raw := strings.TrimSpace(record[0])
+		if raw == "" {
+			continue
+		}
+
+		recipient, err := phone.Normalize(raw, s.cfg.Phone.DefaultCountry)
+		if err != nil {
+			if line == 1 {
+				continue // Header row
+			}
+			importResp.Errors = append(importResp.Errors, models.RecipientError{
+				Index: line,
+				Phone: raw,
+				Error: err.Error(),
+			})
+			continue
+		}
+
+		var reason string
+		if len(record) > 1 {
+			reason = strings.TrimSpace(record[1])
+			if len(reason) > maxReasonLength {
+				reason = reason[:maxReasonLength]
+			}
+		}
+
+		created, err := suppression.Add(s.db, username, recipient, reason, models.SuppressionSourceImport)
+		if err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if created {
+			importResp.Imported++
+		} else {
+			importResp.Existing++
+		}
+	}
+
+	sendJSONResponse(w, http.StatusOK, importResp)
+}
@@ -0,0 +1,395 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/partadox/wags_queue/internal/auth"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/msgtemplate"
+)
+
+// loadTemplate loads a template owned by the user. It returns sql.ErrNoRows
+// when the template does not exist, belongs to someone else or was deleted.
+func (s *Server) loadTemplate(username string, templateID int) (*models.Template, error) {
+	var tpl models.Template
+	var variablesJSON []byte
+	var dtUpdate sql.NullTime
+	
+	err := s.db.QueryRow(`
+		SELECT id, name, body, variables, version, dt_store, dt_update 
+		FROM template 
+		WHERE id = ? AND owner = ? AND dt_delete IS NULL
+	`, templateID, username).Scan(
+		&tpl.ID,
+		&tpl.Name,
+		&tpl.Body,
+		&variablesJSON,
+		&tpl.Version,
+		&tpl.DTStore,
+		&dtUpdate,
+	)
+	if err != nil {
+		return nil, err
+	}
+	
+	if err := json.Unmarshal(variablesJSON, &tpl.Variables); err != nil {
+		return nil, fmt.Errorf("error decoding template variables: %w", err)
+	}
+	
+	if dtUpdate.Valid {
+		tpl.DTUpdate = &dtUpdate.Time
+	}
+	
+	return &tpl, nil
+}
+
+// templateFromRequest resolves the template referenced by a send request and
+// reports lookup failures to the client. It returns nil after writing an error.
+func (s *Server) templateFromRequest(w http.ResponseWriter, username string, templateID int) *models.Template {
+	tpl, err := s.loadTemplate(username, templateID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			sendErrorResponse(w, http.StatusBadRequest, "Template not found", fmt.Sprintf("Template %d does not exist", templateID))
+		} else {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading template: %v", err))
+		}
+		return nil
+	}
+	return tpl
+}
+
+// decodeTemplateRequest decodes and validates a create or update request
+func decodeTemplateRequest(w http.ResponseWriter, r *http.Request) (*models.TemplateRequest, bool) {
+	var tplReq models.TemplateRequest
+	
+	if err := json.NewDecoder(r.Body).Decode(&tplReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return nil, false
+	}
+	
+	if tplReq.Name == "" || tplReq.Body == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Name and body are required")
+		return nil, false
+	}
+	
+	// Declared variables default to the ones used in the body
+	if len(tplReq.Variables) == 0 {
+		tplReq.Variables = msgtemplate.Variables(tplReq.Body)
+	}
+	
+	if undeclared := msgtemplate.Undeclared(tplReq.Body, tplReq.Variables); len(undeclared) > 0 {
+		sendErrorResponse(w, http.StatusBadRequest, "Undeclared template variables", strings.Join(undeclared, ", "))
+		return nil, false
+	}
+	
+	return &tplReq, true
+}
+
+// templateIDFromPath parses the {id} route variable
+func templateIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	templateID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid template id", "")
+		return 0, false
+	}
+	return templateID, true
+}
+
+// handleListTemplates lists the templates of the authenticated user
+func (s *Server) handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+	
+	rows, err := s.db.Query(`
+		SELECT id, name, body, variables, version, dt_store, dt_update 
+		FROM template 
+		WHERE owner = ? AND dt_delete IS NULL 
+		ORDER BY name
+	`, username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error querying templates: %v", err))
+		return
+	}
+	defer rows.Close()
+	
+	templates := []*models.Template{}
+	for rows.Next() {
+		var tpl models.Template
+		var variablesJSON []byte
+		var dtUpdate sql.NullTime
+		
+		if err := rows.Scan(&tpl.ID, &tpl.Name, &tpl.Body, &variablesJSON, &tpl.Version, &tpl.DTStore, &dtUpdate); err != nil {
+			continue // Skip this row and continue with the next
+		}
+		
+		_ = json.Unmarshal(variablesJSON, &tpl.Variables)
+		if dtUpdate.Valid {
+			tpl.DTUpdate = &dtUpdate.Time
+		}
+		
+		templates = append(templates, &tpl)
+	}
+	
+	if err := rows.Err(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error iterating templates: %v", err))
+		return
+	}
+	
+	sendJSONResponse(w, http.StatusOK, templates)
+}
+
+// handleGetTemplate returns a single template
+func (s *Server) handleGetTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, ok := templateIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+	
+	tpl, err := s.loadTemplate(username, templateID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			sendErrorResponse(w, http.StatusNotFound, "Template not found", "")
+		} else {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading template: %v", err))
+		}
+		return
+	}
+	
+	sendJSONResponse(w, http.StatusOK, tpl)
+}
+
+// handleCreateTemplate creates version 1 of a new template
+func (s *Server) handleCreateTemplate(w http.ResponseWriter, r *http.Request) {
+	tplReq, ok := decodeTemplateRequest(w, r)
+	if !ok {
+		return
+	}
+	
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+	
+	variablesJSON, err := json.Marshal(tplReq.Variables)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error processing request", "")
+		return
+	}
+	
+	now := time.Now()
+	
+	tx, err := s.db.Begin()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error beginning transaction: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	
+	res, err := tx.Exec(`
+		INSERT INTO template (
+			owner, name, body, variables, version, dt_store
+		) VALUES (
+			?, ?, ?, ?, 1, ?
+		)
+	`, username, tplReq.Name, tplReq.Body, variablesJSON, now)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting template: %v", err))
+		return
+	}
+	
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", "Error retrieving template ID")
+		return
+	}
+	
+	_, err = tx.Exec(`
+		INSERT INTO template_version (
+			template_id, version, body, variables, dt_store
+		) VALUES (
+			?, 1, ?, ?, ?
+		)
+	`, lastID, tplReq.Body, variablesJSON, now)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting template version: %v", err))
+		return
+	}
+	
+	if err := tx.Commit(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error committing template: %v", err))
+		return
+	}
+	
+	sendJSONResponse(w, http.StatusCreated, models.Template{
+		ID:        int(lastID),
+		Name:      tplReq.Name,
+		Body:      tplReq.Body,
+		Variables: tplReq.Variables,
+		Version:   1,
+		DTStore:   now,
+	})
+}
+
+// handleUpdateTemplate stores a new version of a template. Earlier versions are
+// kept in template_version so messages can still be traced to what was sent.
+func (s *Server) handleUpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, ok := templateIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	
+	tplReq, ok := decodeTemplateRequest(w, r)
+	if !ok {
+		return
+	}
+	
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+	
+	variablesJSON, err := json.Marshal(tplReq.Variables)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Error processing request", "")
+		return
+	}
+	
+	now := time.Now()
+	
+	tx, err := s.db.Begin()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error beginning transaction: %v", err))
+		return
+	}
+	defer tx.Rollback()
+	
+	// Lock the row so concurrent updates get consecutive versions
+	var version int
+	err = tx.QueryRow(`
+		SELECT version 
+		FROM template 
+		WHERE id = ? AND owner = ? AND dt_delete IS NULL 
+		FOR UPDATE
+	`, templateID, username).Scan(&version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			sendErrorResponse(w, http.StatusNotFound, "Template not found", "")
+		} else {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading template: %v", err))
+		}
+		return
+	}
+	version++
+	
+	_, err = tx.Exec(`
+		UPDATE template 
+		SET name = ?, 
+			body = ?, 
+			variables = ?, 
+			version = ?, 
+			dt_update = ? 
+		WHERE id = ?
+	`, tplReq.Name, tplReq.Body, variablesJSON, version, now, templateID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error updating template: %v", err))
+		return
+	}
+	
+	_, err = tx.Exec(`
+		INSERT INTO template_version (
+			template_id, version, body, variables, dt_store
+		) VALUES (
+			?, ?, ?, ?, ?
+		)
+	`, templateID, version, tplReq.Body, variablesJSON, now)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error inserting template version: %v", err))
+		return
+	}
+	
+	if err := tx.Commit(); err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error committing template: %v", err))
+		return
+	}
+	
+	tpl, err := s.loadTemplate(username, templateID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading template: %v", err))
+		return
+	}
+	
+	sendJSONResponse(w, http.StatusOK, tpl)
+}
+
+// handleDeleteTemplate soft-deletes a template so its versions stay auditable
+func (s *Server) handleDeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, ok := templateIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+	
+	res, err := s.db.Exec(`
+		UPDATE template 
+		SET dt_delete = ? 
+		WHERE id = ? AND owner = ? AND dt_delete IS NULL
+	`, time.Now(), templateID, username)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error deleting template: %v", err))
+		return
+	}
+	
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		sendErrorResponse(w, http.StatusNotFound, "Template not found", "")
+		return
+	}
+	
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePreviewTemplate renders a template with the given variables
+func (s *Server) handlePreviewTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, ok := templateIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	
+	var previewReq models.TemplatePreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&previewReq); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return
+	}
+	
+	// Get username from context (set by auth middleware)
+	username, _ := auth.GetUsername(r.Context())
+	
+	tpl, err := s.loadTemplate(username, templateID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			sendErrorResponse(w, http.StatusNotFound, "Template not found", "")
+		} else {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", fmt.Sprintf("Error loading template: %v", err))
+		}
+		return
+	}
+	
+	previewResp := models.TemplatePreviewResponse{
+		TemplateID: tpl.ID,
+		Version:    tpl.Version,
+		Missing:    msgtemplate.Missing(tpl.Body, previewReq.Variables),
+	}
+	
+	if len(previewResp.Missing) == 0 {
+		previewResp.Message, _ = msgtemplate.Render(tpl.Body, previewReq.Variables)
+	}
+	
+	sendJSONResponse(w, http.StatusOK, previewResp)
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/consent"
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/phone"
+	"github.com/partadox/wags_queue/internal/suppression"
+)
+
+// webhookMiddleware only lets through requests carrying the shared webhook secret
+func (s *Server) webhookMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := s.cfg.Webhook.Secret
+		provided := r.Header.Get("X-Webhook-Secret")
+		
+		if secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(provided)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleInboundWebhook receives messages the gateway got from recipients
+func (s *Server) handleInboundWebhook(w http.ResponseWriter, r *http.Request) {
+	var event models.InboundEvent
+	
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid request body", "")
+		return
+	}
+	
+	if event.Sender == "" || event.From == "" {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Sender and from are required")
+		return
+	}
+	
+	// Match the E.164 form used for stored recipients
+	from, err := phone.Normalize(event.From, s.cfg.Phone.DefaultCountry)
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid phone number", err.Error())
+		return
+	}
+	event.From = from
+	
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	
+	if event.Type == "" {
+		event.Type = models.InboundText
+	}
+	
+	if (event.Type == models.InboundButtonReply || event.Type == models.InboundListReply) &&
+		(event.Reply == nil || event.Reply.ID == "") {
+		sendErrorResponse(w, http.StatusBadRequest, "Missing required fields", "Reply id is required for interactive replies")
+		return
+	}
+	
+	// Every inbound message is kept for the conversation view
+	inboundID, duplicate, err := s.recordInbound(event)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	}
+	if duplicate {
+		sendJSONResponse(w, http.StatusOK, models.InboundEventResponse{
+			Status: "duplicate",
+			Info:   "Event was already received",
+		})
+		return
+	}
+	
+	switch event.Type {
+	case models.InboundButtonReply, models.InboundListReply:
+		if err := s.recordReply(event); err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		
+		inboundResp := models.InboundEventResponse{
+			InboundID: inboundID,
+			Status:    "recorded",
+			Info:      "Interactive reply recorded",
+		}
+		
+		// A menu bot may be waiting for this choice
+		s.answerWithFlow(event, event.Reply.ID, inboundID, &inboundResp)
+		
+		sendJSONResponse(w, http.StatusOK, inboundResp)
+		return
+	
+	case models.InboundText:
+		if !suppression.IsOptOut(event.Message, s.cfg.Webhook.OptOutKeywords) {
+			break
+		}
+		
+		// The recipient asked us to stop messaging them
+		reason := fmt.Sprintf("Opt-out keyword %q", strings.TrimSpace(event.Message))
+		if _, err := suppression.Add(s.db, event.Sender, event.From, reason, models.SuppressionSourceKeyword); err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		
+		// Opting out also withdraws any marketing consent
+		if _, err := consent.Revoke(s.db, event.Sender, event.From, event.Timestamp); err != nil {
+			sendErrorResponse(w, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		
+		sendJSONResponse(w, http.StatusOK, models.InboundEventResponse{
+			InboundID: inboundID,
+			Status:    "suppressed",
+			Info:      "Recipient added to the suppression list",
+		})
+		return
+	}
+	
+	inboundResp := models.InboundEventResponse{
+		InboundID: inboundID,
+		Status:    "recorded",
+		Info:      "Inbound message recorded",
+	}
+	
+	// Menu bots first, then canned answers; the inbound message is already
+	// stored, so a failure here is only logged
+	if event.Type == models.InboundText && !s.answerWithFlow(event, event.Message, inboundID, &inboundResp) {
+		ruleID, messageID, err := s.autoReply(event)
+		if err != nil {
+			log.Printf("Error answering inbound message (ID: %d): %v", inboundID, err)
+		} else if messageID != 0 {
+			inboundResp.AutoReplyID = ruleID
+			inboundResp.ReplyMessageID = messageID
+			inboundResp.Info = "Inbound message recorded and answered"
+		}
+	}
+	
+	sendJSONResponse(w, http.StatusOK, inboundResp)
+}
+
+// answerWithFlow runs the flow engine for an inbound message and reports
+// whether a flow took it. Errors are logged; the message is already stored.
+func (s *Server) answerWithFlow(event models.InboundEvent, input string, inboundID int, inboundResp *models.InboundEventResponse) bool {
+	flowID, messageID, handled, err := s.runFlow(event, input)
+	if err != nil {
+		log.Printf("Error running flow for inbound message (ID: %d): %v", inboundID, err)
+		return false
+	}
+	if !handled {
+		return false
+	}
+	
+	inboundResp.FlowID = flowID
+	inboundResp.ReplyMessageID = messageID
+	inboundResp.Info = "Inbound message recorded and handled by a flow"
+	return true
+}
+
+// recordInbound stores an inbound message. Events the gateway delivers again
+// with the same ID are reported as duplicates and not stored twice.
+func (s *Server) recordInbound(event models.InboundEvent) (int, bool, error) {
+	var externalID sql.NullString
+	if event.ID != "" {
+		externalID = sql.NullString{String: event.ID, Valid: true}
+	}
+	
+	var replyID, replyTitle sql.NullString
+	if event.Reply != nil {
+		replyID = sql.NullString{String: event.Reply.ID, Valid: true}
+		replyTitle = sql.NullString{String: event.Reply.Title, Valid: event.Reply.Title != ""}
+	}
+	
+	if externalID.Valid {
+		var exists bool
+		err := s.db.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM inbound_message WHERE sender = ? AND external_id = ?)
+		`, event.Sender, externalID).Scan(&exists)
+		if err != nil {
+			return 0, false, fmt.Errorf("error looking up inbound message: %w", err)
+		}
+		if exists {
+			return 0, true, nil
+		}
+	}
+	
+	res, err := s.db.Exec(`
+		INSERT INTO inbound_message (
+			sender, recipient, external_id, type, message, reply_id, reply_title, dt_receive, dt_store
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?, ?
+		)
+	`, event.Sender, event.From, externalID, event.Type, event.Message, replyID, replyTitle, event.Timestamp, time.Now())
+	if err != nil {
+		return 0, false, fmt.Errorf("error inserting inbound message: %w", err)
+	}
+	
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return 0, false, fmt.Errorf("error retrieving inbound message ID: %w", err)
+	}
+	
+	return int(lastID), false, nil
+}
+
+// recordReply stores a button or list reply, linked to the interactive
+// message it answers when that message can be found
+func (s *Server) recordReply(event models.InboundEvent) error {
+	var messageID sql.NullInt64
+	
+	// Replies answer the latest interactive message sent to this recipient
+	var candidateID int64
+	var payloadJSON []byte
+	err := s.db.QueryRow(`
+		SELECT id, payload 
+		FROM message 
+		WHERE sender = ? AND recipient = ? AND content_type = ? AND status = ? 
+		ORDER BY dt_send DESC 
+		LIMIT 1
+	`, event.Sender, event.From, models.ContentInteractive, models.StatusSent).Scan(&candidateID, &payloadJSON)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error looking up interactive message: %w", err)
+	}
+	
+	if err == nil {
+		var payload models.MessagePayload
+		if json.Unmarshal(payloadJSON, &payload) == nil && hasReplyID(payload.Interactive, event.Reply.ID) {
+			messageID = sql.NullInt64{Int64: candidateID, Valid: true}
+		}
+	}
+	
+	_, err = s.db.Exec(`
+		INSERT INTO message_reply (
+			message_id, sender, recipient, reply_type, reply_id, reply_title, dt_receive
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?
+		)
+	`, messageID, event.Sender, event.From, event.Type, event.Reply.ID, event.Reply.Title, event.Timestamp)
+	if err != nil {
+		return fmt.Errorf("error inserting reply: %w", err)
+	}
+	
+	return nil
+}
+
+// hasReplyID reports whether an interactive payload offers the given button or row ID
+func hasReplyID(interactive *models.InteractivePayload, replyID string) bool {
+	if interactive == nil {
+		return false
+	}
+	for _, button := range interactive.Buttons {
+		if button.ID == replyID {
+			return true
+		}
+	}
+	for _, section := range interactive.Sections {
+		for _, row := range section.Rows {
+			if row.ID == replyID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// insertReply queues an answer to an inbound message at high priority, so it
+// goes out ahead of broadcasts
+func insertReply(tx *sql.Tx, sender, recipient, content string, templateID, templateVersion sql.NullInt64, dtQueue time.Time) (int, error) {
+	res, err := tx.Exec(`
+		INSERT INTO message (
+			sender, recipient, status, dt_store, dt_queue, message, template_id, template_version, content_type, 
+			category, priority
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		)
+	`, sender, recipient, models.StatusPending, time.Now(), dtQueue, content, templateID, templateVersion,
+		models.ContentText, models.CategoryTransactional, models.PriorityHigh)
+	if err != nil {
+		return 0, fmt.Errorf("error queueing reply: %w", err)
+	}
+	
+	messageID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving reply message ID: %w", err)
+	}
+	
+	return int(messageID), nil
+}
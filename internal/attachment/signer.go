@@ -0,0 +1,55 @@
+package attachment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/config"
+)
+
+// Signer creates and checks short-lived download URLs for attachments
+type Signer struct {
+	key     []byte
+	baseURL string
+	ttl     time.Duration
+}
+
+// NewSigner creates a signer from the attachment configuration
+func NewSigner(cfg config.AttachmentConfig) *Signer {
+	return &Signer{
+		key:     []byte(cfg.SigningKey),
+		baseURL: cfg.PublicBaseURL,
+		ttl:     cfg.URLTTL,
+	}
+}
+
+// URL returns a download URL for an attachment that expires after the configured TTL
+func (s *Signer) URL(attachmentID string) string {
+	expires := time.Now().Add(s.ttl).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", s.signature(attachmentID, expires))
+	return fmt.Sprintf("%s/api/files/%s?%s", s.baseURL, url.PathEscape(attachmentID), query.Encode())
+}
+
+// Verify checks the expiry and signature of a download URL
+func (s *Signer) Verify(attachmentID, expires, signature string) bool {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+	expected := s.signature(attachmentID, expiresAt)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// signature computes the HMAC of an attachment ID and expiry
+func (s *Signer) signature(attachmentID string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s:%d", attachmentID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,173 @@
+package attachment
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/partadox/wags_queue/internal/config"
+)
+
+var (
+	ErrTooLarge        = errors.New("file too large")
+	ErrTypeNotAllowed  = errors.New("file type not allowed")
+	ErrEmptyFile       = errors.New("file is empty")
+	ErrInvalidChecksum = errors.New("invalid checksum")
+)
+
+// genericTypes are sniffed MIME types too vague to trust over the declared one
+var genericTypes = map[string]bool{
+	"application/octet-stream": true,
+	"application/zip":          true,
+	"text/plain":               true,
+}
+
+// Stored describes a file written to the store
+type Stored struct {
+	SHA256   string
+	Size     int64
+	MimeType string
+}
+
+// Store keeps uploaded files on local disk, addressed by their SHA-256 hash
+// so identical uploads share one file
+type Store struct {
+	dir      string
+	maxBytes int64
+	allowed  map[string]bool
+}
+
+// NewStore creates the store directory if needed
+func NewStore(cfg config.AttachmentConfig) (*Store, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating attachment directory: %w", err)
+	}
+
+	allowed := make(map[string]bool, len(cfg.AllowedTypes))
+	for _, mimeType := range cfg.AllowedTypes {
+		allowed[strings.ToLower(mimeType)] = true
+	}
+
+	return &Store{
+		dir:      cfg.Dir,
+		maxBytes: cfg.MaxBytes,
+		allowed:  allowed,
+	}, nil
+}
+
+// MaxBytes returns the largest file the store accepts
+func (s *Store) MaxBytes() int64 {
+	return s.maxBytes
+}
+
+// Save writes r to the store. The MIME type is sniffed from the content; the
+// declared type is only used when sniffing gives a generic answer.
+func (s *Store) Save(r io.Reader, declaredType string) (*Stored, error) {
+	buffered := bufio.NewReaderSize(r, 512)
+	head, err := buffered.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading upload: %w", err)
+	}
+	if len(head) == 0 {
+		return nil, ErrEmptyFile
+	}
+
+	mimeType := detectType(head, declaredType)
+	if !s.allowed[mimeType] {
+		return nil, fmt.Errorf("%w: %s", ErrTypeNotAllowed, mimeType)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(buffered, s.maxBytes+1))
+	closeErr := tmp.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error writing upload: %w", err)
+	}
+	if closeErr != nil {
+		return nil, fmt.Errorf("error writing upload: %w", closeErr)
+	}
+	if size > s.maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	final := s.path(sum)
+	if err := os.MkdirAll(filepath.Dir(final), 0o750); err != nil {
+		return nil, fmt.Errorf("error creating attachment directory: %w", err)
+	}
+	if _, err := os.Stat(final); err != nil {
+		if err := os.Rename(tmp.Name(), final); err != nil {
+			return nil, fmt.Errorf("error storing upload: %w", err)
+		}
+	}
+
+	return &Stored{SHA256: sum, Size: size, MimeType: mimeType}, nil
+}
+
+// Open opens a stored file by hash
+func (s *Store) Open(sum string) (*os.File, error) {
+	if !validSum(sum) {
+		return nil, ErrInvalidChecksum
+	}
+	return os.Open(s.path(sum))
+}
+
+// Exists reports whether the file of a hash is stored
+func (s *Store) Exists(sum string) bool {
+	if !validSum(sum) {
+		return false
+	}
+	_, err := os.Stat(s.path(sum))
+	return err == nil
+}
+
+// Remove deletes a stored file by hash
+func (s *Store) Remove(sum string) error {
+	if !validSum(sum) {
+		return ErrInvalidChecksum
+	}
+	err := os.Remove(s.path(sum))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// path returns where a file with the given hash lives, fanned out by prefix
+func (s *Store) path(sum string) string {
+	return filepath.Join(s.dir, sum[:2], sum)
+}
+
+// validSum reports whether sum looks like a hex SHA-256, so it is safe in a path
+func validSum(sum string) bool {
+	if len(sum) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(sum)
+	return err == nil
+}
+
+// detectType picks the MIME type of an upload from its first bytes
+func detectType(head []byte, declaredType string) string {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	declared, _, _ := mime.ParseMediaType(declaredType)
+
+	if genericTypes[sniffed] && declared != "" {
+		return strings.ToLower(declared)
+	}
+	return sniffed
+}
@@ -0,0 +1,22 @@
+package attachment
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// RemoveUnused deletes the file of sum when no attachment row refers to it.
+// The rows are read with a locking read inside tx and the file is removed
+// before tx commits, so an upload of the same content inserting its row
+// meanwhile waits for tx and then finds the file gone (see Exists).
+func RemoveUnused(tx *sql.Tx, store *Store, sum string) error {
+	var remaining int
+	err := tx.QueryRow("SELECT COUNT(*) FROM attachment WHERE sha256 = ? FOR UPDATE", sum).Scan(&remaining)
+	if err != nil {
+		return fmt.Errorf("error counting attachments for file %s: %w", sum, err)
+	}
+	if remaining > 0 {
+		return nil
+	}
+	return store.Remove(sum)
+}
@@ -0,0 +1,143 @@
+package autoreply
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Match types of a rule
+const (
+	MatchExact    = "exact"    // Whole message, ignoring case and surrounding spaces
+	MatchContains = "contains" // Anywhere in the message, ignoring case
+	MatchRegex    = "regex"    // Go regular expression; named groups become reply variables
+)
+
+// Rule decides whether an inbound text deserves an automatic reply
+type Rule struct {
+	MatchType   string
+	Pattern     string
+	ActiveFrom  string // "HH:MM", empty means always active
+	ActiveUntil string // "HH:MM", may be earlier than ActiveFrom to span midnight
+
+	re *regexp.Regexp
+}
+
+// Compile validates the rule and prepares it for matching
+func (r *Rule) Compile() error {
+	if strings.TrimSpace(r.Pattern) == "" {
+		return errors.New("pattern is required")
+	}
+
+	switch r.MatchType {
+	case MatchExact, MatchContains:
+	case MatchRegex:
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+		r.re = re
+	default:
+		return fmt.Errorf("unknown match type %q, expected %s, %s or %s", r.MatchType, MatchExact, MatchContains, MatchRegex)
+	}
+
+	if (r.ActiveFrom == "") != (r.ActiveUntil == "") {
+		return errors.New("active_from and active_until must be set together")
+	}
+	if r.ActiveFrom != "" {
+		if _, err := minuteOfDay(r.ActiveFrom); err != nil {
+			return fmt.Errorf("invalid active_from: %w", err)
+		}
+		if _, err := minuteOfDay(r.ActiveUntil); err != nil {
+			return fmt.Errorf("invalid active_until: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Match reports whether the text triggers the rule. For regex rules the
+// named groups of the match are returned as variables.
+func (r *Rule) Match(text string) (map[string]string, bool) {
+	text = strings.TrimSpace(text)
+
+	switch r.MatchType {
+	case MatchExact:
+		return nil, strings.EqualFold(text, strings.TrimSpace(r.Pattern))
+	case MatchContains:
+		return nil, strings.Contains(strings.ToLower(text), strings.ToLower(strings.TrimSpace(r.Pattern)))
+	case MatchRegex:
+		if r.re == nil {
+			return nil, false
+		}
+		match := r.re.FindStringSubmatch(text)
+		if match == nil {
+			return nil, false
+		}
+		vars := make(map[string]string)
+		for i, name := range r.re.SubexpNames() {
+			if name != "" {
+				vars[name] = match[i]
+			}
+		}
+		return vars, true
+	}
+	return nil, false
+}
+
+// Variables returns the names of the variables a match can set: the named
+// groups of a regex rule
+func (r *Rule) Variables() []string {
+	names := make([]string, 0)
+	if r.re == nil {
+		return names
+	}
+	for _, name := range r.re.SubexpNames() {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ActiveAt reports whether the rule applies at the given time of day
+func (r *Rule) ActiveAt(t time.Time) bool {
+	if r.ActiveFrom == "" {
+		return true
+	}
+
+	from, err := minuteOfDay(r.ActiveFrom)
+	if err != nil {
+		return false
+	}
+	until, err := minuteOfDay(r.ActiveUntil)
+	if err != nil {
+		return false
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	if from <= until {
+		return now >= from && now < until
+	}
+	return now >= from || now < until // Spans midnight
+}
+
+// minuteOfDay parses "HH:MM" into minutes since midnight
+func minuteOfDay(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("expected HH:MM, got %q", value)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("invalid hour in %q", value)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid minute in %q", value)
+	}
+	return hour*60 + minute, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -15,6 +16,13 @@ type Config struct {
 	DB          DBConfig
 	Auth        AuthConfig
 	ExternalAPI ExternalAPIConfig
+	Worker      WorkerConfig
+	Schedule    ScheduleConfig
+	Attachment  AttachmentConfig
+	Webhook     WebhookConfig
+	Phone       PhoneConfig
+	Idempotency IdempotencyConfig
+	Job         JobConfig
 }
 
 // ServerConfig holds HTTP server related configuration
@@ -46,6 +54,55 @@ type ExternalAPIConfig struct {
 	Key string
 }
 
+// WorkerConfig holds configuration for the background workers
+type WorkerConfig struct {
+	InstanceID       string        // Identifies this replica when claiming bulk messages
+	BulkConcurrency  int           // Maximum number of bulk messages expanded at the same time
+	BulkClaimTimeout time.Duration // Claims older than this are considered abandoned
+}
+
+// ScheduleConfig holds the defaults used when spreading broadcasts over days
+type ScheduleConfig struct {
+	DailyCap    int // Default messages per sender per day, 0 means unlimited
+	WindowStart int // Hour of day at which rolled-over broadcasts resume
+	WindowEnd   int // Hour of day after which no more messages are scheduled
+}
+
+// AttachmentConfig holds configuration for the local attachment store
+type AttachmentConfig struct {
+	Dir           string
+	MaxBytes      int64
+	AllowedTypes  []string      // Accepted MIME types
+	TTL           time.Duration // How long an uploaded file is kept
+	SigningKey    string        // HMAC key for download URLs
+	URLTTL        time.Duration // How long a signed download URL stays valid
+	PublicBaseURL string        // Base URL the gateway uses to reach this server
+}
+
+// WebhookConfig holds configuration for webhooks called by the gateway
+type WebhookConfig struct {
+	Secret         string   // Shared secret expected in the X-Webhook-Secret header
+	OptOutKeywords []string // Inbound texts that add the sender to the suppression list
+}
+
+// PhoneConfig holds configuration for recipient phone number normalization
+type PhoneConfig struct {
+	DefaultCountry string // Calling code used for numbers starting with 0
+}
+
+// IdempotencyConfig holds configuration for Idempotency-Key handling
+type IdempotencyConfig struct {
+	TTL time.Duration // How long a stored response is replayed for the same key
+}
+
+// JobConfig holds configuration for background export and report jobs
+type JobConfig struct {
+	Dir         string        // Where result files are written; shared by all replicas
+	Concurrency int           // Maximum number of jobs run at the same time by this replica
+	MaxPerUser  int           // Maximum number of running jobs per user
+	ResultTTL   time.Duration // How long a finished job and its file are kept
+}
+
 // Load loads configuration from environment variables (.env file)
 func Load() (*Config, error) {
 	// Load .env file if it exists
@@ -72,10 +129,67 @@ func Load() (*Config, error) {
 	externalAPIURL := getEnv("EXTERNAL_API_URL", "https://wag.artakusuma.com/api/clients")
 	externalAPIKey := getEnv("EXTERNAL_API_KEY", "changeme")
 
+	// Worker config
+	instanceID := getEnv("WORKER_ID", defaultInstanceID())
+	bulkConcurrency, _ := strconv.Atoi(getEnv("BULK_MAX_CONCURRENCY", "4"))
+	bulkClaimTimeout, _ := strconv.Atoi(getEnv("BULK_CLAIM_TIMEOUT", "600")) // seconds
+
+	// Schedule config
+	dailyCap, _ := strconv.Atoi(getEnv("SENDER_DAILY_CAP", "0"))
+	windowStart, _ := strconv.Atoi(getEnv("SEND_WINDOW_START", "0"))
+	windowEnd, _ := strconv.Atoi(getEnv("SEND_WINDOW_END", "24"))
+
+	// Attachment config
+	attachmentDir := getEnv("ATTACHMENT_DIR", "./data/attachments")
+	attachmentMaxMB, _ := strconv.Atoi(getEnv("ATTACHMENT_MAX_MB", "16"))
+	attachmentTypes := getEnv("ATTACHMENT_ALLOWED_TYPES",
+		"image/jpeg,image/png,image/webp,application/pdf,audio/mpeg,audio/ogg,video/mp4")
+	attachmentTTL, _ := strconv.Atoi(getEnv("ATTACHMENT_TTL", "168"))        // hours
+	attachmentURLTTL, _ := strconv.Atoi(getEnv("ATTACHMENT_URL_TTL", "300")) // seconds
+	attachmentKey := getEnv("ATTACHMENT_SIGNING_KEY", jwtSecret)
+	publicBaseURL := strings.TrimRight(getEnv("PUBLIC_BASE_URL", "http://localhost:"+port), "/")
+
+	// Webhook config
+	webhookSecret := getEnv("WEBHOOK_SECRET", "")
+	optOutKeywords := getEnv("OPT_OUT_KEYWORDS", "STOP,BERHENTI,UNSUBSCRIBE")
+
+	// Phone config
+	defaultCountry := strings.TrimPrefix(getEnv("PHONE_DEFAULT_COUNTRY", "62"), "+")
+
+	// Idempotency config
+	idempotencyTTL, _ := strconv.Atoi(getEnv("IDEMPOTENCY_TTL", "24")) // hours
+
+	// Job config
+	jobDir := getEnv("JOB_DIR", "./data/jobs")
+	jobConcurrency, _ := strconv.Atoi(getEnv("JOB_MAX_CONCURRENCY", "2"))
+	jobMaxPerUser, _ := strconv.Atoi(getEnv("JOB_MAX_PER_USER", "1"))
+	jobResultTTL, _ := strconv.Atoi(getEnv("JOB_RESULT_TTL", "24")) // hours
+
+	if windowStart < 0 || windowEnd > 24 || windowStart >= windowEnd {
+		return nil, fmt.Errorf("invalid send window: SEND_WINDOW_START (%d) and SEND_WINDOW_END (%d) must be hours with 0 <= start < end <= 24",
+			windowStart, windowEnd)
+	}
+
+	if bulkConcurrency < 1 {
+		bulkConcurrency = 1
+	}
+
+	if jobConcurrency < 1 {
+		jobConcurrency = 1
+	}
+
+	if jobMaxPerUser < 1 {
+		jobMaxPerUser = 1
+	}
+
 	if jwtSecret == "your-secret-key" {
 		fmt.Println("WARNING: Using default JWT secret key. This is insecure. Set JWT_SECRET environment variable.")
 	}
 
+	if webhookSecret == "" {
+		fmt.Println("WARNING: WEBHOOK_SECRET is not set. Inbound webhooks from the gateway will be rejected.")
+	}
+
 	return &Config{
 		Server: ServerConfig{
 			Port:         port,
@@ -98,6 +212,41 @@ func Load() (*Config, error) {
 			URL: externalAPIURL,
 			Key: externalAPIKey,
 		},
+		Worker: WorkerConfig{
+			InstanceID:       instanceID,
+			BulkConcurrency:  bulkConcurrency,
+			BulkClaimTimeout: time.Duration(bulkClaimTimeout) * time.Second,
+		},
+		Schedule: ScheduleConfig{
+			DailyCap:    dailyCap,
+			WindowStart: windowStart,
+			WindowEnd:   windowEnd,
+		},
+		Attachment: AttachmentConfig{
+			Dir:           attachmentDir,
+			MaxBytes:      int64(attachmentMaxMB) << 20,
+			AllowedTypes:  splitList(attachmentTypes),
+			TTL:           time.Duration(attachmentTTL) * time.Hour,
+			SigningKey:    attachmentKey,
+			URLTTL:        time.Duration(attachmentURLTTL) * time.Second,
+			PublicBaseURL: publicBaseURL,
+		},
+		Webhook: WebhookConfig{
+			Secret:         webhookSecret,
+			OptOutKeywords: splitList(optOutKeywords),
+		},
+		Phone: PhoneConfig{
+			DefaultCountry: defaultCountry,
+		},
+		Idempotency: IdempotencyConfig{
+			TTL: time.Duration(idempotencyTTL) * time.Hour,
+		},
+		Job: JobConfig{
+			Dir:         jobDir,
+			Concurrency: jobConcurrency,
+			MaxPerUser:  jobMaxPerUser,
+			ResultTTL:   time.Duration(jobResultTTL) * time.Hour,
+		},
 	}, nil
 }
 
@@ -109,3 +258,23 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// splitList splits a comma separated value, dropping empty items
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// defaultInstanceID builds a replica identifier from the hostname and process ID
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
@@ -0,0 +1,77 @@
+package consent
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// lookupChunk bounds the number of placeholders in a single IN query
+const lookupChunk = 500
+
+// Consented returns the subset of phones that hold valid marketing consent for
+// the sender at the given time: recorded, not revoked and not expired
+func Consented(db *sql.DB, sender string, phones []string, at time.Time) (map[string]bool, error) {
+	consented := make(map[string]bool)
+
+	for start := 0; start < len(phones); start += lookupChunk {
+		end := start + lookupChunk
+		if end > len(phones) {
+			end = len(phones)
+		}
+		chunk := phones[start:end]
+
+		args := make([]interface{}, 0, len(chunk)+3)
+		args = append(args, sender, at, at)
+		for _, phone := range chunk {
+			args = append(args, phone)
+		}
+
+		rows, err := db.Query(`
+			SELECT DISTINCT recipient 
+			FROM consent 
+			WHERE sender = ? AND dt_revoke IS NULL AND dt_consent <= ? 
+				AND (dt_expire IS NULL OR dt_expire > ?) 
+				AND recipient IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)
+		`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("error querying consent: %w", err)
+		}
+
+		for rows.Next() {
+			var recipient string
+			if err := rows.Scan(&recipient); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning consent row: %w", err)
+			}
+			consented[recipient] = true
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating consent rows: %w", err)
+		}
+	}
+
+	return consented, nil
+}
+
+// Revoke withdraws every active consent the recipient gave the sender and
+// returns how many records were revoked
+func Revoke(db *sql.DB, sender, recipient string, at time.Time) (int64, error) {
+	res, err := db.Exec(`
+		UPDATE consent 
+		SET dt_revoke = ? 
+		WHERE sender = ? AND recipient = ? AND dt_revoke IS NULL
+	`, at, sender, recipient)
+	if err != nil {
+		return 0, fmt.Errorf("error revoking consent: %w", err)
+	}
+
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error revoking consent: %w", err)
+	}
+	return revoked, nil
+}
@@ -0,0 +1,333 @@
+// Package contacts resolves the contact groups and segments a broadcast is sent to
+package contacts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/msgtemplate"
+	"github.com/partadox/wags_queue/internal/segment"
+)
+
+// ErrInvalidSegment and ErrInvalidAttributes come from stored data that
+// resolving again will not fix, unlike database errors
+var (
+	ErrInvalidSegment    = errors.New("invalid segment expression")
+	ErrInvalidAttributes = errors.New("invalid contact attributes")
+)
+
+// NameVariable is the template variable filled with the contact name, unless
+// the contact has an attribute of the same name
+const NameVariable = "name"
+
+// Vars returns the template variables of a contact
+func Vars(name string, attributes map[string]string) map[string]string {
+	vars := make(map[string]string, len(attributes)+1)
+	if name != "" {
+		vars[NameVariable] = name
+	}
+	for key, value := range attributes {
+		vars[key] = value
+	}
+	return vars
+}
+
+// placeholders returns "?, ?, ..." for n arguments
+func placeholders(n int) string {
+	return "?" + strings.Repeat(", ?", n-1)
+}
+
+// MissingGroups returns the ids of groupIDs that do not exist or belong to
+// someone else
+func MissingGroups(db *sql.DB, owner string, groupIDs []int) ([]int, error) {
+	return missingIDs(db, "contact_group", owner, groupIDs)
+}
+
+// MissingSegments returns the ids of segmentIDs that do not exist or belong
+// to someone else
+func MissingSegments(db *sql.DB, owner string, segmentIDs []int) ([]int, error) {
+	return missingIDs(db, "segment", owner, segmentIDs)
+}
+
+// missingIDs returns the ids that have no row of the owner in table
+func missingIDs(db *sql.DB, table, owner string, ids []int) ([]int, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, owner)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := db.Query(`
+		SELECT id
+		FROM `+table+`
+		WHERE owner = ? AND id IN (`+placeholders(len(ids))+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	found := make(map[int]bool, len(ids))
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning %s row: %w", table, err)
+		}
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating %s rows: %w", table, err)
+	}
+
+	var missing []int
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+// Recipients returns every contact of the owner that belongs to at least one
+// of the groups, once, in the order the contacts were created. The contact
+// name and attributes become the variables of the recipient.
+func Recipients(db *sql.DB, owner string, groupIDs []int) ([]models.Recipient, error) {
+	if len(groupIDs) == 0 {
+		return nil, nil
+	}
+
+	args := make([]interface{}, 0, len(groupIDs)+2)
+	args = append(args, owner, owner)
+	for _, id := range groupIDs {
+		args = append(args, id)
+	}
+
+	rows, err := db.Query(`
+		SELECT c.phone, c.name, c.attributes
+		FROM contact c
+		WHERE c.owner = ? AND c.id IN (
+			SELECT m.contact_id
+			FROM contact_group_member m
+			JOIN contact_group g ON g.id = m.group_id
+			WHERE g.owner = ? AND m.group_id IN (`+placeholders(len(groupIDs))+`)
+		)
+		ORDER BY c.id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying group members: %w", err)
+	}
+	defer rows.Close()
+
+	recipients := make([]models.Recipient, 0)
+	for rows.Next() {
+		recipient, err := scanRecipient(rows)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating group member rows: %w", err)
+	}
+
+	return recipients, nil
+}
+
+// scanRecipient scans the phone, name and attributes of a contact into a
+// recipient whose variables are the name and attributes
+func scanRecipient(row interface{ Scan(...interface{}) error }) (models.Recipient, error) {
+	var phone string
+	var name sql.NullString
+	var attributesJSON []byte
+	if err := row.Scan(&phone, &name, &attributesJSON); err != nil {
+		return models.Recipient{}, fmt.Errorf("error scanning contact row: %w", err)
+	}
+
+	var attributes map[string]string
+	if len(attributesJSON) > 0 {
+		if err := json.Unmarshal(attributesJSON, &attributes); err != nil {
+			return models.Recipient{}, fmt.Errorf("%w: %s: %w", ErrInvalidAttributes, phone, err)
+		}
+	}
+
+	recipient := models.Recipient{Phone: phone}
+	if vars := Vars(name.String, attributes); len(vars) > 0 {
+		recipient.Vars = vars
+	}
+	return recipient, nil
+}
+
+// segmentFields returns what a segment expression sees of a contact: its
+// variables and its phone number
+func segmentFields(recipient models.Recipient) map[string]string {
+	fields := make(map[string]string, len(recipient.Vars)+1)
+	for key, value := range recipient.Vars {
+		fields[key] = value
+	}
+	fields["phone"] = recipient.Phone
+	return fields
+}
+
+// eachContact calls fn with every contact of the owner, oldest first
+func eachContact(db *sql.DB, owner string, fn func(models.Recipient)) error {
+	rows, err := db.Query(`
+		SELECT phone, name, attributes
+		FROM contact
+		WHERE owner = ?
+		ORDER BY id
+	`, owner)
+	if err != nil {
+		return fmt.Errorf("error querying contacts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		recipient, err := scanRecipient(rows)
+		if err != nil {
+			return err
+		}
+		fn(recipient)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating contact rows: %w", err)
+	}
+	return nil
+}
+
+// SegmentRecipients returns every contact of the owner that matches at least
+// one of the saved segments, once, in the order the contacts were created.
+// Segments deleted in the meantime are skipped.
+func SegmentRecipients(db *sql.DB, owner string, segmentIDs []int, now time.Time) ([]models.Recipient, error) {
+	if len(segmentIDs) == 0 {
+		return nil, nil
+	}
+
+	args := make([]interface{}, 0, len(segmentIDs)+1)
+	args = append(args, owner)
+	for _, id := range segmentIDs {
+		args = append(args, id)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, expression
+		FROM segment
+		WHERE owner = ? AND id IN (`+placeholders(len(segmentIDs))+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying segments: %w", err)
+	}
+	defer rows.Close()
+
+	exprs := make([]*segment.Expr, 0, len(segmentIDs))
+	for rows.Next() {
+		var id int
+		var source string
+		if err := rows.Scan(&id, &source); err != nil {
+			return nil, fmt.Errorf("error scanning segment row: %w", err)
+		}
+		expr, err := segment.Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("%w: segment %d: %w", ErrInvalidSegment, id, err)
+		}
+		exprs = append(exprs, expr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating segment rows: %w", err)
+	}
+	rows.Close()
+
+	recipients := make([]models.Recipient, 0)
+	if len(exprs) == 0 {
+		return recipients, nil
+	}
+
+	err = eachContact(db, owner, func(recipient models.Recipient) {
+		fields := segmentFields(recipient)
+		for _, expr := range exprs {
+			if expr.Match(fields, now) {
+				recipients = append(recipients, recipient)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recipients, nil
+}
+
+// Matching returns the contacts of the owner that match an expression, and
+// how many contacts the owner has
+func Matching(db *sql.DB, owner string, expr *segment.Expr, now time.Time) ([]models.Recipient, int, error) {
+	matched := make([]models.Recipient, 0)
+	var total int
+	err := eachContact(db, owner, func(recipient models.Recipient) {
+		total++
+		if expr.Match(segmentFields(recipient), now) {
+			matched = append(matched, recipient)
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return matched, total, nil
+}
+
+// Merge appends the group members to the explicit recipients of a broadcast.
+// A number listed explicitly keeps its own variables, with the contact
+// attributes filling the rest.
+func Merge(recipients, members []models.Recipient) []models.Recipient {
+	index := make(map[string]int, len(recipients))
+	merged := make([]models.Recipient, 0, len(recipients)+len(members))
+	for _, recipient := range recipients {
+		index[recipient.Phone] = len(merged)
+		merged = append(merged, recipient)
+	}
+
+	for _, member := range members {
+		i, ok := index[member.Phone]
+		if !ok {
+			index[member.Phone] = len(merged)
+			merged = append(merged, member)
+			continue
+		}
+		if len(member.Vars) > 0 {
+			merged[i].Vars = msgtemplate.Merge(member.Vars, merged[i].Vars)
+		}
+	}
+	return merged
+}
+
+// CountMembers returns how many distinct contacts belong to at least one of the groups
+func CountMembers(db *sql.DB, owner string, groupIDs []int) (int, error) {
+	if len(groupIDs) == 0 {
+		return 0, nil
+	}
+
+	args := make([]interface{}, 0, len(groupIDs)+1)
+	args = append(args, owner)
+	for _, id := range groupIDs {
+		args = append(args, id)
+	}
+
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(DISTINCT m.contact_id)
+		FROM contact_group_member m
+		JOIN contact_group g ON g.id = m.group_id
+		WHERE g.owner = ? AND m.group_id IN (`+placeholders(len(groupIDs))+`)
+	`, args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting group members: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,246 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formats an export can be written in
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// Spreadsheet limits of the XLSX format
+const (
+	maxXLSXRows      = 1048576
+	maxXLSXCellChars = 32767
+)
+
+// ErrTooManyRows is returned when an export does not fit in one XLSX sheet
+var ErrTooManyRows = errors.New("export exceeds the XLSX row limit")
+
+// Column is one column of an export
+type Column struct {
+	Header  string
+	Numeric bool // Written as a number in XLSX; everything else is text
+}
+
+// Writer writes rows of an export as they come, so large exports never sit
+// in memory. Flush pushes buffered rows to the underlying writer; Close must
+// be called to finish the file.
+type Writer interface {
+	WriteRow(values []string) error
+	Flush() error
+	Close() error
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// NewWriter starts an export in the given format and writes the header row
+func NewWriter(w io.Writer, format string, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatXLSX:
+		return newXLSXWriter(w, columns)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// csvWriter writes RFC 4180 CSV
+type csvWriter struct {
+	w       *csv.Writer
+	columns []Column
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), columns: columns}
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Header
+	}
+	return cw, cw.WriteRow(header)
+}
+
+func (cw *csvWriter) WriteRow(values []string) error {
+	for i, value := range values {
+		if i < len(cw.columns) && cw.columns[i].Numeric && isNumber(value) {
+			continue
+		}
+		values[i] = escapeFormula(value)
+	}
+	return cw.w.Write(values)
+}
+
+// escapeFormula keeps spreadsheet programs from running text such as message
+// bodies as a formula when a CSV export is opened. Such values get a leading
+// quote, which Excel shows as text.
+func escapeFormula(value string) string {
+	if value == "" {
+		return value
+	}
+	switch value[0] {
+	case '=', '+', '-', '@', '\t', '\r':
+		return "'" + value
+	}
+	return value
+}
+
+func (cw *csvWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *csvWriter) Close() error {
+	return cw.Flush()
+}
+
+// xlsxWriter writes a workbook with a single sheet. The sheet is streamed into
+// the zip archive row by row; the other parts are small and fixed.
+type xlsxWriter struct {
+	zip     *zip.Writer
+	sheet   *bufio.Writer
+	columns []Column
+	rows    int
+}
+
+// Fixed parts of the workbook
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+func newXLSXWriter(w io.Writer, columns []Column) (*xlsxWriter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	// The sheet is the last entry, so it can stay open while rows come in
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	xw := &xlsxWriter{zip: zw, sheet: bufio.NewWriter(f), columns: columns}
+	xw.sheet.WriteString(xml.Header)
+	xw.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	// The header row is always text
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Header
+	}
+	return xw, xw.writeRow(header, false)
+}
+
+func (xw *xlsxWriter) WriteRow(values []string) error {
+	return xw.writeRow(values, true)
+}
+
+func (xw *xlsxWriter) writeRow(values []string, typed bool) error {
+	if xw.rows == maxXLSXRows {
+		return ErrTooManyRows
+	}
+	xw.rows++
+
+	fmt.Fprintf(xw.sheet, `<row r="%d">`, xw.rows)
+	for i, value := range values {
+		ref := columnName(i) + strconv.Itoa(xw.rows)
+		if typed && i < len(xw.columns) && xw.columns[i].Numeric && isNumber(value) {
+			fmt.Fprintf(xw.sheet, `<c r="%s"><v>%s</v></c>`, ref, value)
+			continue
+		}
+		if value == "" {
+			continue
+		}
+		if len(value) > maxXLSXCellChars {
+			value = truncateRunes(value, maxXLSXCellChars)
+		}
+		fmt.Fprintf(xw.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+		if err := xml.EscapeText(xw.sheet, []byte(value)); err != nil {
+			return err
+		}
+		xw.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := xw.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (xw *xlsxWriter) Flush() error {
+	return xw.sheet.Flush()
+}
+
+func (xw *xlsxWriter) Close() error {
+	xw.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := xw.sheet.Flush(); err != nil {
+		return err
+	}
+	return xw.zip.Close()
+}
+
+// columnName turns a zero-based column index into A, B, ..., Z, AA, ...
+func columnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// isNumber reports whether a value can be written as a numeric cell
+func isNumber(value string) bool {
+	if value == "" {
+		return false
+	}
+	_, err := strconv.ParseFloat(value, 64)
+	return err == nil
+}
+
+// truncateRunes shortens a string to at most max characters
+func truncateRunes(value string, max int) string {
+	var b strings.Builder
+	count := 0
+	for _, r := range value {
+		if count == max {
+			break
+		}
+		b.WriteRune(r)
+		count++
+	}
+	return b.String()
+}
@@ -0,0 +1,240 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat is how exports write timestamps; spreadsheets read it as a date
+const TimeFormat = "2006-01-02 15:04:05"
+
+// flushRows is how often WriteMessages flushes and reports progress
+const flushRows = 500
+
+// MessageQuery selects the messages of an export. It is stored with
+// background jobs, so it only holds plain values.
+type MessageQuery struct {
+	Sender    string     `json:"sender"`
+	Statuses  []string   `json:"statuses,omitempty"`
+	Recipient string     `json:"recipient,omitempty"`
+	BulkID    int        `json:"bulk_id,omitempty"`
+	From      *time.Time `json:"from,omitempty"`
+	Until     *time.Time `json:"until,omitempty"` // Exclusive
+}
+
+// where returns the WHERE clause of the query and its arguments
+func (q MessageQuery) where() (string, []interface{}) {
+	conds := []string{"sender = ?"}
+	args := []interface{}{q.Sender}
+
+	if len(q.Statuses) > 0 {
+		conds = append(conds, "status IN (?"+strings.Repeat(", ?", len(q.Statuses)-1)+")")
+		for _, status := range q.Statuses {
+			args = append(args, status)
+		}
+	}
+	if q.Recipient != "" {
+		conds = append(conds, "recipient = ?")
+		args = append(args, q.Recipient)
+	}
+	if q.BulkID != 0 {
+		conds = append(conds, "type = ?")
+		args = append(args, strconv.Itoa(q.BulkID))
+	}
+	if q.From != nil {
+		conds = append(conds, "dt_store >= ?")
+		args = append(args, *q.From)
+	}
+	if q.Until != nil {
+		conds = append(conds, "dt_store < ?")
+		args = append(args, *q.Until)
+	}
+
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// Options are the format, columns and timezone of an export
+type Options struct {
+	Format  string   `json:"format"`
+	Columns []string `json:"columns,omitempty"`
+	TZ      string   `json:"tz,omitempty"` // IANA name; empty means server time
+}
+
+// DefaultColumns are exported when no columns are picked
+var DefaultColumns = []string{"id", "recipient", "status", "bulk_id", "dt_store", "dt_queue", "dt_send", "message"}
+
+// Validate fills in the defaults and checks the format, columns and timezone
+func (o *Options) Validate() error {
+	if o.Format == "" {
+		o.Format = FormatCSV
+	}
+	o.Format = strings.ToLower(o.Format)
+	if o.Format != FormatCSV && o.Format != FormatXLSX {
+		return fmt.Errorf("format must be %s or %s", FormatCSV, FormatXLSX)
+	}
+
+	// Columns are trimmed in place, so never hand out DefaultColumns itself
+	if len(o.Columns) == 0 {
+		o.Columns = append([]string(nil), DefaultColumns...)
+	}
+	for i, name := range o.Columns {
+		o.Columns[i] = strings.TrimSpace(name)
+		if _, ok := messageColumns[o.Columns[i]]; !ok {
+			return fmt.Errorf("unknown column %q", name)
+		}
+	}
+
+	if _, err := o.Location(); err != nil {
+		return fmt.Errorf("unknown time zone %q", o.TZ)
+	}
+	return nil
+}
+
+// Location returns the timezone dates are written in, the server's when
+// no TZ is given
+func (o *Options) Location() (*time.Location, error) {
+	if o.TZ == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(o.TZ)
+}
+
+// messageRow is one message as selected by messageColumnsSQL
+type messageRow struct {
+	ID            int
+	Recipient     string
+	Status        string
+	BulkID        sql.NullString
+	ContentType   string
+	Category      string
+	Attempts      int
+	FailureReason sql.NullString
+	DTStore       time.Time
+	DTQueue       time.Time
+	DTSend        sql.NullTime
+	Message       string
+}
+
+// messageColumnsSQL is the column list scanned into a messageRow
+const messageColumnsSQL = `id, recipient, status, type, content_type, category, attempts, failure_reason,
+	dt_store, dt_queue, dt_send, message`
+
+// messageColumn is a column that can be picked for a message export
+type messageColumn struct {
+	Column
+	value func(row *messageRow, loc *time.Location) string
+}
+
+// messageColumns are the columns of a message export by name
+var messageColumns = map[string]messageColumn{
+	"id": {Column{Header: "ID", Numeric: true}, func(row *messageRow, _ *time.Location) string {
+		return strconv.Itoa(row.ID)
+	}},
+	"recipient": {Column{Header: "Recipient"}, func(row *messageRow, _ *time.Location) string {
+		return row.Recipient
+	}},
+	"status": {Column{Header: "Status"}, func(row *messageRow, _ *time.Location) string {
+		return row.Status
+	}},
+	"bulk_id": {Column{Header: "Broadcast ID", Numeric: true}, func(row *messageRow, _ *time.Location) string {
+		return row.BulkID.String
+	}},
+	"content_type": {Column{Header: "Content Type"}, func(row *messageRow, _ *time.Location) string {
+		return row.ContentType
+	}},
+	"category": {Column{Header: "Category"}, func(row *messageRow, _ *time.Location) string {
+		return row.Category
+	}},
+	"attempts": {Column{Header: "Attempts", Numeric: true}, func(row *messageRow, _ *time.Location) string {
+		return strconv.Itoa(row.Attempts)
+	}},
+	"failure_reason": {Column{Header: "Failure Reason"}, func(row *messageRow, _ *time.Location) string {
+		return row.FailureReason.String
+	}},
+	"dt_store": {Column{Header: "Store Date"}, func(row *messageRow, loc *time.Location) string {
+		return row.DTStore.In(loc).Format(TimeFormat)
+	}},
+	"dt_queue": {Column{Header: "Queue Date"}, func(row *messageRow, loc *time.Location) string {
+		return row.DTQueue.In(loc).Format(TimeFormat)
+	}},
+	"dt_send": {Column{Header: "Send Date"}, func(row *messageRow, loc *time.Location) string {
+		if !row.DTSend.Valid {
+			return ""
+		}
+		return row.DTSend.Time.In(loc).Format(TimeFormat)
+	}},
+	"message": {Column{Header: "Message"}, func(row *messageRow, _ *time.Location) string {
+		return row.Message
+	}},
+}
+
+// WriteMessages streams the messages selected by q to out, oldest first.
+// Every few hundred rows the output is flushed and progress is called with
+// the number of rows written so far; an error from progress stops the export.
+// It returns the number of rows written.
+func WriteMessages(ctx context.Context, db *sql.DB, out io.Writer, q MessageQuery, opts Options,
+	progress func(rows int) error) (int, error) {
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+	loc, _ := opts.Location()
+
+	where, args := q.where()
+	rows, err := db.QueryContext(ctx, "SELECT "+messageColumnsSQL+" FROM message"+where+" ORDER BY dt_store, id", args...)
+	if err != nil {
+		return 0, fmt.Errorf("error querying messages: %w", err)
+	}
+	defer rows.Close()
+
+	columns := make([]messageColumn, len(opts.Columns))
+	headers := make([]Column, len(opts.Columns))
+	for i, name := range opts.Columns {
+		columns[i] = messageColumns[name]
+		headers[i] = columns[i].Column
+	}
+
+	writer, err := NewWriter(out, opts.Format, headers)
+	if err != nil {
+		return 0, err
+	}
+
+	values := make([]string, len(columns))
+	count := 0
+	for rows.Next() {
+		var row messageRow
+		if err := rows.Scan(&row.ID, &row.Recipient, &row.Status, &row.BulkID, &row.ContentType, &row.Category,
+			&row.Attempts, &row.FailureReason, &row.DTStore, &row.DTQueue, &row.DTSend, &row.Message); err != nil {
+			continue // Skip this row and continue with the next
+		}
+
+		for i, column := range columns {
+			values[i] = column.value(&row, loc)
+		}
+		if err := writer.WriteRow(values); err != nil {
+			return count, err
+		}
+
+		count++
+		if count%flushRows == 0 {
+			if err := writer.Flush(); err != nil {
+				return count, err
+			}
+			if progress != nil {
+				if err := progress(count); err != nil {
+					return count, err
+				}
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("error reading messages: %w", err)
+	}
+
+	return count, writer.Close()
+}
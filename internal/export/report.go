@@ -0,0 +1,93 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// reportStatuses are the status columns of the daily report, in order
+var reportStatuses = []string{"PENDING", "PROCESSING", "SENT", "FAILED", "SUPPRESSED"}
+
+// WriteDailyReport writes the number of messages per day and status for the
+// messages selected by q. Only the format and timezone of opts are used.
+//
+// Counts are grouped per hour in the database and added up per day in the
+// requested timezone, so zones with a half-hour offset are rounded to the hour.
+func WriteDailyReport(ctx context.Context, db *sql.DB, out io.Writer, q MessageQuery, opts Options) (int, error) {
+	opts.Columns = nil
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+	loc, _ := opts.Location()
+
+	where, args := q.where()
+	rows, err := db.QueryContext(ctx, `
+		SELECT DATE_FORMAT(dt_store, '%Y-%m-%d %H:00:00') AS hour, status, COUNT(*)
+		FROM message`+where+`
+		GROUP BY hour, status`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error querying messages: %w", err)
+	}
+	defer rows.Close()
+
+	days := map[string]map[string]int{}
+	for rows.Next() {
+		var hour, status string
+		var count int
+		if err := rows.Scan(&hour, &status, &count); err != nil {
+			continue // Skip this row and continue with the next
+		}
+
+		// The database stores server time
+		t, err := time.ParseInLocation(TimeFormat, hour, time.Local)
+		if err != nil {
+			continue
+		}
+		day := t.In(loc).Format("2006-01-02")
+		if days[day] == nil {
+			days[day] = map[string]int{}
+		}
+		days[day][status] += count
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error reading messages: %w", err)
+	}
+
+	columns := []Column{{Header: "Date"}, {Header: "Total", Numeric: true}}
+	for _, status := range reportStatuses {
+		columns = append(columns, Column{Header: status, Numeric: true})
+	}
+
+	writer, err := NewWriter(out, opts.Format, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	dates := make([]string, 0, len(days))
+	for day := range days {
+		dates = append(dates, day)
+	}
+	sort.Strings(dates)
+
+	for _, day := range dates {
+		total := 0
+		values := []string{day, ""}
+		for _, status := range reportStatuses {
+			total += days[day][status]
+			values = append(values, strconv.Itoa(days[day][status]))
+		}
+		values[1] = strconv.Itoa(total)
+
+		if err := writer.WriteRow(values); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(dates), writer.Close()
+}
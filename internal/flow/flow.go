@@ -0,0 +1,362 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/autoreply"
+	"github.com/partadox/wags_queue/internal/msgtemplate"
+)
+
+// maxChain bounds how many states are entered in one step, so a definition
+// whose states keep pointing at each other cannot loop forever
+const maxChain = 10
+
+// PhoneVariable holds the recipient's phone number from the start of every session
+const PhoneVariable = "phone"
+
+// DefaultTimeout ends a session after this much inactivity when neither the
+// flow nor the state sets a timeout
+const DefaultTimeout = 30 * time.Minute
+
+// Definition is a menu bot: a set of states the recipient moves through by replying
+type Definition struct {
+	Start          string            `json:"start"`
+	Trigger        *Match            `json:"trigger,omitempty"` // Inbound text that starts the flow; nil means any text
+	TimeoutSeconds int               `json:"timeout_seconds,omitempty"`
+	States         map[string]*State `json:"states"`
+}
+
+// State is one step of a flow. Its message is sent when the state is entered.
+type State struct {
+	Message        string        `json:"message,omitempty"`
+	Transitions    []*Transition `json:"transitions,omitempty"` // Tried in order against the reply
+	Capture        string        `json:"capture,omitempty"`     // Store any reply in this variable and go to Next
+	Next           string        `json:"next,omitempty"`        // Without transitions or capture: go on right away
+	Fallback       string        `json:"fallback,omitempty"`    // Sent when no transition matches; the state message is repeated otherwise
+	TimeoutSeconds int           `json:"timeout_seconds,omitempty"`
+	End            bool          `json:"end,omitempty"` // The session ends after the message is sent
+}
+
+// Transition moves to another state when the reply matches
+type Transition struct {
+	Match
+	Next string `json:"next"`
+}
+
+// Match is an exact, contains or regex pattern, as for auto-reply rules.
+// Regex named groups are captured into the session variables.
+type Match struct {
+	MatchType string `json:"match_type,omitempty"` // Defaults to exact
+	Pattern   string `json:"pattern"`
+
+	rule *autoreply.Rule
+}
+
+// Session is where a recipient currently is in a flow
+type Session struct {
+	State string
+	Vars  map[string]string
+}
+
+// Result is what a step produced: the messages to send and the new session
+type Result struct {
+	Messages []string
+	Session  Session
+	Ended    bool
+	Timeout  time.Duration // How long the session stays open waiting for the next reply
+}
+
+// Parse decodes and validates a flow definition
+func Parse(data []byte) (*Definition, error) {
+	var def Definition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, fmt.Errorf("invalid flow definition: %w", err)
+	}
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	return &def, nil
+}
+
+// Validate checks that the definition is complete and that every state
+// referenced exists, and compiles its patterns. It also rejects states that
+// go on by themselves in a loop, and messages using a variable that is not
+// set on every way into their state.
+func (d *Definition) Validate() error {
+	if len(d.States) == 0 {
+		return errors.New("flow needs at least one state")
+	}
+	if d.Start == "" {
+		return errors.New("start state is required")
+	}
+	if _, ok := d.States[d.Start]; !ok {
+		return fmt.Errorf("start state %q does not exist", d.Start)
+	}
+	if d.TimeoutSeconds < 0 {
+		return errors.New("timeout_seconds cannot be negative")
+	}
+	if d.Trigger != nil {
+		if err := d.Trigger.compile(); err != nil {
+			return fmt.Errorf("trigger: %w", err)
+		}
+	}
+
+	for name, state := range d.States {
+		if state == nil {
+			return fmt.Errorf("state %q is empty", name)
+		}
+		if state.TimeoutSeconds < 0 {
+			return fmt.Errorf("state %q: timeout_seconds cannot be negative", name)
+		}
+		if !state.End && state.Next == "" && len(state.Transitions) == 0 {
+			return fmt.Errorf("state %q needs transitions, next or end", name)
+		}
+		if state.Capture != "" && state.Next == "" {
+			return fmt.Errorf("state %q captures %q but has no next state", name, state.Capture)
+		}
+		if state.Next != "" {
+			if _, ok := d.States[state.Next]; !ok {
+				return fmt.Errorf("state %q: next state %q does not exist", name, state.Next)
+			}
+		}
+		for i, transition := range state.Transitions {
+			if err := transition.compile(); err != nil {
+				return fmt.Errorf("state %q transition %d: %w", name, i, err)
+			}
+			if _, ok := d.States[transition.Next]; !ok {
+				return fmt.Errorf("state %q transition %d: next state %q does not exist", name, i, transition.Next)
+			}
+		}
+	}
+
+	if err := d.checkChains(); err != nil {
+		return err
+	}
+	return d.checkVariables()
+}
+
+// goesOn reports whether a state moves to its next state without waiting for a reply
+func goesOn(state *State) bool {
+	return !state.End && len(state.Transitions) == 0 && state.Capture == "" && state.Next != ""
+}
+
+// checkChains rejects states that go on by themselves forever, or for more
+// states than a single step may enter
+func (d *Definition) checkChains() error {
+	for name := range d.States {
+		seen := make(map[string]bool)
+		current := name
+		for count := 1; goesOn(d.States[current]); count++ {
+			seen[current] = true
+			current = d.States[current].Next
+			if seen[current] {
+				return fmt.Errorf("state %q goes on to %q in a loop without waiting for a reply", name, current)
+			}
+			if count >= maxChain {
+				return fmt.Errorf("state %q passes through more than %d states without waiting for a reply", name, maxChain)
+			}
+		}
+	}
+	return nil
+}
+
+// checkVariables rejects messages that use a variable which is not set on
+// every way into their state: the phone, captured replies and regex groups
+// of the transitions taken
+func (d *Definition) checkVariables() error {
+	// Variables set on every way into a state; states never reached are absent
+	available := map[string]map[string]bool{
+		d.Start: {PhoneVariable: true},
+	}
+	pending := []string{d.Start}
+
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		state := d.States[name]
+
+		for _, edge := range d.edges(state) {
+			vars := make(map[string]bool, len(available[name])+len(edge.sets))
+			for v := range available[name] {
+				vars[v] = true
+			}
+			for _, v := range edge.sets {
+				vars[v] = true
+			}
+
+			known, reached := available[edge.next]
+			if !reached {
+				available[edge.next] = vars
+				pending = append(pending, edge.next)
+				continue
+			}
+			changed := false
+			for v := range known {
+				if !vars[v] {
+					delete(known, v)
+					changed = true
+				}
+			}
+			if changed {
+				pending = append(pending, edge.next)
+			}
+		}
+	}
+
+	for name, vars := range available {
+		state := d.States[name]
+		for _, text := range []string{state.Message, state.Fallback} {
+			for _, v := range msgtemplate.Variables(text) {
+				if !vars[v] {
+					return fmt.Errorf("state %q uses {{%s}}, which is not set on every way into the state", name, v)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// edge is a way out of a state and the variables set when taking it
+type edge struct {
+	next string
+	sets []string
+}
+
+// edges returns the ways out of a state, following the order Step and enter use
+func (d *Definition) edges(state *State) []edge {
+	switch {
+	case state.End:
+		return nil
+	case state.Capture != "":
+		return []edge{{next: state.Next, sets: []string{state.Capture}}}
+	case len(state.Transitions) > 0:
+		edges := make([]edge, 0, len(state.Transitions))
+		for _, transition := range state.Transitions {
+			edges = append(edges, edge{next: transition.Next, sets: transition.rule.Variables()})
+		}
+		return edges
+	}
+	return []edge{{next: state.Next}}
+}
+
+// Triggered reports whether an inbound text starts this flow
+func (d *Definition) Triggered(text string) bool {
+	if d.Trigger == nil {
+		return true
+	}
+	_, ok := d.Trigger.match(text)
+	return ok
+}
+
+// Begin starts a new session at the start state
+func (d *Definition) Begin(vars map[string]string) (*Result, error) {
+	return d.enter(d.Start, Session{Vars: msgtemplate.Merge(nil, vars)})
+}
+
+// Step feeds a reply to a session and moves it along
+func (d *Definition) Step(session Session, input string) (*Result, error) {
+	state, ok := d.States[session.State]
+	if !ok {
+		// The definition changed under the session; start over
+		return d.Begin(session.Vars)
+	}
+
+	session.Vars = msgtemplate.Merge(nil, session.Vars)
+
+	if state.Capture != "" {
+		session.Vars[state.Capture] = strings.TrimSpace(input)
+		return d.enter(state.Next, session)
+	}
+
+	for _, transition := range state.Transitions {
+		captured, ok := transition.match(input)
+		if !ok {
+			continue
+		}
+		session.Vars = msgtemplate.Merge(session.Vars, captured)
+		return d.enter(transition.Next, session)
+	}
+
+	// No transition matched: stay and say so
+	result := &Result{Session: session, Timeout: d.timeout(state)}
+	reply := state.Fallback
+	if reply == "" {
+		reply = state.Message
+	}
+	if reply != "" {
+		rendered, err := msgtemplate.Render(reply, session.Vars)
+		if err != nil {
+			return nil, fmt.Errorf("state %q: %w", session.State, err)
+		}
+		result.Messages = append(result.Messages, rendered)
+	}
+	return result, nil
+}
+
+// enter moves the session into a state and follows states that go on right away
+func (d *Definition) enter(name string, session Session) (*Result, error) {
+	result := &Result{}
+
+	for i := 0; i < maxChain; i++ {
+		state := d.States[name]
+		session.State = name
+
+		if state.Message != "" {
+			rendered, err := msgtemplate.Render(state.Message, session.Vars)
+			if err != nil {
+				return nil, fmt.Errorf("state %q: %w", name, err)
+			}
+			result.Messages = append(result.Messages, rendered)
+		}
+
+		result.Session = session
+		result.Timeout = d.timeout(state)
+
+		if state.End {
+			result.Ended = true
+			return result, nil
+		}
+
+		// Wait for the recipient unless the state goes on by itself
+		if len(state.Transitions) > 0 || state.Capture != "" || state.Next == "" {
+			return result, nil
+		}
+		name = state.Next
+	}
+
+	return nil, fmt.Errorf("flow passes through more than %d states without waiting for a reply", maxChain)
+}
+
+// timeout returns how long a session waits in a state
+func (d *Definition) timeout(state *State) time.Duration {
+	switch {
+	case state.TimeoutSeconds > 0:
+		return time.Duration(state.TimeoutSeconds) * time.Second
+	case d.TimeoutSeconds > 0:
+		return time.Duration(d.TimeoutSeconds) * time.Second
+	}
+	return DefaultTimeout
+}
+
+// compile validates the pattern of a match
+func (m *Match) compile() error {
+	if m.MatchType == "" {
+		m.MatchType = autoreply.MatchExact
+	}
+	m.rule = &autoreply.Rule{MatchType: m.MatchType, Pattern: m.Pattern}
+	return m.rule.Compile()
+}
+
+// match tests a reply against the pattern
+func (m *Match) match(text string) (map[string]string, bool) {
+	if m.rule == nil {
+		if err := m.compile(); err != nil {
+			return nil, false
+		}
+	}
+	return m.rule.Match(text)
+}
@@ -0,0 +1,51 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+
+	"github.com/partadox/wags_queue/internal/export"
+)
+
+// Kinds of background jobs
+const (
+	KindExportMessages  = "export_messages"  // Message history with the listing filters
+	KindExportBroadcast = "export_broadcast" // Messages of one bulk message
+	KindReportDaily     = "report_daily"     // Messages per day and status
+)
+
+// Kinds lists every job kind
+var Kinds = []string{KindExportMessages, KindExportBroadcast, KindReportDaily}
+
+// Params are stored with a job and tell the runner what to write
+type Params struct {
+	Query   export.MessageQuery `json:"query"`
+	Options export.Options      `json:"options"`
+}
+
+// Run writes the result of a job to out and returns the number of rows
+// written. progress is called every few hundred rows; an error from it stops
+// the job.
+func Run(ctx context.Context, db *sql.DB, kind string, params Params, out io.Writer, progress func(rows int) error) (int, error) {
+	switch kind {
+	case KindExportMessages, KindExportBroadcast:
+		return export.WriteMessages(ctx, db, out, params.Query, params.Options, progress)
+	case KindReportDaily:
+		return export.WriteDailyReport(ctx, db, out, params.Query, params.Options)
+	}
+	return 0, fmt.Errorf("unknown job kind %q", kind)
+}
+
+// Filename is the name a job result is downloaded as
+func Filename(id int, kind string, params Params) string {
+	prefix := "messages"
+	switch kind {
+	case KindExportBroadcast:
+		prefix = fmt.Sprintf("broadcast-%d", params.Query.BulkID)
+	case KindReportDaily:
+		prefix = "daily-report"
+	}
+	return fmt.Sprintf("%s-job%d.%s", prefix, id, params.Options.Format)
+}
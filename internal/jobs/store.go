@@ -0,0 +1,119 @@
+package jobs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/config"
+)
+
+// Store keeps job results on disk, one file per job. With several replicas
+// the directory must be shared, so any of them can serve and remove a result.
+type Store struct {
+	dir string
+}
+
+// NewStore creates the store directory if needed
+func NewStore(cfg config.JobConfig) (*Store, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating job directory: %w", err)
+	}
+	return &Store{dir: cfg.Dir}, nil
+}
+
+// Write stores the result of a job. write gets a temp file, which only
+// replaces the result once write returns without error. It returns the size
+// of the file.
+func (s *Store) Write(id int, format string, write func(w io.Writer) error) (int64, error) {
+	tmp, err := os.CreateTemp(s.dir, "job-*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("error creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	writeErr := write(tmp)
+	closeErr := tmp.Close()
+	if writeErr != nil {
+		return 0, writeErr
+	}
+	if closeErr != nil {
+		return 0, fmt.Errorf("error writing job result: %w", closeErr)
+	}
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return 0, fmt.Errorf("error writing job result: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(id, format)); err != nil {
+		return 0, fmt.Errorf("error storing job result: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// Open opens the result of a job
+func (s *Store) Open(id int, format string) (*os.File, error) {
+	return os.Open(s.path(id, format))
+}
+
+// Remove deletes the result of a job, if there is one
+func (s *Store) Remove(id int, format string) error {
+	err := os.Remove(s.path(id, format))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// path returns where the result of a job lives. format is validated before a
+// job is stored, so it is safe in a path.
+func (s *Store) path(id int, format string) string {
+	return filepath.Join(s.dir, fmt.Sprintf("job-%d.%s", id, format))
+}
+
+// OldFile is a file of the store that was last modified before a cutoff
+type OldFile struct {
+	Name  string
+	JobID int // 0 for a temp file left by an interrupted write
+}
+
+// OldFiles lists the result and temp files last modified before cutoff
+func (s *Store) OldFiles(cutoff time.Time) ([]OldFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing job directory: %w", err)
+	}
+
+	var old []OldFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "job-") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue // Removed meanwhile, or still recent
+		}
+
+		file := OldFile{Name: name}
+		if !strings.HasSuffix(name, ".tmp") {
+			if _, err := fmt.Sscanf(name, "job-%d.", &file.JobID); err != nil || file.JobID <= 0 {
+				continue // Not ours
+			}
+		}
+		old = append(old, file)
+	}
+	return old, nil
+}
+
+// RemoveFile deletes a file listed by OldFiles
+func (s *Store) RemoveFile(name string) error {
+	err := os.Remove(filepath.Join(s.dir, filepath.Base(name)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -3,85 +3,277 @@ package models
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
 // User represents a user in the system
 type User struct {
 	Username string `json:"username"`
-	Key string `json:"-"` // Never return API key in JSON responses
+	Key      string `json:"-"` // Never return API key in JSON responses
 }
 
 // MessageStatus represents the possible statuses of a message
 type MessageStatus string
 
+// ContentType represents the kind of content a message carries
+type ContentType string
+
 // BulkMessageStatus represents the possible statuses of a bulk message
 type BulkMessageStatus string
 
+// JobStatus represents the possible statuses of a background job
+type JobStatus string
+
+// MessageCategory tells transactional messages from marketing ones, which
+// need the recipient's consent
+type MessageCategory string
+
 const (
 	// Message statuses
 	StatusPending    MessageStatus = "PENDING"
 	StatusSent       MessageStatus = "SENT"
 	StatusFailed     MessageStatus = "FAILED"
 	StatusProcessing MessageStatus = "PROCESSING"
+	StatusSuppressed MessageStatus = "SUPPRESSED" // Recipient is on the sender's suppression list
+
+	// Message content types
+	ContentText        ContentType = "text"
+	ContentImage       ContentType = "image"
+	ContentDocument    ContentType = "document"
+	ContentAudio       ContentType = "audio"
+	ContentVideo       ContentType = "video"
+	ContentLocation    ContentType = "location"
+	ContentInteractive ContentType = "interactive"
+
+	// Message categories
+	CategoryTransactional MessageCategory = "transactional"
+	CategoryMarketing     MessageCategory = "marketing"
 
 	// Bulk message statuses
-	BulkStatusProcess BulkMessageStatus = "PROCESS"
-	BulkStatusDone    BulkMessageStatus = "DONE"
-	BulkStatusFailed  BulkMessageStatus = "FAILED"
+	BulkStatusProcess   BulkMessageStatus = "PROCESS"
+	BulkStatusExpanding BulkMessageStatus = "EXPANDING"
+	BulkStatusDone      BulkMessageStatus = "DONE"      // Messages created, not all of them sent yet
+	BulkStatusCompleted BulkMessageStatus = "COMPLETED" // Every message reached a final status
+	BulkStatusFailed    BulkMessageStatus = "FAILED"
+
+	// Background job statuses
+	JobStatusQueued    JobStatus = "QUEUED"
+	JobStatusRunning   JobStatus = "RUNNING"
+	JobStatusDone      JobStatus = "DONE"
+	JobStatusFailed    JobStatus = "FAILED"
+	JobStatusCancelled JobStatus = "CANCELLED"
+
+	// Suppression sources
+	SuppressionSourceAPI     = "api"
+	SuppressionSourceImport  = "import"
+	SuppressionSourceKeyword = "keyword" // Added from an inbound opt-out keyword
+)
+
+// Gateway limits for the message text, in characters
+const (
+	MaxCaptionLength   = 1024
+	MaxInteractiveBody = 1024
 )
 
+// CheckText checks the message text of this content type against the gateway
+// limits. Templates are checked again once rendered, since variables can make
+// the text longer.
+func (t ContentType) CheckText(text string) error {
+	switch t {
+	case ContentImage, ContentDocument, ContentAudio, ContentVideo:
+		if len([]rune(text)) > MaxCaptionLength {
+			return fmt.Errorf("caption must be at most %d characters", MaxCaptionLength)
+		}
+	case ContentInteractive:
+		if len([]rune(text)) > MaxInteractiveBody {
+			return fmt.Errorf("message must be at most %d characters for interactive messages", MaxInteractiveBody)
+		}
+	}
+	return nil
+}
+
 // Message represents an individual message
 type Message struct {
-	ID                 int           `json:"id"`
-	Sender             string        `json:"sender"`
-	Recipient          string        `json:"recipient"`
-	Status             MessageStatus `json:"status"`
-	Type               string        `json:"type"` // Reference to bulk message ID if part of a bulk
-	DTStore            time.Time     `json:"dt_store"`
-	DTQueue            time.Time     `json:"dt_queue"`
-	DTSend             sql.NullTime  `json:"dt_send,omitempty"`
-	MessageContent     string        `json:"message"`
-	ExternalAPIResponse sql.NullString `json:"external_api_response,omitempty"`
+	ID                  int             `json:"id"`
+	Sender              string          `json:"sender"`
+	Recipient           string          `json:"recipient"`
+	Status              MessageStatus   `json:"status"`
+	Type                string          `json:"type"` // Reference to bulk message ID if part of a bulk
+	DTStore             time.Time       `json:"dt_store"`
+	DTQueue             time.Time       `json:"dt_queue"`
+	DTSend              sql.NullTime    `json:"dt_send,omitempty"`
+	MessageContent      string          `json:"message"`
+	ExternalAPIResponse sql.NullString  `json:"external_api_response,omitempty"`
+	ContentType         ContentType     `json:"content_type"`
+	Payload             *MessagePayload `json:"payload,omitempty"`
+	Category            MessageCategory `json:"category"`
+	Priority            int             `json:"priority"` // Higher is sent first
+}
+
+// Message priorities
+const (
+	PriorityNormal = 0
+	PriorityHigh   = 10 // Automatic replies to inbound messages
+)
+
+// Kinds of interactive messages
+const (
+	InteractiveButtons = "buttons"
+	InteractiveList    = "list"
+)
+
+// InteractivePayload describes quick-reply buttons or a list menu. The body
+// text of the message is stored as the message text itself.
+type InteractivePayload struct {
+	Kind       string        `json:"kind"` // buttons or list
+	Header     string        `json:"header,omitempty"`
+	Footer     string        `json:"footer,omitempty"`
+	Buttons    []ReplyButton `json:"buttons,omitempty"`
+	ButtonText string        `json:"button_text,omitempty"` // Label of the button that opens a list
+	Sections   []ListSection `json:"sections,omitempty"`
+}
+
+// ReplyButton is a quick-reply button
+type ReplyButton struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// ListSection groups the rows of a list menu
+type ListSection struct {
+	Title string    `json:"title,omitempty"`
+	Rows  []ListRow `json:"rows"`
+}
+
+// ListRow is a selectable row of a list menu
+type ListRow struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// MessagePayload holds the non-text part of a message. The caption of a media
+// message is stored as the message text itself.
+type MessagePayload struct {
+	Media       *MediaPayload       `json:"media,omitempty"`
+	Location    *LocationPayload    `json:"location,omitempty"`
+	Interactive *InteractivePayload `json:"interactive,omitempty"`
+}
+
+// MediaPayload describes an image, document, audio or video attachment
+type MediaPayload struct {
+	URL          string `json:"url,omitempty"`
+	AttachmentID string `json:"attachment_id,omitempty"` // Uploaded attachment, used instead of url
+	Caption      string `json:"caption,omitempty"`
+	Filename     string `json:"filename,omitempty"`
+	MimeType     string `json:"mime_type,omitempty"`
+}
+
+// LocationPayload describes a location pin
+type LocationPayload struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
 }
 
 // MessageBulk represents a bulk message
 type MessageBulk struct {
-	ID        int              `json:"id"`
-	Sender    string           `json:"sender"`
+	ID        int               `json:"id"`
+	Sender    string            `json:"sender"`
 	Status    BulkMessageStatus `json:"status"`
-	DTStore   time.Time        `json:"dt_store"`
-	DTConvert sql.NullTime     `json:"dt_convert,omitempty"`
-	Bulk      json.RawMessage  `json:"bulk"` // JSON data representing the bulk message
+	DTStore   time.Time         `json:"dt_store"`
+	DTConvert sql.NullTime      `json:"dt_convert,omitempty"`
+	Bulk      json.RawMessage   `json:"bulk"`              // JSON data representing the bulk message
+	Hygiene   json.RawMessage   `json:"hygiene,omitempty"` // HygieneReport stored when the bulk was submitted
 }
 
 // MessageView is used for UI display of messages
 type MessageView struct {
-	ID              int     `json:"id"`
-	Recipient       string  `json:"recipient"`
-	Status          string  `json:"status"`
+	ID               int     `json:"id"`
+	Recipient        string  `json:"recipient"`
+	Status           string  `json:"status"`
 	BroadcastMessage string  `json:"broadcast_message"` // "YES" or "NO"
-	DTStore         string  `json:"dt_store"`
-	DTQueue         string  `json:"dt_queue"`
-	DTSend          *string `json:"dt_send,omitempty"`
-	Message         string  `json:"message"`
+	DTStore          string  `json:"dt_store"`
+	DTQueue          string  `json:"dt_queue"`
+	DTSend           *string `json:"dt_send,omitempty"`
+	Message          string  `json:"message"`
 }
 
 // MessageBulkView is used for UI display of bulk messages
 type MessageBulkView struct {
-	ID        int     `json:"id"`
-	Sender    string  `json:"sender"`
-	Status    string  `json:"status"`
-	DTStore   string  `json:"dt_store"`
-	DTConvert *string `json:"dt_convert,omitempty"`
+	ID            int                `json:"id"`
+	Sender        string             `json:"sender"`
+	Status        string             `json:"status"`
+	DTStore       string             `json:"dt_store"`
+	DTConvert     *string            `json:"dt_convert,omitempty"`
+	DTComplete    *string            `json:"dt_complete,omitempty"`
+	FailureReason *string            `json:"failure_reason,omitempty"` // Why a FAILED broadcast was not expanded
+	Progress      *BroadcastProgress `json:"progress,omitempty"`       // Set once the broadcast is expanded
+}
+
+// BroadcastProgress is how far the messages of a broadcast have got.
+// Finished messages are those in a final status: SENT, FAILED or SUPPRESSED.
+type BroadcastProgress struct {
+	BulkID              int               `json:"bulk_id"`
+	Status              BulkMessageStatus `json:"status"`
+	Total               int               `json:"total"`
+	StatusCounts        map[string]int    `json:"status_counts"`
+	Finished            int               `json:"finished"`
+	PercentComplete     float64           `json:"percent_complete"`
+	SendRate            float64           `json:"send_rate_per_minute"` // Between the first and the last send
+	FirstSend           *time.Time        `json:"first_send,omitempty"`
+	LastSend            *time.Time        `json:"last_send,omitempty"`
+	EstimatedCompletion *time.Time        `json:"estimated_completion,omitempty"` // Until COMPLETED
+	DTComplete          *time.Time        `json:"dt_complete,omitempty"`
+}
+
+// MessageListResponse is one page of the message history. NextCursor is empty
+// on the last page; Total and StatusCounts cover every page.
+type MessageListResponse struct {
+	Messages     []*MessageView `json:"messages"`
+	NextCursor   string         `json:"next_cursor,omitempty"`
+	Total        int            `json:"total"`
+	StatusCounts map[string]int `json:"status_counts"`
+}
+
+// BroadcastListResponse is one page of the broadcast history
+type BroadcastListResponse struct {
+	Broadcasts   []*MessageBulkView `json:"broadcasts"`
+	NextCursor   string             `json:"next_cursor,omitempty"`
+	Total        int                `json:"total"`
+	StatusCounts map[string]int     `json:"status_counts"`
+}
+
+// MessageSearchResult is a message found by a search, with the matching part
+// of its text
+type MessageSearchResult struct {
+	MessageView
+	Score   float64 `json:"score"`   // Full-text relevance; zero when only the recipient matched
+	Snippet string  `json:"snippet"` // HTML-escaped, matches wrapped in <mark>
+}
+
+// MessageSearchResponse is one page of search results, most relevant first
+type MessageSearchResponse struct {
+	Query   string                 `json:"query"`
+	Results []*MessageSearchResult `json:"results"`
+	HasMore bool                   `json:"has_more"`
 }
 
 // SingleMessageRequest represents a request to send a single message
 type SingleMessageRequest struct {
-	Recipient string    `json:"recipient"`
-	Sender    string    `json:"sender"`
-	Message   string    `json:"message"`
-	DTStore   time.Time `json:"dt_store"`
+	Recipient   string              `json:"recipient"`
+	Sender      string              `json:"sender"`
+	Message     string              `json:"message"`
+	TemplateID  *int                `json:"template_id,omitempty"` // Used instead of message
+	Variables   map[string]string   `json:"variables,omitempty"`
+	ContentType ContentType         `json:"type,omitempty"` // Defaults to text
+	Media       *MediaPayload       `json:"media,omitempty"`
+	Location    *LocationPayload    `json:"location,omitempty"`
+	Interactive *InteractivePayload `json:"interactive,omitempty"`
+	Category    MessageCategory     `json:"category,omitempty"` // Defaults to transactional
+	DTStore     time.Time           `json:"dt_store"`
 }
 
 // SingleMessageResponse represents a response to a single message request
@@ -92,25 +284,351 @@ type SingleMessageResponse struct {
 	Info      string        `json:"info"`
 }
 
+// MessageStatusView is the delivery state of one message, for integrations
+// tracking the messages they sent
+type MessageStatusView struct {
+	ID              int             `json:"id"`
+	Recipient       string          `json:"recipient"`
+	Status          MessageStatus   `json:"status"`
+	BulkID          *int            `json:"bulk_id,omitempty"` // Set when the message belongs to a broadcast
+	ContentType     ContentType     `json:"content_type"`
+	Category        MessageCategory `json:"category"`
+	Attempts        int             `json:"attempts"` // Number of times the worker picked the message up
+	FailureReason   string          `json:"failure_reason,omitempty"`
+	GatewayResponse json.RawMessage `json:"gateway_response,omitempty"`
+	DTStore         time.Time       `json:"dt_store"`
+	DTQueue         time.Time       `json:"dt_queue"`
+	DTSend          *time.Time      `json:"dt_send,omitempty"`
+}
+
+// MessageStatusRequest asks for the status of several messages at once
+type MessageStatusRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// MessageStatusResponse lists the messages found, in request order, and the
+// IDs that do not exist or belong to another user
+type MessageStatusResponse struct {
+	Messages []*MessageStatusView `json:"messages"`
+	NotFound []int                `json:"not_found"`
+}
+
 // BulkMessageRequest represents a request to send a bulk message
 type BulkMessageRequest struct {
-	Sender     string    `json:"sender"`
-	Recipients []string  `json:"recipients"`
-	Message    string    `json:"message"`
-	DTStore    time.Time `json:"dt_store"`
+	Sender      string              `json:"sender"`
+	Recipients  []Recipient         `json:"recipients"`
+	GroupIDs    []int               `json:"group_ids,omitempty"`   // Contact groups resolved when the broadcast is expanded
+	SegmentIDs  []int               `json:"segment_ids,omitempty"` // Saved segments resolved when the broadcast is expanded
+	Message     string              `json:"message"`
+	TemplateID  *int                `json:"template_id,omitempty"` // Used instead of message
+	Variables   map[string]string   `json:"variables,omitempty"`   // Defaults for every recipient
+	ContentType ContentType         `json:"type,omitempty"`        // Defaults to text
+	Media       *MediaPayload       `json:"media,omitempty"`
+	Location    *LocationPayload    `json:"location,omitempty"`
+	Interactive *InteractivePayload `json:"interactive,omitempty"`
+	Category    MessageCategory     `json:"category,omitempty"` // Defaults to transactional
+	DTStore     time.Time           `json:"dt_store"`
+	Pacing      *PacingOptions      `json:"pacing,omitempty"`
+}
+
+// Recipient is a broadcast recipient with the variables used to personalize its message
+type Recipient struct {
+	Phone string            `json:"phone"`
+	Vars  map[string]string `json:"vars,omitempty"`
+}
+
+// UnmarshalJSON accepts either a plain phone number string or a recipient object
+func (r *Recipient) UnmarshalJSON(data []byte) error {
+	var phone string
+	if err := json.Unmarshal(data, &phone); err == nil {
+		*r = Recipient{Phone: phone}
+		return nil
+	}
+
+	type recipient Recipient // Avoid recursing into this method
+	var obj recipient
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*r = Recipient(obj)
+	return nil
+}
+
+// RecipientError reports why a single recipient of a request was rejected
+type RecipientError struct {
+	Index   int      `json:"index"`
+	Phone   string   `json:"phone"`
+	Error   string   `json:"error"`
+	Missing []string `json:"missing,omitempty"` // Template variables without a value
+}
+
+// PacingOptions selects how the messages of a broadcast are spread over time
+type PacingOptions struct {
+	Strategy             string  `json:"strategy"` // natural (default), linear, jittered, burst or spread
+	IntervalSeconds      float64 `json:"interval_seconds,omitempty"`
+	Jitter               float64 `json:"jitter,omitempty"` // Fraction of the interval, 0-1
+	RatePerMinute        int     `json:"rate_per_minute,omitempty"`
+	BurstSize            int     `json:"burst_size,omitempty"`
+	BurstIntervalSeconds float64 `json:"burst_interval_seconds,omitempty"`
+	Hours                float64 `json:"hours,omitempty"`
+	Seed                 *int64  `json:"seed,omitempty"` // Fixes the random schedule, mainly for testing
 }
 
 // BulkMessageResponse represents a response to a bulk message request
 type BulkMessageResponse struct {
-	BulkMessageID int              `json:"bulk_message_id"`
+	BulkMessageID int               `json:"bulk_message_id"`
 	Status        BulkMessageStatus `json:"status"`
-	Info          string           `json:"info"`
+	Info          string            `json:"info"`
+	Hygiene       *HygieneReport    `json:"hygiene,omitempty"`
+}
+
+// HygieneReport summarizes how a broadcast recipient list was cleaned up
+type HygieneReport struct {
+	Submitted         int              `json:"submitted"`
+	Accepted          int              `json:"accepted"`
+	Duplicate         int              `json:"duplicate"`
+	Invalid           int              `json:"invalid"`
+	Blocked           int              `json:"blocked"`
+	NoConsent         int              `json:"no_consent"`        // Marketing only
+	MissingVariables  int              `json:"missing_variables"` // Group and segment members, counted when the broadcast is expanded
+	DuplicateExamples []string         `json:"duplicate_examples,omitempty"`
+	InvalidExamples   []RecipientError `json:"invalid_examples,omitempty"`
+	BlockedExamples   []string         `json:"blocked_examples,omitempty"`
+	NoConsentList     []string         `json:"no_consent_recipients,omitempty"`        // Every recipient excluded for missing consent
+	MissingVarsList   []string         `json:"missing_variables_recipients,omitempty"` // Every member excluded for a missing template variable
+}
+
+// DayAllocation describes how many messages of a broadcast are queued on one day
+type DayAllocation struct {
+	Date  string    `json:"date"` // YYYY-MM-DD
+	Count int       `json:"count"`
+	First time.Time `json:"first_queue"`
+	Last  time.Time `json:"last_queue"`
+}
+
+// BulkPreviewResponse shows how a broadcast would be scheduled before it is submitted
+type BulkPreviewResponse struct {
+	TotalRecipients int             `json:"total_recipients"`
+	DailyCap        int             `json:"daily_cap"` // 0 means unlimited
+	Days            []DayAllocation `json:"days"`
+	Hygiene         *HygieneReport  `json:"hygiene,omitempty"`
+}
+
+// BulkUploadPreviewResponse shows how an uploaded recipient file was read and
+// how the broadcast would be scheduled
+type BulkUploadPreviewResponse struct {
+	Columns     []string          `json:"columns"` // Header row of the file
+	PhoneColumn string            `json:"phone_column"`
+	Variables   map[string]string `json:"variables"` // Column name to template variable
+	Rows        int               `json:"rows"`      // Data rows, blank rows not counted
+	ErrorCount  int               `json:"error_count"`
+	ErrorRows   []RecipientError  `json:"error_rows"` // Index is the row number in the file
+	Sample      []Recipient       `json:"sample"`     // First recipients as they would be sent
+	BulkPreviewResponse
+}
+
+// Template is a reusable message body owned by a user
+type Template struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Body      string     `json:"body"`
+	Variables []string   `json:"variables"`
+	Version   int        `json:"version"`
+	DTStore   time.Time  `json:"dt_store"`
+	DTUpdate  *time.Time `json:"dt_update,omitempty"`
+}
+
+// TemplateRequest represents a request to create or update a template
+type TemplateRequest struct {
+	Name      string   `json:"name"`
+	Body      string   `json:"body"`
+	Variables []string `json:"variables"` // Derived from the body when empty
+}
+
+// TemplatePreviewRequest holds the variables used to render a template preview
+type TemplatePreviewRequest struct {
+	Variables map[string]string `json:"variables"`
+}
+
+// TemplatePreviewResponse shows a rendered template
+type TemplatePreviewResponse struct {
+	TemplateID int      `json:"template_id"`
+	Version    int      `json:"version"`
+	Message    string   `json:"message,omitempty"`
+	Missing    []string `json:"missing,omitempty"`
+}
+
+// Attachment is a file uploaded to the local attachment store
+type Attachment struct {
+	ID       string    `json:"id"`
+	Filename string    `json:"filename"`
+	MimeType string    `json:"mime_type"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	DTStore  time.Time `json:"dt_store"`
+	DTExpire time.Time `json:"dt_expire"`
+}
+
+// Inbound event types sent by the gateway
+const (
+	InboundText        = "text"
+	InboundButtonReply = "button_reply"
+	InboundListReply   = "list_reply"
+)
+
+// InboundEvent is a message received by the gateway and forwarded to our webhook
+type InboundEvent struct {
+	ID        string            `json:"id,omitempty"` // Gateway message ID, used to drop redelivered events
+	Sender    string            `json:"sender"`       // Our user whose number received the message
+	From      string            `json:"from"`         // Phone number of the person who wrote
+	Type      string            `json:"type"`         // text, button_reply or list_reply
+	Message   string            `json:"message"`
+	Reply     *InteractiveReply `json:"reply,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// InteractiveReply identifies the button or list row a recipient picked
+type InteractiveReply struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// InboundEventResponse acknowledges an inbound event
+type InboundEventResponse struct {
+	InboundID      int    `json:"inbound_id,omitempty"`
+	Status         string `json:"status"`
+	Info           string `json:"info"`
+	AutoReplyID    int    `json:"auto_reply_id,omitempty"`    // Rule that answered the message
+	FlowID         int    `json:"flow_id,omitempty"`          // Flow that answered the message
+	ReplyMessageID int    `json:"reply_message_id,omitempty"` // First queued reply
+}
+
+// AutoReplyRule answers matching inbound texts with a canned message
+type AutoReplyRule struct {
+	ID              int               `json:"id"`
+	Name            string            `json:"name"`
+	MatchType       string            `json:"match_type"` // exact, contains or regex
+	Pattern         string            `json:"pattern"`
+	ReplyMessage    string            `json:"reply_message,omitempty"`
+	TemplateID      *int              `json:"template_id,omitempty"` // Used instead of reply_message
+	Variables       map[string]string `json:"variables,omitempty"`
+	ActiveFrom      string            `json:"active_from,omitempty"`  // HH:MM
+	ActiveUntil     string            `json:"active_until,omitempty"` // HH:MM
+	CooldownSeconds int               `json:"cooldown_seconds"`       // Per recipient
+	Position        int               `json:"position"`               // Rules are tried in ascending order
+	Enabled         bool              `json:"enabled"`
+	DTStore         time.Time         `json:"dt_store"`
+	DTUpdate        *time.Time        `json:"dt_update,omitempty"`
+}
+
+// AutoReplyRuleRequest represents a request to create or update an auto-reply rule
+type AutoReplyRuleRequest struct {
+	Name            string            `json:"name"`
+	MatchType       string            `json:"match_type"`
+	Pattern         string            `json:"pattern"`
+	ReplyMessage    string            `json:"reply_message"`
+	TemplateID      *int              `json:"template_id,omitempty"`
+	Variables       map[string]string `json:"variables,omitempty"`
+	ActiveFrom      string            `json:"active_from,omitempty"`
+	ActiveUntil     string            `json:"active_until,omitempty"`
+	CooldownSeconds int               `json:"cooldown_seconds"`
+	Position        int               `json:"position"`
+	Enabled         *bool             `json:"enabled,omitempty"` // Defaults to true
+}
+
+// Flow is a stored menu bot definition
+type Flow struct {
+	ID         int             `json:"id"`
+	Name       string          `json:"name"`
+	Definition json.RawMessage `json:"definition"` // See internal/flow for the format
+	Position   int             `json:"position"`   // Flows are tried in ascending order for a trigger
+	Enabled    bool            `json:"enabled"`
+	DTStore    time.Time       `json:"dt_store"`
+	DTUpdate   *time.Time      `json:"dt_update,omitempty"`
+}
+
+// FlowRequest represents a request to create or update a flow
+type FlowRequest struct {
+	Name       string          `json:"name"`
+	Definition json.RawMessage `json:"definition"`
+	Position   int             `json:"position"`
+	Enabled    *bool           `json:"enabled,omitempty"` // Defaults to true
+}
+
+// Directions of a conversation item
+const (
+	DirectionOutbound = "outbound"
+	DirectionInbound  = "inbound"
+)
+
+// ConversationItem is an outbound message or an inbound message in a conversation
+type ConversationItem struct {
+	Direction string            `json:"direction"`
+	ID        int               `json:"id"`   // message.id or inbound_message.id, depending on direction
+	Type      string            `json:"type"` // Content type for outbound, event type for inbound
+	Message   string            `json:"message"`
+	Status    MessageStatus     `json:"status,omitempty"` // Outbound only
+	Payload   *MessagePayload   `json:"payload,omitempty"`
+	Reply     *InteractiveReply `json:"reply,omitempty"`
+	Timestamp time.Time         `json:"timestamp"` // Sent or queued time for outbound, received time for inbound
+}
+
+// ConversationResponse is one page of the timeline with a recipient, oldest first
+type ConversationResponse struct {
+	Recipient  string              `json:"recipient"`
+	Items      []*ConversationItem `json:"items"`
+	HasMore    bool                `json:"has_more"`              // Older items exist, fetch them with next_cursor
+	NextCursor string              `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// Suppression is a recipient the sender must not message
+type Suppression struct {
+	ID        int       `json:"id"`
+	Recipient string    `json:"recipient"`
+	Reason    string    `json:"reason,omitempty"`
+	Source    string    `json:"source"`
+	DTStore   time.Time `json:"dt_store"`
+}
+
+// SuppressionRequest represents a request to add or update a suppression
+type SuppressionRequest struct {
+	Recipient string `json:"recipient"`
+	Reason    string `json:"reason"`
+}
+
+// SuppressionImportResponse summarizes a CSV import into the suppression list
+type SuppressionImportResponse struct {
+	Imported int              `json:"imported"`
+	Existing int              `json:"existing"` // Already suppressed, left unchanged
+	Errors   []RecipientError `json:"errors,omitempty"`
+}
+
+// Consent is a recorded opt-in of a recipient to marketing messages
+type Consent struct {
+	ID        int        `json:"id"`
+	Recipient string     `json:"recipient"`
+	Source    string     `json:"source"`             // Where the opt-in was collected, e.g. "web-form"
+	Evidence  string     `json:"evidence,omitempty"` // Proof such as a form submission ID or the opt-in text
+	DTConsent time.Time  `json:"dt_consent"`
+	DTExpire  *time.Time `json:"dt_expire,omitempty"`
+	DTRevoke  *time.Time `json:"dt_revoke,omitempty"`
+	DTStore   time.Time  `json:"dt_store"`
+	Valid     bool       `json:"valid"`
+}
+
+// ConsentRequest represents a request to record a consent
+type ConsentRequest struct {
+	Recipient string     `json:"recipient"`
+	Source    string     `json:"source"`
+	Evidence  string     `json:"evidence"`
+	DTConsent *time.Time `json:"dt_consent,omitempty"` // Defaults to now
+	DTExpire  *time.Time `json:"dt_expire,omitempty"`
 }
 
 // LoginRequest represents a login request
 type LoginRequest struct {
 	Username string `json:"username"`
-	Key string `json:"key"`
+	Key      string `json:"key"`
 }
 
 // LoginResponse represents a login response
@@ -122,6 +640,118 @@ type LoginResponse struct {
 
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Details string `json:"details,omitempty"`
+	Error           string           `json:"error"`
+	Details         string           `json:"details,omitempty"`
+	RecipientErrors []RecipientError `json:"recipient_errors,omitempty"`
+}
+
+// Job is a background export or report
+type Job struct {
+	ID          int        `json:"id"`
+	Kind        string     `json:"kind"`
+	Status      JobStatus  `json:"status"`
+	Format      string     `json:"format"`
+	Rows        int        `json:"rows"` // Rows written so far
+	ResultSize  *int64     `json:"result_size,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"` // Set once the job is DONE
+	DTStore     time.Time  `json:"dt_store"`
+	DTStart     *time.Time `json:"dt_start,omitempty"`
+	DTFinish    *time.Time `json:"dt_finish,omitempty"`
+	DTExpire    *time.Time `json:"dt_expire,omitempty"` // The job and its file are removed after this
+}
+
+// JobRequest represents a request to start a background job
+type JobRequest struct {
+	Kind    string            `json:"kind"`
+	Format  string            `json:"format,omitempty"` // csv (default) or xlsx
+	Columns []string          `json:"columns,omitempty"`
+	TZ      string            `json:"tz,omitempty"`
+	Filters map[string]string `json:"filters,omitempty"` // Same as the query parameters of GET /api/ui/messages
+}
+
+// Contact is a stored recipient of a user with attributes used as template variables
+type Contact struct {
+	ID         int               `json:"id"`
+	Phone      string            `json:"phone"`
+	Name       string            `json:"name,omitempty"`
+	Attributes map[string]string `json:"attributes"`
+	GroupIDs   []int             `json:"group_ids"`
+	DTStore    time.Time         `json:"dt_store"`
+	DTUpdate   *time.Time        `json:"dt_update,omitempty"`
+}
+
+// ContactRequest represents a request to create or update a contact
+type ContactRequest struct {
+	Phone      string            `json:"phone"`
+	Name       string            `json:"name"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	GroupIDs   []int             `json:"group_ids,omitempty"` // Replaces the groups of the contact
+}
+
+// ContactImportResponse summarizes a CSV or XLSX import into the contacts
+type ContactImportResponse struct {
+	Created    int              `json:"created"`
+	Updated    int              `json:"updated"` // Existing contacts whose name and attributes were merged
+	ErrorCount int              `json:"error_count"`
+	ErrorRows  []RecipientError `json:"error_rows,omitempty"` // Index is the row number in the file
+}
+
+// ContactGroup is a named list of contacts that a broadcast can be sent to
+type ContactGroup struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	MemberCount int        `json:"member_count"`
+	DTStore     time.Time  `json:"dt_store"`
+	DTUpdate    *time.Time `json:"dt_update,omitempty"`
+}
+
+// ContactGroupRequest represents a request to create or update a contact group
+type ContactGroupRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ContactGroupMembersRequest adds contacts to a group, by id or by phone number
+type ContactGroupMembersRequest struct {
+	ContactIDs []int    `json:"contact_ids,omitempty"`
+	Phones     []string `json:"phones,omitempty"` // Must already be contacts
+}
+
+// ContactGroupMembersResponse summarizes a change to the members of a group
+type ContactGroupMembersResponse struct {
+	Added       int      `json:"added"`
+	Existing    int      `json:"existing"` // Already members, left unchanged
+	NotFound    []string `json:"not_found,omitempty"`
+	MemberCount int      `json:"member_count"`
+}
+
+// Segment is a saved filter over contact attributes that a broadcast can be sent to
+type Segment struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Expression  string     `json:"expression"` // See internal/segment for the syntax
+	DTStore     time.Time  `json:"dt_store"`
+	DTUpdate    *time.Time `json:"dt_update,omitempty"`
+}
+
+// SegmentRequest represents a request to create or update a segment
+type SegmentRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Expression  string `json:"expression"`
+}
+
+// SegmentCountRequest asks how many contacts an expression matches
+type SegmentCountRequest struct {
+	Expression string `json:"expression"`
+}
+
+// SegmentCountResponse is the audience size of a segment at this moment
+type SegmentCountResponse struct {
+	Matched  int `json:"matched"`
+	Total    int `json:"total"`    // All contacts of the user
+	Eligible int `json:"eligible"` // Matched contacts not on the suppression list
 }
@@ -0,0 +1,75 @@
+package msgtemplate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// placeholder matches {{name}} and {{ name }}
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// Variables returns the distinct variable names used in a template, in order of appearance
+func Variables(body string) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, match := range placeholder.FindAllStringSubmatch(body, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// Missing returns the variables used in a template that have no value in vars
+func Missing(body string, vars map[string]string) []string {
+	missing := make([]string, 0)
+	for _, name := range Variables(body) {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// Render replaces every placeholder with its value. It fails when a variable
+// has no value so a half-rendered message is never sent.
+func Render(body string, vars map[string]string) (string, error) {
+	if missing := Missing(body, vars); len(missing) > 0 {
+		return "", fmt.Errorf("missing variables: %s", strings.Join(missing, ", "))
+	}
+
+	return placeholder.ReplaceAllStringFunc(body, func(match string) string {
+		name := placeholder.FindStringSubmatch(match)[1]
+		return vars[name]
+	}), nil
+}
+
+// Merge returns the union of base and override, with override winning on conflicts
+func Merge(base, override map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(override))
+	for name, value := range base {
+		merged[name] = value
+	}
+	for name, value := range override {
+		merged[name] = value
+	}
+	return merged
+}
+
+// Undeclared returns the variables used in a template that are not in declared
+func Undeclared(body string, declared []string) []string {
+	known := make(map[string]bool, len(declared))
+	for _, name := range declared {
+		known[name] = true
+	}
+
+	undeclared := make([]string, 0)
+	for _, name := range Variables(body) {
+		if !known[name] {
+			undeclared = append(undeclared, name)
+		}
+	}
+	return undeclared
+}
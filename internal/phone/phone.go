@@ -0,0 +1,66 @@
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrEmpty         = errors.New("phone number is empty")
+	ErrInvalidChars  = errors.New("phone number contains invalid characters")
+	ErrInvalidLength = errors.New("phone number must have 8 to 15 digits")
+	ErrInvalidPrefix = errors.New("phone number has an invalid country code")
+)
+
+// E.164 limits on the number of digits including the country code
+const (
+	minDigits = 8
+	maxDigits = 15
+)
+
+// Normalize converts a phone number to E.164 digits without the leading plus.
+// Numbers written with a trunk prefix 0 are national numbers of defaultCountry,
+// so with defaultCountry "62" both "0812-3456-789" and "+62 812 3456 789"
+// become "628123456789". Spaces, dashes, dots and parentheses are ignored.
+func Normalize(raw, defaultCountry string) (string, error) {
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return "", ErrEmpty
+	}
+
+	international := strings.HasPrefix(value, "+")
+	if international {
+		value = value[1:]
+	}
+
+	var digits strings.Builder
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+			// Separator, skip
+		default:
+			return "", ErrInvalidChars
+		}
+	}
+
+	number := digits.String()
+	switch {
+	case international:
+		// Already carries its country code
+	case strings.HasPrefix(number, "00"):
+		number = number[2:] // International call prefix
+	case strings.HasPrefix(number, "0"):
+		number = defaultCountry + number[1:]
+	}
+
+	if strings.HasPrefix(number, "0") {
+		return "", ErrInvalidPrefix
+	}
+	if len(number) < minDigits || len(number) > maxDigits {
+		return "", ErrInvalidLength
+	}
+
+	return number, nil
+}
@@ -0,0 +1,132 @@
+// Package progress aggregates the messages of broadcasts into delivery progress
+package progress
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// Final reports whether a message keeps its status for good
+func Final(status models.MessageStatus) bool {
+	switch status {
+	case models.StatusSent, models.StatusFailed, models.StatusSuppressed:
+		return true
+	}
+	return false
+}
+
+// Fill computes the message aggregates of broadcasts of a sender. Each
+// progress comes with its BulkID, Status and DTComplete set.
+func Fill(db *sql.DB, sender string, progresses []*models.BroadcastProgress, now time.Time) error {
+	if len(progresses) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*models.BroadcastProgress, len(progresses))
+	args := make([]interface{}, 0, len(progresses)+1)
+	args = append(args, sender)
+	for _, p := range progresses {
+		p.StatusCounts = map[string]int{}
+		id := strconv.Itoa(p.BulkID)
+		byID[id] = p
+		args = append(args, id)
+	}
+
+	// message.type holds the bulk id as text
+	rows, err := db.Query(`
+		SELECT type, status, COUNT(*), COUNT(dt_send), MIN(dt_send), MAX(dt_send), MAX(dt_queue)
+		FROM message
+		WHERE sender = ? AND type IN (?`+strings.Repeat(", ?", len(progresses)-1)+`)
+		GROUP BY type, status
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("error querying broadcast messages: %w", err)
+	}
+	defer rows.Close()
+
+	sent := make(map[*models.BroadcastProgress]int, len(progresses))
+	pendingUntil := make(map[*models.BroadcastProgress]time.Time, len(progresses))
+	for rows.Next() {
+		var bulkID string
+		var status models.MessageStatus
+		var count, sendCount int
+		var firstSend, lastSend sql.NullTime
+		var lastQueue time.Time
+		if err := rows.Scan(&bulkID, &status, &count, &sendCount, &firstSend, &lastSend, &lastQueue); err != nil {
+			return fmt.Errorf("error scanning broadcast message counts: %w", err)
+		}
+
+		p := byID[bulkID]
+		if p == nil {
+			continue
+		}
+
+		p.StatusCounts[string(status)] += count
+		p.Total += count
+		if Final(status) {
+			p.Finished += count
+		} else if lastQueue.After(pendingUntil[p]) {
+			pendingUntil[p] = lastQueue
+		}
+
+		sent[p] += sendCount
+		if firstSend.Valid && (p.FirstSend == nil || firstSend.Time.Before(*p.FirstSend)) {
+			t := firstSend.Time
+			p.FirstSend = &t
+		}
+		if lastSend.Valid && (p.LastSend == nil || lastSend.Time.After(*p.LastSend)) {
+			t := lastSend.Time
+			p.LastSend = &t
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating broadcast message counts: %w", err)
+	}
+
+	for _, p := range progresses {
+		summarize(p, sent[p], pendingUntil[p], now)
+	}
+	return nil
+}
+
+// summarize derives the percentage, rate and estimate from the counts.
+// sent is the number of messages with a send time and pendingUntil the
+// latest queue time of the messages not finished yet.
+func summarize(p *models.BroadcastProgress, sent int, pendingUntil, now time.Time) {
+	expanded := p.Status == models.BulkStatusDone || p.Status == models.BulkStatusCompleted
+	switch {
+	case p.Total > 0:
+		p.PercentComplete = math.Round(float64(p.Finished)*1000/float64(p.Total)) / 10
+	case expanded:
+		p.PercentComplete = 100 // Every recipient was left out
+	}
+
+	var rate float64
+	if sent > 1 && p.LastSend.After(*p.FirstSend) {
+		rate = float64(sent-1) / p.LastSend.Sub(*p.FirstSend).Minutes()
+		p.SendRate = math.Round(rate*100) / 100
+	}
+
+	remaining := p.Total - p.Finished
+	if p.Status != models.BulkStatusDone || remaining == 0 {
+		return
+	}
+
+	// At the current rate, but no sooner than pacing lets the last message go
+	var eta time.Time
+	if rate > 0 {
+		eta = now.Add(time.Duration(float64(remaining) / rate * float64(time.Minute)))
+	}
+	if pendingUntil.After(eta) {
+		eta = pendingUntil
+	}
+	if eta.After(now) {
+		p.EstimatedCompletion = &eta
+	}
+}
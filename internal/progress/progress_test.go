@@ -0,0 +1,123 @@
+package progress
+
+import (
+	"testing"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+func TestSummarize(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration) *time.Time {
+		v := now.Add(offset)
+		return &v
+	}
+
+	tests := []struct {
+		name         string
+		progress     models.BroadcastProgress
+		sent         int
+		pendingUntil time.Time
+		wantPercent  float64
+		wantRate     float64
+		wantETA      *time.Time
+	}{
+		{
+			name:     "zero total while expanding",
+			progress: models.BroadcastProgress{Status: models.BulkStatusExpanding},
+		},
+		{
+			name:        "zero total once expanded",
+			progress:    models.BroadcastProgress{Status: models.BulkStatusDone},
+			wantPercent: 100,
+		},
+		{
+			name: "single send",
+			progress: models.BroadcastProgress{
+				Status: models.BulkStatusDone, Total: 3, Finished: 1,
+				FirstSend: at(-time.Minute), LastSend: at(-time.Minute),
+			},
+			sent:         1,
+			pendingUntil: now.Add(2 * time.Minute),
+			wantPercent:  33.3,
+			wantETA:      at(2 * time.Minute),
+		},
+		{
+			name: "single send, rest overdue",
+			progress: models.BroadcastProgress{
+				Status: models.BulkStatusDone, Total: 2, Finished: 1,
+				FirstSend: at(-time.Minute), LastSend: at(-time.Minute),
+			},
+			sent:         1,
+			pendingUntil: now.Add(-30 * time.Second),
+			wantPercent:  50,
+		},
+		{
+			name: "estimate from the send rate",
+			progress: models.BroadcastProgress{
+				Status: models.BulkStatusDone, Total: 15, Finished: 10,
+				FirstSend: at(-9 * time.Minute), LastSend: at(0),
+			},
+			sent:         10,
+			pendingUntil: now.Add(time.Minute),
+			wantPercent:  66.7,
+			wantRate:     1,
+			wantETA:      at(5 * time.Minute),
+		},
+		{
+			name: "estimate no sooner than pacing",
+			progress: models.BroadcastProgress{
+				Status: models.BulkStatusDone, Total: 15, Finished: 10,
+				FirstSend: at(-9 * time.Minute), LastSend: at(0),
+			},
+			sent:         10,
+			pendingUntil: now.Add(time.Hour),
+			wantPercent:  66.7,
+			wantRate:     1,
+			wantETA:      at(time.Hour),
+		},
+		{
+			name: "all finished",
+			progress: models.BroadcastProgress{
+				Status: models.BulkStatusDone, Total: 4, Finished: 4,
+				FirstSend: at(-2 * time.Minute), LastSend: at(-time.Minute),
+			},
+			sent:        4,
+			wantPercent: 100,
+			wantRate:    3,
+		},
+		{
+			name: "completed",
+			progress: models.BroadcastProgress{
+				Status: models.BulkStatusCompleted, Total: 4, Finished: 4,
+				FirstSend: at(-2 * time.Minute), LastSend: at(-time.Minute),
+			},
+			sent:        4,
+			wantPercent: 100,
+			wantRate:    3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.progress
+			summarize(&p, tt.sent, tt.pendingUntil, now)
+
+			if p.PercentComplete != tt.wantPercent {
+				t.Errorf("percent = %v, want %v", p.PercentComplete, tt.wantPercent)
+			}
+			if p.SendRate != tt.wantRate {
+				t.Errorf("rate = %v, want %v", p.SendRate, tt.wantRate)
+			}
+			switch {
+			case tt.wantETA == nil && p.EstimatedCompletion != nil:
+				t.Errorf("estimate = %v, want none", *p.EstimatedCompletion)
+			case tt.wantETA != nil && p.EstimatedCompletion == nil:
+				t.Errorf("no estimate, want %v", *tt.wantETA)
+			case tt.wantETA != nil && !p.EstimatedCompletion.Equal(*tt.wantETA):
+				t.Errorf("estimate = %v, want %v", *p.EstimatedCompletion, *tt.wantETA)
+			}
+		})
+	}
+}
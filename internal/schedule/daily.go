@@ -0,0 +1,148 @@
+package schedule
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/config"
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// DailyLimit restricts how many messages a sender may have queued per day
+// and the hours of the day in which a rolled-over broadcast resumes
+type DailyLimit struct {
+	Cap         int            // Maximum messages per day, 0 means unlimited
+	WindowStart int            // Hour of day at which sending may start
+	WindowEnd   int            // Hour of day at which sending stops, 0 means midnight
+	Used        map[string]int // Messages already queued per day, keyed by DayKey
+}
+
+// LimitFromConfig returns the configured default daily limit
+func LimitFromConfig(cfg config.ScheduleConfig) DailyLimit {
+	return DailyLimit{
+		Cap:         cfg.DailyCap,
+		WindowStart: cfg.WindowStart,
+		WindowEnd:   cfg.WindowEnd,
+	}
+}
+
+// DayKey returns the key used for a day in DailyLimit.Used. Days are taken
+// in the local time zone, which is also how the database connection stores
+// dt_queue (loc=Local) and so how DATE_FORMAT groups the usage.
+func DayKey(t time.Time) string {
+	return t.In(time.Local).Format("2006-01-02")
+}
+
+// bounded reports whether the limit restricts anything: a daily cap or a
+// sending window shorter than the whole day
+func (l DailyLimit) bounded() bool {
+	return l.Cap > 0 || l.WindowStart > 0 || l.windowEnd() < 24
+}
+
+// firstSlot returns the first moment at or after t inside the sending window,
+// in the local time zone
+func (l DailyLimit) firstSlot(t time.Time) time.Time {
+	t = t.In(time.Local)
+	windowStart := time.Date(t.Year(), t.Month(), t.Day(), l.WindowStart, 0, 0, 0, t.Location())
+	windowEnd := l.closing(t)
+
+	switch {
+	case t.Before(windowStart):
+		return windowStart
+	case !t.Before(windowEnd):
+		return l.nextDay(t)
+	}
+	return t
+}
+
+// nextDay returns the start of the sending window on the day after t
+func (l DailyLimit) nextDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+1, l.WindowStart, 0, 0, 0, t.Location())
+}
+
+// closing returns the end of the sending window on the day of t
+func (l DailyLimit) closing(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), l.windowEnd(), 0, 0, 0, t.Location())
+}
+
+// windowEnd returns the closing hour of the sending window
+func (l DailyLimit) windowEnd() int {
+	if l.WindowEnd <= 0 || l.WindowEnd > 24 {
+		return 24
+	}
+	return l.WindowEnd
+}
+
+// Distribution groups queue times per day for previews
+func Distribution(times []time.Time) []models.DayAllocation {
+	days := make([]models.DayAllocation, 0)
+	for _, t := range times {
+		key := DayKey(t)
+		last := len(days) - 1
+		if last < 0 || days[last].Date != key {
+			days = append(days, models.DayAllocation{Date: key, First: t, Last: t})
+			last++
+		}
+		days[last].Count++
+		if t.Before(days[last].First) {
+			days[last].First = t
+		}
+		if t.After(days[last].Last) {
+			days[last].Last = t
+		}
+	}
+	return days
+}
+
+// Querier runs queries on a *sql.DB or inside a *sql.Tx
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// LoadDailyLimit builds the daily limit of a sender. The cap comes from the
+// user row when set, otherwise from the configured default, and the usage
+// counts every message already queued for the sender from the given day on.
+// Callers that queue messages against the limit run it in the transaction
+// that inserts them, after locking the sender's user row.
+func LoadDailyLimit(db Querier, sender string, from time.Time, defaults DailyLimit) (DailyLimit, error) {
+	limit := defaults
+	limit.Used = make(map[string]int)
+
+	var userCap sql.NullInt64
+	err := db.QueryRow("SELECT daily_cap FROM user WHERE username = ?", sender).Scan(&userCap)
+	if err != nil && err != sql.ErrNoRows {
+		return limit, fmt.Errorf("error loading daily cap: %w", err)
+	}
+	if userCap.Valid {
+		limit.Cap = int(userCap.Int64)
+	}
+
+	if limit.Cap <= 0 {
+		return limit, nil
+	}
+
+	dayStart := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	rows, err := db.Query(`
+		SELECT DATE_FORMAT(dt_queue, '%Y-%m-%d') AS day, COUNT(*) 
+		FROM message 
+		WHERE sender = ? AND dt_queue >= ? 
+		GROUP BY day
+	`, sender, dayStart)
+	if err != nil {
+		return limit, fmt.Errorf("error loading daily usage: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day string
+		var count int
+		if err := rows.Scan(&day, &count); err != nil {
+			return limit, fmt.Errorf("error scanning daily usage: %w", err)
+		}
+		limit.Used[day] = count
+	}
+
+	return limit, rows.Err()
+}
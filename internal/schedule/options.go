@@ -0,0 +1,105 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// Names of the built-in pacing strategies
+const (
+	StrategyNatural          = "natural"
+	StrategyLinear           = "linear"
+	StrategyJittered         = "jittered"
+	StrategyBurstThenTrickle = "burst"
+	StrategySpread           = "spread"
+)
+
+// Upper bounds of the pacing options, well below where a plan's durations overflow
+const (
+	maxIntervalSeconds = 3600    // One hour between messages
+	maxSpreadHours     = 30 * 24 // One month
+)
+
+// FromOptions builds a pacing strategy from the options of a broadcast request.
+// A nil options value selects the default strategy.
+func FromOptions(opts *models.PacingOptions) (PacingStrategy, error) {
+	if opts == nil || opts.Strategy == "" || opts.Strategy == StrategyNatural {
+		strategy := DefaultStrategy().(Natural)
+		if opts != nil && opts.RatePerMinute != 0 {
+			if opts.RatePerMinute < 0 {
+				return nil, fmt.Errorf("rate_per_minute must be positive")
+			}
+			strategy.RatePerMinute = opts.RatePerMinute
+		}
+		return strategy, nil
+	}
+
+	if opts.Jitter < 0 || opts.Jitter > 1 {
+		return nil, fmt.Errorf("jitter must be between 0 and 1")
+	}
+
+	interval, err := seconds(opts.IntervalSeconds, 1, "interval_seconds")
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.Strategy {
+	case StrategyLinear:
+		return Linear{Interval: interval}, nil
+
+	case StrategyJittered:
+		jitter := opts.Jitter
+		if jitter == 0 {
+			jitter = 0.5
+		}
+		return Jittered{Interval: interval, Jitter: jitter}, nil
+
+	case StrategyBurstThenTrickle:
+		if opts.BurstSize < 0 {
+			return nil, fmt.Errorf("burst_size must be positive")
+		}
+		burstSize := opts.BurstSize
+		if burstSize == 0 {
+			burstSize = 10
+		}
+		burstInterval, err := seconds(opts.BurstIntervalSeconds, 1, "burst_interval_seconds")
+		if err != nil {
+			return nil, err
+		}
+		return BurstThenTrickle{
+			BurstSize:       burstSize,
+			BurstInterval:   burstInterval,
+			TrickleInterval: interval,
+		}, nil
+
+	case StrategySpread:
+		if opts.Hours <= 0 {
+			return nil, fmt.Errorf("hours is required for the spread strategy")
+		}
+		if opts.Hours > maxSpreadHours {
+			return nil, fmt.Errorf("hours must be at most %d", maxSpreadHours)
+		}
+		return Spread{
+			Duration: time.Duration(opts.Hours * float64(time.Hour)),
+			Jitter:   opts.Jitter,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown pacing strategy %q", opts.Strategy)
+}
+
+// seconds converts a seconds option to a duration, applying a default when unset
+func seconds(value float64, defaultValue float64, name string) (time.Duration, error) {
+	if value < 0 {
+		return 0, fmt.Errorf("%s must be positive", name)
+	}
+	if value > maxIntervalSeconds {
+		return 0, fmt.Errorf("%s must be at most %d", name, maxIntervalSeconds)
+	}
+	if value == 0 {
+		value = defaultValue
+	}
+	return time.Duration(value * float64(time.Second)), nil
+}
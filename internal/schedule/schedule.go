@@ -0,0 +1,111 @@
+package schedule
+
+import (
+	"math/rand"
+	"time"
+)
+
+// PacingStrategy decides when each message of a broadcast is queued
+type PacingStrategy interface {
+	// Schedule returns n queue times for a broadcast starting at start.
+	// All randomness must come from rng so schedules are reproducible.
+	Schedule(start time.Time, n int, rng *rand.Rand) []time.Time
+}
+
+// Clock provides the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock backed by time.Now
+type SystemClock struct{}
+
+// Now returns the current local time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock is a Clock that always returns the same instant
+type FixedClock time.Time
+
+// Now returns the fixed instant
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// Planner turns a pacing strategy into concrete queue times
+type Planner struct {
+	Strategy PacingStrategy
+	Clock    Clock
+	Seed     *int64 // Random seed; derived from the clock when nil
+}
+
+// Plan computes queue times for n messages starting at start.
+// A zero start means "now" according to the planner's clock.
+func (p Planner) Plan(start time.Time, n int) []time.Time {
+	return p.PlanDaily(start, n, DailyLimit{})
+}
+
+// PlanDaily computes queue times for n messages like Plan, but never queues
+// more than the limit allows on a single day, nor outside the sending window.
+// Messages that do not fit roll over to the start of the sending window on the
+// next day with capacity left.
+func (p Planner) PlanDaily(start time.Time, n int, limit DailyLimit) []time.Time {
+	if n <= 0 {
+		return nil
+	}
+
+	clock := p.Clock
+	if clock == nil {
+		clock = SystemClock{}
+	}
+
+	if start.IsZero() {
+		start = clock.Now()
+	}
+
+	seed := clock.Now().UnixNano()
+	if p.Seed != nil {
+		seed = *p.Seed
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	strategy := p.Strategy
+	if strategy == nil {
+		strategy = DefaultStrategy()
+	}
+
+	// Without a cap or a window the strategy is free to run past midnight
+	if !limit.bounded() {
+		return strategy.Schedule(start, n, rng)
+	}
+
+	times := make([]time.Time, 0, n)
+	for dayStart := limit.firstSlot(start); len(times) < n; dayStart = limit.nextDay(dayStart) {
+		count := n - len(times)
+		if limit.Cap > 0 {
+			available := limit.Cap - limit.Used[DayKey(dayStart)]
+			if available <= 0 {
+				continue
+			}
+			if count > available {
+				count = available
+			}
+		}
+
+		// Keep what the strategy places before the window closes; the rest rolls over
+		chunk := strategy.Schedule(dayStart, count, rng)
+		closing := limit.closing(dayStart)
+		fit := 0
+		for fit < len(chunk) && chunk[fit].Before(closing) {
+			fit++
+		}
+		if fit == 0 {
+			// Pacing slower than the window is long: send one message per day
+			chunk[0], fit = dayStart, 1
+		}
+		times = append(times, chunk[:fit]...)
+	}
+
+	return times
+}
@@ -0,0 +1,227 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// offsets parses the expected queue times, relative to the start
+func offsets(t *testing.T, values ...string) []time.Duration {
+	t.Helper()
+	durations := make([]time.Duration, len(values))
+	for i, value := range values {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			t.Fatalf("bad offset %q: %v", value, err)
+		}
+		durations[i] = d
+	}
+	return durations
+}
+
+func TestPlannerStrategies(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	seed := int64(42)
+
+	tests := []struct {
+		name     string
+		strategy PacingStrategy
+		ordered  bool // Natural sends its first messages within a few seconds, in any order
+		want     []string
+	}{
+		{
+			name:     "natural",
+			strategy: Natural{RatePerMinute: 100, MinDuration: 30 * time.Second},
+			want:     []string{"3s", "2s", "4s", "25.877262815s", "29.306675099s"},
+		},
+		{
+			name:     "linear",
+			strategy: Linear{Interval: 10 * time.Second},
+			ordered:  true,
+			want:     []string{"0s", "10s", "20s", "30s", "40s"},
+		},
+		{
+			name:     "jittered",
+			strategy: Jittered{Interval: 10 * time.Second, Jitter: 0.5},
+			ordered:  true,
+			want:     []string{"0s", "5.660004968s", "21.040938515s", "27.088187031s", "35.438184586s"},
+		},
+		{
+			name:     "burst",
+			strategy: BurstThenTrickle{BurstSize: 2, BurstInterval: time.Second, TrickleInterval: 10 * time.Second},
+			ordered:  true,
+			want:     []string{"0s", "1s", "12s", "22s", "32s"},
+		},
+		{
+			name:     "spread",
+			strategy: Spread{Duration: time.Hour, Jitter: 0.5},
+			ordered:  true,
+			want:     []string{"2m14.290209976s", "12m23.760178845s", "27m37.473786561s", "37m15.174733099s", "48m15.774645095s"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			planner := Planner{Strategy: tt.strategy, Clock: FixedClock(start), Seed: &seed}
+			got := planner.Plan(time.Time{}, len(tt.want))
+
+			want := offsets(t, tt.want...)
+			if len(got) != len(want) {
+				t.Fatalf("got %d queue times, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if !got[i].Equal(start.Add(want[i])) {
+					t.Errorf("message %d queued at +%s, want +%s", i, got[i].Sub(start), want[i])
+				}
+				if tt.ordered && i > 0 && got[i].Before(got[i-1]) {
+					t.Errorf("message %d queued before message %d", i, i-1)
+				}
+			}
+
+			// The same seed gives the same schedule
+			again := planner.Plan(time.Time{}, len(tt.want))
+			for i := range got {
+				if !again[i].Equal(got[i]) {
+					t.Fatalf("schedule not reproducible at message %d: %s then %s", i, got[i], again[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFromOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *models.PacingOptions
+		want PacingStrategy
+	}{
+		{"default", nil, DefaultStrategy()},
+		{"natural rate", &models.PacingOptions{RatePerMinute: 20}, Natural{RatePerMinute: 20, MinDuration: 30 * time.Second}},
+		{"linear", &models.PacingOptions{Strategy: StrategyLinear, IntervalSeconds: 2}, Linear{Interval: 2 * time.Second}},
+		{"linear default interval", &models.PacingOptions{Strategy: StrategyLinear}, Linear{Interval: time.Second}},
+		{"jittered default jitter", &models.PacingOptions{Strategy: StrategyJittered, IntervalSeconds: 4},
+			Jittered{Interval: 4 * time.Second, Jitter: 0.5}},
+		{"burst defaults", &models.PacingOptions{Strategy: StrategyBurstThenTrickle, IntervalSeconds: 30},
+			BurstThenTrickle{BurstSize: 10, BurstInterval: time.Second, TrickleInterval: 30 * time.Second}},
+		{"spread", &models.PacingOptions{Strategy: StrategySpread, Hours: 2, Jitter: 0.25},
+			Spread{Duration: 2 * time.Hour, Jitter: 0.25}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromOptions(tt.opts)
+			if err != nil {
+				t.Fatalf("FromOptions: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromOptionsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts models.PacingOptions
+	}{
+		{"negative rate", models.PacingOptions{RatePerMinute: -1}},
+		{"unknown strategy", models.PacingOptions{Strategy: "random"}},
+		{"negative jitter", models.PacingOptions{Strategy: StrategyJittered, Jitter: -0.1}},
+		{"jitter above one", models.PacingOptions{Strategy: StrategyJittered, Jitter: 1.5}},
+		{"negative interval", models.PacingOptions{Strategy: StrategyLinear, IntervalSeconds: -5}},
+		{"negative burst size", models.PacingOptions{Strategy: StrategyBurstThenTrickle, BurstSize: -1}},
+		{"negative burst interval", models.PacingOptions{Strategy: StrategyBurstThenTrickle, BurstIntervalSeconds: -1}},
+		{"spread without hours", models.PacingOptions{Strategy: StrategySpread}},
+		{"interval too long", models.PacingOptions{Strategy: StrategyLinear, IntervalSeconds: maxIntervalSeconds + 1}},
+		{"burst interval too long", models.PacingOptions{Strategy: StrategyBurstThenTrickle, BurstIntervalSeconds: 1e12}},
+		{"spread too long", models.PacingOptions{Strategy: StrategySpread, Hours: 1e9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if strategy, err := FromOptions(&tt.opts); err == nil {
+				t.Errorf("accepted %+v as %#v", tt.opts, strategy)
+			}
+		})
+	}
+}
+
+func TestPlanDailyKeepsWindowAndCap(t *testing.T) {
+	start := time.Date(2024, 3, 1, 16, 0, 0, 0, time.Local)
+	seed := int64(7)
+
+	tests := []struct {
+		name     string
+		strategy PacingStrategy
+		n        int
+		limit    DailyLimit
+		perDay   map[string]int
+	}{
+		{
+			// 10 minutes apart, 6 fit between 16:00 and 17:00
+			name:     "linear overflow rolls over",
+			strategy: Linear{Interval: 10 * time.Minute},
+			n:        10,
+			limit:    DailyLimit{WindowStart: 8, WindowEnd: 17},
+			perDay:   map[string]int{"2024-03-01": 6, "2024-03-02": 4},
+		},
+		{
+			name:     "cap and used",
+			strategy: Linear{Interval: time.Minute},
+			n:        10,
+			limit:    DailyLimit{Cap: 5, WindowStart: 8, WindowEnd: 20, Used: map[string]int{"2024-03-01": 2}},
+			perDay:   map[string]int{"2024-03-01": 3, "2024-03-02": 5, "2024-03-03": 2},
+		},
+		{
+			// 6 hour slots: 22:00 is past the window, then 8 hour slots from 08:00
+			name:     "spread longer than the window",
+			strategy: Spread{Duration: 24 * time.Hour},
+			n:        4,
+			limit:    DailyLimit{WindowStart: 8, WindowEnd: 20},
+			perDay:   map[string]int{"2024-03-01": 1, "2024-03-02": 2, "2024-03-03": 1},
+		},
+		{
+			// The fourth message would be queued at 00:00:02
+			name:     "burst capped at midnight",
+			strategy: BurstThenTrickle{BurstSize: 2, BurstInterval: time.Second, TrickleInterval: 4 * time.Hour},
+			n:        5,
+			limit:    DailyLimit{Cap: 100},
+			perDay:   map[string]int{"2024-03-01": 3, "2024-03-02": 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			planner := Planner{Strategy: tt.strategy, Clock: FixedClock(start), Seed: &seed}
+			times := planner.PlanDaily(start, tt.n, tt.limit)
+			if len(times) != tt.n {
+				t.Fatalf("got %d queue times, want %d", len(times), tt.n)
+			}
+
+			perDay := map[string]int{}
+			for i, queued := range times {
+				closing := tt.limit.closing(queued)
+				opening := time.Date(queued.Year(), queued.Month(), queued.Day(), tt.limit.WindowStart, 0, 0, 0, queued.Location())
+				if queued.Before(opening) || !queued.Before(closing) {
+					t.Errorf("message %d queued at %s, outside the window", i, queued)
+				}
+				if i > 0 && queued.Before(times[i-1]) {
+					t.Errorf("message %d queued before message %d", i, i-1)
+				}
+				perDay[DayKey(queued)]++
+			}
+
+			for day, want := range tt.perDay {
+				if perDay[day] != want {
+					t.Errorf("%s: %d messages, want %d", day, perDay[day], want)
+				}
+			}
+			if len(perDay) != len(tt.perDay) {
+				t.Errorf("messages on %v, want %v", perDay, tt.perDay)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package schedule
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Natural is the original "look natural" algorithm: messages are spread over
+// at least MinDuration at no more than RatePerMinute, each delayed by ±50% of
+// the base delay, and the first three go out within a few seconds.
+type Natural struct {
+	RatePerMinute int
+	MinDuration   time.Duration
+}
+
+// DefaultStrategy returns the strategy used when a broadcast does not choose one
+func DefaultStrategy() PacingStrategy {
+	return Natural{RatePerMinute: 100, MinDuration: 30 * time.Second}
+}
+
+// Schedule implements PacingStrategy
+func (s Natural) Schedule(start time.Time, n int, rng *rand.Rand) []time.Time {
+	// Determine minimum total time needed for all messages
+	// If recipients count is under max rate, we'll still spread over at least MinDuration
+	total := s.MinDuration
+	if n > s.RatePerMinute {
+		total = time.Duration(n) * time.Minute / time.Duration(s.RatePerMinute)
+	}
+
+	// Base delay for each message
+	baseDelay := total / time.Duration(n)
+
+	times := make([]time.Time, n)
+	for i := range times {
+		// Add random variance to queue time (±50% of base delay)
+		randomFactor := 0.5 + rng.Float64()
+		messageDelay := time.Duration(float64(baseDelay) * randomFactor)
+
+		// Calculate queue time by adding progressive delay to base time
+		times[i] = start.Add(time.Duration(i)*baseDelay + messageDelay)
+
+		// For first few messages, apply smaller delays to appear natural
+		if i < 3 {
+			// First message: 1-3 seconds delay
+			// Second message: 2-5 seconds delay
+			// Third message: 3-8 seconds delay
+			randomSeconds := rng.Intn(3) + i + 1
+			times[i] = start.Add(time.Duration(randomSeconds) * time.Second)
+		}
+	}
+
+	return times
+}
+
+// Linear queues messages at a fixed interval
+type Linear struct {
+	Interval time.Duration
+}
+
+// Schedule implements PacingStrategy
+func (s Linear) Schedule(start time.Time, n int, rng *rand.Rand) []time.Time {
+	times := make([]time.Time, n)
+	for i := range times {
+		times[i] = start.Add(time.Duration(i) * s.Interval)
+	}
+	return times
+}
+
+// Jittered queues messages at a fixed interval, each shifted by up to
+// ±Jitter (a fraction of the interval) while keeping the original order
+type Jittered struct {
+	Interval time.Duration
+	Jitter   float64
+}
+
+// Schedule implements PacingStrategy
+func (s Jittered) Schedule(start time.Time, n int, rng *rand.Rand) []time.Time {
+	times := make([]time.Time, n)
+	for i := range times {
+		offset := (rng.Float64()*2 - 1) * s.Jitter * float64(s.Interval)
+		times[i] = start.Add(time.Duration(i)*s.Interval + time.Duration(offset))
+		if times[i].Before(start) {
+			times[i] = start
+		}
+		if i > 0 && times[i].Before(times[i-1]) {
+			times[i] = times[i-1]
+		}
+	}
+	return times
+}
+
+// BurstThenTrickle sends the first BurstSize messages quickly and the rest slowly
+type BurstThenTrickle struct {
+	BurstSize       int
+	BurstInterval   time.Duration
+	TrickleInterval time.Duration
+}
+
+// Schedule implements PacingStrategy
+func (s BurstThenTrickle) Schedule(start time.Time, n int, rng *rand.Rand) []time.Time {
+	times := make([]time.Time, n)
+	for i := range times {
+		if i < s.BurstSize {
+			times[i] = start.Add(time.Duration(i) * s.BurstInterval)
+			continue
+		}
+		burstEnd := time.Duration(s.BurstSize) * s.BurstInterval
+		times[i] = start.Add(burstEnd + time.Duration(i-s.BurstSize+1)*s.TrickleInterval)
+	}
+	return times
+}
+
+// Spread distributes messages evenly over Duration, each placed at a random
+// point inside its own slot when Jitter is set
+type Spread struct {
+	Duration time.Duration
+	Jitter   float64
+}
+
+// Schedule implements PacingStrategy
+func (s Spread) Schedule(start time.Time, n int, rng *rand.Rand) []time.Time {
+	slot := s.Duration / time.Duration(n)
+	times := make([]time.Time, n)
+	for i := range times {
+		offset := time.Duration(rng.Float64() * s.Jitter * float64(slot))
+		times[i] = start.Add(time.Duration(i)*slot + offset)
+	}
+	return times
+}
@@ -0,0 +1,514 @@
+// Package segment parses and evaluates the filter expressions that pick a
+// broadcast audience from contact attributes.
+//
+// An expression compares fields with values and combines the comparisons
+// with AND, OR, NOT and parentheses:
+//
+//	tier = "gold" AND city IN ("Bandung", "Cimahi")
+//	last_purchase >= days_ago(30) OR NOT EXISTS last_purchase
+//
+// Fields are the contact attributes, name and phone. Values are quoted
+// strings, numbers, today() and days_ago(n), the last two being dates written
+// as YYYY-MM-DD. Comparisons ignore case; two finite numbers compare as
+// numbers and anything else as text, which orders YYYY-MM-DD dates correctly.
+// A comparison on a field the contact does not have is false. Expressions are
+// evaluated in Go, never turned into SQL.
+package segment
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Limits of an expression
+const (
+	MaxLength   = 2000
+	maxDepth    = 32
+	maxInValues = 100
+)
+
+// DateFormat is how today() and days_ago(n) are written, and how date
+// attributes should be stored to compare correctly
+const DateFormat = "2006-01-02"
+
+// Expr is a parsed filter expression
+type Expr struct {
+	root node
+}
+
+// Parse parses a filter expression
+func Parse(source string) (*Expr, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, fmt.Errorf("expression is empty")
+	}
+	if len(source) > MaxLength {
+		return nil, fmt.Errorf("expression must be at most %d characters", MaxLength)
+	}
+
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("position %d: unexpected %s", tok.pos, tok)
+	}
+
+	return &Expr{root: root}, nil
+}
+
+// Match reports whether a contact with the given fields belongs to the
+// segment. now fixes what today() and days_ago(n) mean.
+func (e *Expr) Match(fields map[string]string, now time.Time) bool {
+	return e.root.match(fields, now)
+}
+
+// node is a part of a parsed expression
+type node interface {
+	match(fields map[string]string, now time.Time) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) match(fields map[string]string, now time.Time) bool {
+	return n.left.match(fields, now) && n.right.match(fields, now)
+}
+
+type orNode struct{ left, right node }
+
+func (n orNode) match(fields map[string]string, now time.Time) bool {
+	return n.left.match(fields, now) || n.right.match(fields, now)
+}
+
+type notNode struct{ inner node }
+
+func (n notNode) match(fields map[string]string, now time.Time) bool {
+	return !n.inner.match(fields, now)
+}
+
+type existsNode struct{ field string }
+
+func (n existsNode) match(fields map[string]string, now time.Time) bool {
+	return fieldValue(fields, n.field) != ""
+}
+
+// compareNode compares a field with one value, or with a list for IN
+type compareNode struct {
+	field  string
+	op     string
+	values []value
+}
+
+func (n compareNode) match(fields map[string]string, now time.Time) bool {
+	actual := fieldValue(fields, n.field)
+	if actual == "" {
+		return false
+	}
+
+	switch n.op {
+	case "IN":
+		for _, v := range n.values {
+			if compare(actual, v.resolve(now)) == 0 {
+				return true
+			}
+		}
+		return false
+	case "CONTAINS":
+		return strings.Contains(strings.ToLower(actual), strings.ToLower(n.values[0].resolve(now)))
+	}
+
+	c := compare(actual, n.values[0].resolve(now))
+	switch n.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// fieldValue looks a field up; field names ignore case
+func fieldValue(fields map[string]string, field string) string {
+	if v, ok := fields[field]; ok {
+		return strings.TrimSpace(v)
+	}
+	for name, v := range fields {
+		if strings.EqualFold(name, field) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// compare orders two values: numerically when both are numbers, otherwise as
+// case-insensitive text
+func compare(a, b string) int {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// number parses a finite number. ParseFloat also reads "nan" and "inf",
+// which are text in an attribute and would not order against numbers.
+func number(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// value is a literal, or a date relative to the time of evaluation
+type value struct {
+	text     string
+	relative bool
+	daysAgo  int
+}
+
+func (v value) resolve(now time.Time) string {
+	if v.relative {
+		return now.AddDate(0, 0, -v.daysAgo).Format(DateFormat)
+	}
+	return v.text
+}
+
+// Token kinds
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int // 1-based offset in the source, for error messages
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// isKeyword reports whether an identifier token is the given keyword
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+// isIdentStart and isIdentPart match the names of template variables
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r) || r == '.' || r == '-'
+}
+
+// lex splits an expression into tokens
+func lex(source string) ([]token, error) {
+	runes := []rune(source)
+	tokens := make([]token, 0)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: pos})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: pos})
+			i++
+
+		case r == '=':
+			tokens = append(tokens, token{kind: tokenOp, text: "=", pos: pos})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			var next rune
+			if i+1 < len(runes) {
+				next = runes[i+1]
+			}
+			op, width := string(r), 1
+			switch {
+			case next == '=':
+				op, width = op+"=", 2
+			case r == '<' && next == '>':
+				op, width = "!=", 2
+			case r == '!':
+				return nil, fmt.Errorf("position %d: expected != but found !", pos)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: pos})
+			i += width
+
+		case r == '"' || r == '\'':
+			var text strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				text.WriteRune(runes[j])
+			}
+			if j == len(runes) {
+				return nil, fmt.Errorf("position %d: unterminated string", pos)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text.String(), pos: pos})
+			i = j + 1
+
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			text := string(runes[i:j])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, fmt.Errorf("position %d: invalid number %q", pos, text)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, pos: pos})
+			i = j
+
+		case isIdentStart(r):
+			j := i + 1
+			for j < len(runes) && isIdentPart(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[i:j]), pos: pos})
+			i = j
+
+		default:
+			return nil, fmt.Errorf("position %d: unexpected character %q", pos, r)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(runes) + 1}), nil
+}
+
+// parser is a recursive descent parser over the tokens of an expression
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.next]
+	if tok.kind != tokenEOF {
+		p.next++
+	}
+	return tok
+}
+
+// parseOr parses: and ("OR" and)*
+func (p *parser) parseOr(depth int) (node, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("OR") {
+		p.advance()
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: not ("AND" not)*
+func (p *parser) parseAnd(depth int) (node, error) {
+	left, err := p.parseNot(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("AND") {
+		p.advance()
+		right, err := p.parseNot(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot parses: "NOT" not | "(" or ")" | condition
+func (p *parser) parseNot(depth int) (node, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("position %d: expression is nested too deeply", p.peek().pos)
+	}
+
+	tok := p.peek()
+	switch {
+	case tok.isKeyword("NOT"):
+		p.advance()
+		inner, err := p.parseNot(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner: inner}, nil
+
+	case tok.kind == tokenLParen:
+		p.advance()
+		inner, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.advance(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("position %d: expected ) but found %s", closing.pos, closing)
+		}
+		return inner, nil
+	}
+
+	return p.parseCondition()
+}
+
+// parseCondition parses: "EXISTS" field | field op value | field "IN" "(" value ("," value)* ")"
+func (p *parser) parseCondition() (node, error) {
+	tok := p.advance()
+	if tok.isKeyword("EXISTS") {
+		field := p.advance()
+		if field.kind != tokenIdent {
+			return nil, fmt.Errorf("position %d: expected a field name after EXISTS but found %s", field.pos, field)
+		}
+		return existsNode{field: field.text}, nil
+	}
+
+	if tok.kind != tokenIdent || isReserved(tok.text) {
+		return nil, fmt.Errorf("position %d: expected a field name but found %s", tok.pos, tok)
+	}
+	field := tok.text
+
+	op := p.advance()
+	switch {
+	case op.kind == tokenOp:
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{field: field, op: op.text, values: []value{v}}, nil
+
+	case op.isKeyword("CONTAINS"):
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{field: field, op: "CONTAINS", values: []value{v}}, nil
+
+	case op.isKeyword("IN"):
+		if open := p.advance(); open.kind != tokenLParen {
+			return nil, fmt.Errorf("position %d: expected ( after IN but found %s", open.pos, open)
+		}
+		values := make([]value, 0)
+		for {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			if len(values) > maxInValues {
+				return nil, fmt.Errorf("position %d: IN takes at most %d values", op.pos, maxInValues)
+			}
+
+			sep := p.advance()
+			if sep.kind == tokenRParen {
+				break
+			}
+			if sep.kind != tokenComma {
+				return nil, fmt.Errorf("position %d: expected , or ) but found %s", sep.pos, sep)
+			}
+		}
+		return compareNode{field: field, op: "IN", values: values}, nil
+	}
+
+	return nil, fmt.Errorf("position %d: expected an operator after %s but found %s", op.pos, field, op)
+}
+
+// parseValue parses: string | number | "today" "(" ")" | "days_ago" "(" number ")"
+func (p *parser) parseValue() (value, error) {
+	tok := p.advance()
+	switch tok.kind {
+	case tokenString, tokenNumber:
+		return value{text: tok.text}, nil
+	case tokenIdent:
+		var days int
+		switch {
+		case strings.EqualFold(tok.text, "today"):
+		case strings.EqualFold(tok.text, "days_ago"):
+		default:
+			return value{}, fmt.Errorf("position %d: expected a value but found %s; quote text values", tok.pos, tok)
+		}
+
+		if open := p.advance(); open.kind != tokenLParen {
+			return value{}, fmt.Errorf("position %d: expected ( after %s", open.pos, tok.text)
+		}
+		if strings.EqualFold(tok.text, "days_ago") {
+			n := p.advance()
+			parsed, err := strconv.Atoi(n.text)
+			if n.kind != tokenNumber || err != nil {
+				return value{}, fmt.Errorf("position %d: days_ago takes a whole number of days", n.pos)
+			}
+			days = parsed
+		}
+		if closing := p.advance(); closing.kind != tokenRParen {
+			return value{}, fmt.Errorf("position %d: expected ) but found %s", closing.pos, closing)
+		}
+		return value{relative: true, daysAgo: days}, nil
+	}
+	return value{}, fmt.Errorf("position %d: expected a value but found %s", tok.pos, tok)
+}
+
+// isReserved reports whether a name is a keyword and so cannot be a field
+func isReserved(name string) bool {
+	switch strings.ToUpper(name) {
+	case "AND", "OR", "NOT", "IN", "CONTAINS", "EXISTS":
+		return true
+	}
+	return false
+}
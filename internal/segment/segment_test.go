@@ -0,0 +1,159 @@
+package segment
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseInvalid(t *testing.T) {
+	nested := func(open, close string, n int) string {
+		return strings.Repeat(open, n) + `tier = "gold"` + strings.Repeat(close, n)
+	}
+	inValues := func(n int) string {
+		values := make([]string, n)
+		for i := range values {
+			values[i] = `"x"`
+		}
+		return "city IN (" + strings.Join(values, ", ") + ")"
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		want   string // Part of the error
+	}{
+		{"empty", "  ", "empty"},
+		{"too long", `tier = "` + strings.Repeat("x", MaxLength) + `"`, "at most"},
+		{"unterminated string", `tier = "gold`, "unterminated string"},
+		{"lone bang", `tier ! "gold"`, "expected !="},
+		{"unexpected character", `tier = "gold" & city = "x"`, "unexpected character"},
+		{"invalid number", `age = 1.2.3`, "invalid number"},
+		{"missing value", `tier =`, "expected a value"},
+		{"unquoted text", `tier = gold`, "quote text values"},
+		{"keyword as field", `AND = 1`, "expected a field name"},
+		{"missing operator", `tier "gold"`, "expected an operator"},
+		{"exists without field", `EXISTS "tier"`, "after EXISTS"},
+		{"trailing tokens", `tier = "gold" city`, "unexpected"},
+		{"unclosed parenthesis", `(tier = "gold"`, "expected )"},
+		{"in without parenthesis", `city IN "x"`, "expected ( after IN"},
+		{"in without separator", `city IN ("x" "y")`, "expected , or )"},
+		{"too many in values", inValues(maxInValues + 1), "at most"},
+		{"parentheses too deep", nested("(", ")", maxDepth+2), "nested too deeply"},
+		{"not too deep", nested("NOT ", "", maxDepth+2), "nested too deeply"},
+		{"fractional days_ago", `dt >= days_ago(1.5)`, "whole number"},
+		{"days_ago without number", `dt >= days_ago()`, "whole number"},
+		{"today with argument", `dt >= today(1)`, "expected )"},
+		{"unknown function", `dt >= yesterday()`, "quote text values"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.source)
+			if err == nil {
+				t.Fatalf("Parse(%q) succeeded, want an error", tt.source)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	values := make([]string, maxInValues)
+	for i := range values {
+		values[i] = `"x"`
+	}
+
+	for _, source := range []string{
+		"city IN (" + strings.Join(values, ", ") + ")",
+		strings.Repeat("(", maxDepth) + `tier = "gold"` + strings.Repeat(")", maxDepth),
+		strings.Repeat("NOT ", maxDepth) + `tier = "gold"`,
+	} {
+		if _, err := Parse(source); err != nil {
+			t.Errorf("Parse(%.40q...) = %v, want no error", source, err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Date(2024, 3, 31, 15, 0, 0, 0, time.UTC)
+	contact := map[string]string{
+		"name":          "Budi",
+		"phone":         "628123456789",
+		"tier":          "Gold",
+		"City":          " Bandung ",
+		"age":           "10",
+		"score":         "NaN",
+		"limit":         "inf",
+		"empty":         "  ",
+		"last_purchase": "2024-03-05",
+		"signup":        "2024-03-31",
+	}
+
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		// Text comparisons ignore case and surrounding spaces
+		{`tier = "gold"`, true},
+		{`tier != "gold"`, false},
+		{`tier <> "silver"`, true},
+		{`city = "bandung"`, true},
+		{`name CONTAINS "ud"`, true},
+		{`name CONTAINS "x"`, false},
+
+		// Numbers compare as numbers
+		{`age > 9`, true},
+		{`age >= 10.0`, true},
+		{`age < 9`, false},
+		{`age = "10"`, true},
+
+		// NaN and Inf are text, not numbers
+		{`score = 1`, false},
+		{`score <= 5`, false},
+		{`score = "nan"`, true},
+		{`limit > 5`, true},
+		{`limit = "INF"`, true},
+
+		// IN
+		{`city IN ("Cimahi", "Bandung")`, true},
+		{`city IN ("Cimahi")`, false},
+		{`age IN (1, 10)`, true},
+
+		// Missing or blank fields
+		{`missing = "x"`, false},
+		{`missing != "x"`, false},
+		{`NOT missing = "x"`, true},
+		{`EXISTS tier`, true},
+		{`EXISTS missing`, false},
+		{`EXISTS empty`, false},
+		{`NOT EXISTS empty`, true},
+
+		// Dates
+		{`signup = today()`, true},
+		{`last_purchase >= days_ago(30)`, true},
+		{`last_purchase >= days_ago(7)`, false},
+		{`last_purchase < days_ago(-1)`, true},
+
+		// Precedence: NOT, then AND, then OR
+		{`tier = "gold" OR age = 1 AND city = "x"`, true},
+		{`(tier = "gold" OR age = 1) AND city = "x"`, false},
+		{`NOT tier = "gold" OR age = 10`, true},
+		{`NOT (tier = "gold" OR age = 10)`, false},
+		{`tier = "gold" and not city = "x"`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			expr, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := expr.Match(contact, now); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
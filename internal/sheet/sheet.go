@@ -0,0 +1,393 @@
+// Package sheet reads the rows of uploaded CSV and XLSX files
+package sheet
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrTooManyRows is returned when a file has more rows than the caller allows
+var ErrTooManyRows = errors.New("file has too many rows")
+
+// zipSignature starts every XLSX file
+var zipSignature = []byte("PK\x03\x04")
+
+// maxColumns is the number of columns of an Excel sheet
+const maxColumns = 16384
+
+// Limits that keep a small, highly compressed workbook from using up memory.
+// maxCellLength is the longest text Excel itself allows in a cell.
+const (
+	maxPartSize      = 64 << 20 // Decompressed size of one XML part
+	maxSharedStrings = 1 << 20
+	maxCellLength    = 32767
+)
+
+// utf8BOM is written by Excel at the start of CSV files saved as UTF-8
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// Read returns the rows of a CSV or XLSX file, at most maxRows of them. XLSX
+// files are recognised by content, not by name; only their first worksheet
+// is read. Cell values are trimmed and trailing empty cells are dropped.
+func Read(r io.ReaderAt, size int64, maxRows int) ([][]string, error) {
+	head := make([]byte, len(zipSignature))
+	n, _ := r.ReadAt(head, 0)
+	if n == len(head) && bytes.Equal(head, zipSignature) {
+		return readXLSX(r, size, maxRows)
+	}
+	return readCSV(io.NewSectionReader(r, 0, size), maxRows)
+}
+
+// readCSV reads a CSV file separated by commas or, as Excel writes it in many
+// locales, by semicolons
+func readCSV(r io.Reader, maxRows int) ([][]string, error) {
+	buffered := bufio.NewReader(r)
+	if bom, _ := buffered.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+		buffered.Discard(len(utf8BOM))
+	}
+
+	reader := csv.NewReader(buffered)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	if firstLine, _ := buffered.Peek(4096); bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+
+	rows := make([][]string, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV file: %w", err)
+		}
+		if len(rows) == maxRows {
+			return nil, ErrTooManyRows
+		}
+		rows = append(rows, trimRow(record))
+	}
+	return rows, nil
+}
+
+// readXLSX reads the first worksheet of a workbook
+func readXLSX(r io.ReaderAt, size int64, maxRows int) ([][]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid XLSX file: %w", err)
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	sheetName, err := firstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+	sheetFile, ok := files[sheetName]
+	if !ok {
+		return nil, fmt.Errorf("invalid XLSX file: worksheet %s is missing", sheetName)
+	}
+
+	var shared []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if shared, err = readSharedStrings(f); err != nil {
+			return nil, err
+		}
+	}
+
+	rc, err := openPart(sheetFile)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return readWorksheet(rc, shared, maxRows)
+}
+
+// openPart opens a part of the workbook. Parts that decompress to more than
+// maxPartSize are rejected, whatever size the zip directory claims.
+func openPart(f *zip.File) (io.ReadCloser, error) {
+	if f.UncompressedSize64 > maxPartSize {
+		return nil, fmt.Errorf("invalid XLSX file: %s is larger than %d MB", f.Name, maxPartSize>>20)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("invalid XLSX file: %w", err)
+	}
+	return &limitedPart{ReadCloser: rc, name: f.Name, left: maxPartSize}, nil
+}
+
+// limitedPart fails reads once more than maxPartSize bytes were decompressed
+type limitedPart struct {
+	io.ReadCloser
+	name string
+	left int64
+}
+
+func (p *limitedPart) Read(b []byte) (int, error) {
+	if p.left <= 0 {
+		// One byte more tells a part of exactly maxPartSize from a larger one
+		var extra [1]byte
+		n, err := p.ReadCloser.Read(extra[:])
+		if n > 0 {
+			return 0, fmt.Errorf("%s is larger than %d MB", p.name, maxPartSize>>20)
+		}
+		return 0, err
+	}
+	if int64(len(b)) > p.left {
+		b = b[:p.left]
+	}
+	n, err := p.ReadCloser.Read(b)
+	p.left -= int64(n)
+	return n, err
+}
+
+// firstSheet finds the part name of the first worksheet in the workbook
+func firstSheet(files map[string]*zip.File) (string, error) {
+	var workbook struct {
+		Sheets []struct {
+			RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+
+	if err := decodePart(files, "xl/workbook.xml", &workbook); err != nil {
+		return "", err
+	}
+	if len(workbook.Sheets) == 0 {
+		return "", errors.New("invalid XLSX file: the workbook has no sheets")
+	}
+	if err := decodePart(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return "", err
+	}
+
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].RID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return "", errors.New("invalid XLSX file: the first sheet has no part")
+}
+
+// decodePart unmarshals a small XML part of the workbook
+func decodePart(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("invalid XLSX file: %s is missing", name)
+	}
+	rc, err := openPart(f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("invalid XLSX file: %s: %w", name, err)
+	}
+	return nil
+}
+
+// readSharedStrings reads the string table cells of type "s" point into.
+// Rich text items are joined into plain text.
+func readSharedStrings(f *zip.File) ([]string, error) {
+	rc, err := openPart(f)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(rc)
+	shared := make([]string, 0)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XLSX file: shared strings: %w", err)
+		}
+
+		el, ok := token.(xml.StartElement)
+		if !ok || el.Name.Local != "si" {
+			continue
+		}
+		if len(shared) == maxSharedStrings {
+			return nil, fmt.Errorf("invalid XLSX file: more than %d shared strings", maxSharedStrings)
+		}
+
+		var item struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		}
+		if err := decoder.DecodeElement(&item, &el); err != nil {
+			return nil, fmt.Errorf("invalid XLSX file: shared strings: %w", err)
+		}
+		text := item.Text
+		for _, run := range item.Runs {
+			text += run.Text
+		}
+		if len(text) > maxCellLength && utf8.RuneCountInString(text) > maxCellLength {
+			return nil, fmt.Errorf("invalid XLSX file: shared string %d is longer than %d characters", len(shared), maxCellLength)
+		}
+		shared = append(shared, text)
+	}
+	return shared, nil
+}
+
+// xlsxCell is a <c> element of a worksheet
+type xlsxCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"is"`
+}
+
+// readWorksheet streams the rows of a worksheet. Empty rows in between are
+// kept as empty rows so row numbers match what the user sees in Excel.
+func readWorksheet(r io.Reader, shared []string, maxRows int) ([][]string, error) {
+	decoder := xml.NewDecoder(r)
+	rows := make([][]string, 0)
+	var row []string
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XLSX file: worksheet: %w", err)
+		}
+
+		switch el := token.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "row":
+				number := len(rows) + 1
+				for _, attr := range el.Attr {
+					if attr.Name.Local == "r" {
+						if n, err := strconv.Atoi(attr.Value); err == nil && n > number {
+							number = n
+						}
+					}
+				}
+				if number > maxRows {
+					return nil, ErrTooManyRows
+				}
+				for len(rows) < number-1 {
+					rows = append(rows, []string{})
+				}
+				row = []string{}
+
+			case "c":
+				var cell xlsxCell
+				if err := decoder.DecodeElement(&cell, &el); err != nil {
+					return nil, fmt.Errorf("invalid XLSX file: worksheet: %w", err)
+				}
+				index := len(row)
+				if cell.Ref != "" {
+					index = columnIndex(cell.Ref)
+				}
+				if index < 0 || index >= maxColumns {
+					return nil, fmt.Errorf("invalid XLSX file: bad cell reference %q", cell.Ref)
+				}
+				for len(row) <= index {
+					row = append(row, "")
+				}
+				value := cellValue(cell, shared)
+				if len(value) > maxCellLength && utf8.RuneCountInString(value) > maxCellLength {
+					return nil, fmt.Errorf("invalid XLSX file: cell %s is longer than %d characters", cell.Ref, maxCellLength)
+				}
+				row[index] = value
+			}
+
+		case xml.EndElement:
+			if el.Name.Local == "row" {
+				rows = append(rows, trimRow(row))
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+// cellValue returns the text of a cell
+func cellValue(cell xlsxCell, shared []string) string {
+	switch cell.Type {
+	case "s":
+		index, err := strconv.Atoi(strings.TrimSpace(cell.Value))
+		if err != nil || index < 0 || index >= len(shared) {
+			return ""
+		}
+		return shared[index]
+	case "inlineStr":
+		text := cell.Inline.Text
+		for _, run := range cell.Inline.Runs {
+			text += run.Text
+		}
+		return text
+	case "", "n":
+		// Long numbers such as phone numbers may be stored in E notation
+		if strings.ContainsAny(cell.Value, "eE") {
+			if f, err := strconv.ParseFloat(cell.Value, 64); err == nil {
+				return strconv.FormatFloat(f, 'f', -1, 64)
+			}
+		}
+		return cell.Value
+	}
+	return cell.Value // str, b and e hold their text in <v>
+}
+
+// columnIndex turns a cell reference such as "AB12" into a zero-based column
+func columnIndex(ref string) int {
+	index := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		index = index*26 + int(r-'A'+1)
+		if index > maxColumns {
+			return -1
+		}
+	}
+	return index - 1
+}
+
+// trimRow trims every value and drops the empty cells at the end of a row
+func trimRow(row []string) []string {
+	for i := range row {
+		row[i] = strings.TrimSpace(row[i])
+	}
+	for len(row) > 0 && row[len(row)-1] == "" {
+		row = row[:len(row)-1]
+	}
+	return row
+}
@@ -0,0 +1,105 @@
+package suppression
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// lookupChunk bounds the number of placeholders in a single IN query
+const lookupChunk = 500
+
+// Blocked returns the subset of phones that the sender must not message
+func Blocked(db *sql.DB, sender string, phones []string) (map[string]bool, error) {
+	blocked := make(map[string]bool)
+
+	for start := 0; start < len(phones); start += lookupChunk {
+		end := start + lookupChunk
+		if end > len(phones) {
+			end = len(phones)
+		}
+		chunk := phones[start:end]
+
+		args := make([]interface{}, 0, len(chunk)+1)
+		args = append(args, sender)
+		for _, phone := range chunk {
+			args = append(args, phone)
+		}
+
+		rows, err := db.Query(`
+			SELECT recipient 
+			FROM suppression 
+			WHERE sender = ? AND recipient IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)
+		`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("error querying suppression list: %w", err)
+		}
+
+		for rows.Next() {
+			var recipient string
+			if err := rows.Scan(&recipient); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning suppression row: %w", err)
+			}
+			blocked[recipient] = true
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating suppression rows: %w", err)
+		}
+	}
+
+	return blocked, nil
+}
+
+// IsBlocked reports whether a single recipient is on the sender's suppression list
+func IsBlocked(db *sql.DB, sender, recipient string) (bool, error) {
+	var blocked bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM suppression WHERE sender = ? AND recipient = ?)
+	`, sender, recipient).Scan(&blocked)
+	if err != nil {
+		return false, fmt.Errorf("error querying suppression list: %w", err)
+	}
+	return blocked, nil
+}
+
+// Add puts a recipient on the sender's suppression list. A recipient that is
+// already suppressed keeps its original reason and source; the returned flag
+// reports whether a new entry was created.
+func Add(db *sql.DB, sender, recipient, reason, source string) (bool, error) {
+	res, err := db.Exec(`
+		INSERT IGNORE INTO suppression (
+			sender, recipient, reason, source, dt_store
+		) VALUES (
+			?, ?, ?, ?, ?
+		)
+	`, sender, recipient, reason, source, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("error inserting suppression: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error inserting suppression: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// IsOptOut reports whether an inbound text is one of the opt-out keywords.
+// The whole message must match, ignoring case, surrounding spaces and
+// trailing punctuation, so "stop!" opts out but "don't stop" does not.
+func IsOptOut(text string, keywords []string) bool {
+	text = strings.TrimRight(strings.TrimSpace(text), ".!?")
+	if text == "" {
+		return false
+	}
+	for _, keyword := range keywords {
+		if strings.EqualFold(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
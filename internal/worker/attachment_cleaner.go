@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/attachment"
+)
+
+// AttachmentCleaner removes expired attachments and their files
+type AttachmentCleaner struct {
+	db    *sql.DB
+	store *attachment.Store
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewAttachmentCleaner creates a new attachment cleaner
+func NewAttachmentCleaner(db *sql.DB, store *attachment.Store) *AttachmentCleaner {
+	return &AttachmentCleaner{
+		db:    db,
+		store: store,
+		done:  make(chan struct{}),
+	}
+}
+
+// Run starts the attachment cleaner
+func (c *AttachmentCleaner) Run() {
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(15 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.removeExpired()
+		case <-c.done:
+			log.Println("Attachment cleaner is shutting down...")
+			return
+		}
+	}
+}
+
+// Stop signals the cleaner to stop
+func (c *AttachmentCleaner) Stop() {
+	close(c.done)
+	c.wg.Wait()
+	log.Println("Attachment cleaner stopped")
+}
+
+// removeExpired deletes expired attachment rows, then every file that no
+// remaining attachment refers to
+func (c *AttachmentCleaner) removeExpired() {
+	rows, err := c.db.Query(`
+		SELECT id, sha256 
+		FROM attachment 
+		WHERE dt_expire <= ? 
+		LIMIT 500
+	`, time.Now())
+	if err != nil {
+		log.Printf("Error querying expired attachments: %v", err)
+		return
+	}
+
+	expired := make(map[string]string)
+	for rows.Next() {
+		var id, sum string
+		if err := rows.Scan(&id, &sum); err != nil {
+			log.Printf("Error scanning attachment row: %v", err)
+			continue
+		}
+		expired[id] = sum
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating attachment rows: %v", err)
+		return
+	}
+
+	// A broadcast may have extended an attachment since it was selected
+	now := time.Now()
+	sums := make(map[string]bool)
+	for id, sum := range expired {
+		if _, err := c.db.Exec("DELETE FROM attachment WHERE id = ? AND dt_expire <= ?", id, now); err != nil {
+			log.Printf("Error deleting attachment (ID: %s): %v", id, err)
+			continue
+		}
+		sums[sum] = true
+	}
+
+	for sum := range sums {
+		if err := c.removeUnused(sum); err != nil {
+			log.Printf("Error removing attachment file %s: %v", sum, err)
+		}
+	}
+
+	if len(expired) > 0 {
+		log.Printf("Removed %d expired attachment(s)", len(expired))
+	}
+}
+
+// removeUnused deletes a file once no attachment refers to it, under the
+// same lock uploads of the same content take
+func (c *AttachmentCleaner) removeUnused(sum string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := attachment.RemoveUnused(tx, c.store, sum); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
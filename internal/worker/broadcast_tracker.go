@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// BroadcastTracker marks expanded broadcasts COMPLETED once every one of
+// their messages has reached a final status
+type BroadcastTracker struct {
+	db   *sql.DB
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewBroadcastTracker creates a new broadcast tracker
+func NewBroadcastTracker(db *sql.DB) *BroadcastTracker {
+	return &BroadcastTracker{
+		db:   db,
+		done: make(chan struct{}),
+	}
+}
+
+// Run starts the broadcast tracker
+func (t *BroadcastTracker) Run() {
+	t.wg.Add(1)
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.markCompleted()
+		case <-t.done:
+			log.Println("Broadcast tracker is shutting down...")
+			return
+		}
+	}
+}
+
+// Stop signals the tracker to stop
+func (t *BroadcastTracker) Stop() {
+	close(t.done)
+	t.wg.Wait()
+	log.Println("Broadcast tracker stopped")
+}
+
+// markCompleted completes the DONE broadcasts without pending or processing
+// messages. The completion time is that of the last message sent, or now
+// when none was sent. Replicas running this at the same time complete each
+// broadcast once, since the update only matches DONE rows. The check for
+// unfinished messages is an index lookup on (sender, type, status).
+func (t *BroadcastTracker) markCompleted() {
+	now := time.Now()
+	res, err := t.db.Exec(`
+		UPDATE message_bulk b
+		SET b.status = ?,
+			b.dt_complete = COALESCE((
+				SELECT MAX(m.dt_send)
+				FROM message m
+				WHERE m.sender = b.sender AND m.type = CAST(b.id AS CHAR)
+			), ?)
+		WHERE b.status = ? AND NOT EXISTS (
+			SELECT 1
+			FROM message m
+			WHERE m.sender = b.sender AND m.type = CAST(b.id AS CHAR) AND m.status IN (?, ?)
+		)
+	`, models.BulkStatusCompleted, now, models.BulkStatusDone, models.StatusPending, models.StatusProcessing)
+	if err != nil {
+		log.Printf("Error completing broadcasts: %v", err)
+		return
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected > 0 {
+		log.Printf("Completed %d broadcast(s)", affected)
+	}
+}
@@ -3,30 +3,49 @@ package worker
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"sync"
 	"time"
 
+	"github.com/partadox/wags_queue/internal/config"
+	"github.com/partadox/wags_queue/internal/consent"
+	"github.com/partadox/wags_queue/internal/contacts"
 	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/msgtemplate"
+	"github.com/partadox/wags_queue/internal/schedule"
+	"github.com/partadox/wags_queue/internal/suppression"
 )
 
+// attachmentGrace is how long an attachment is kept after the last message
+// of a broadcast that uses it is queued, to leave room for retries
+const attachmentGrace = 24 * time.Hour
+
+// errAttachmentGone fails a broadcast whose attachment expired or was deleted
+// before it was expanded
+var errAttachmentGone = errors.New("attachment expired or was deleted before the broadcast was expanded")
+
 // BulkProcessor handles the processing of bulk messages
 type BulkProcessor struct {
-	db   *sql.DB
-	done chan struct{}
-	wg   sync.WaitGroup
+	db    *sql.DB
+	cfg   config.WorkerConfig
+	limit schedule.DailyLimit // Default daily limit, refined per sender
+	clock schedule.Clock
+	sem   chan struct{} // Limits the number of concurrent expansions
+	done  chan struct{}
+	wg    sync.WaitGroup // Tracks Run and every in-flight expansion
 }
 
 // NewBulkProcessor creates a new bulk message processor
-func NewBulkProcessor(db *sql.DB) *BulkProcessor {
-	// Initialize random seed
-	rand.Seed(time.Now().UnixNano())
-	
+func NewBulkProcessor(db *sql.DB, cfg config.WorkerConfig, scheduleCfg config.ScheduleConfig) *BulkProcessor {
 	return &BulkProcessor{
-		db:   db,
-		done: make(chan struct{}),
+		db:    db,
+		cfg:   cfg,
+		limit: schedule.LimitFromConfig(scheduleCfg),
+		clock: schedule.SystemClock{},
+		sem:   make(chan struct{}, cfg.BulkConcurrency),
+		done:  make(chan struct{}),
 	}
 }
 
@@ -49,76 +68,120 @@ func (p *BulkProcessor) Run() {
 	}
 }
 
-// Stop signals the processor to stop
+// Stop signals the processor to stop and waits for in-flight expansions
 func (p *BulkProcessor) Stop() {
 	close(p.done)
 	p.wg.Wait()
 	log.Println("Bulk processor stopped")
 }
 
-// processBulkMessages processes pending bulk messages
+// processBulkMessages claims pending bulk messages and expands them
+// in the background, never running more than cfg.BulkConcurrency at once
 func (p *BulkProcessor) processBulkMessages() {
-	// Begin transaction
-	tx, err := p.db.Begin()
-	if err != nil {
-		log.Printf("Error beginning transaction: %v", err)
+	free := cap(p.sem) - len(p.sem)
+	if free == 0 {
 		return
 	}
-	defer func() {
-		if err := recover(); err != nil {
-			tx.Rollback()
-			log.Printf("Panic in processBulkMessages: %v", err)
-		}
-	}()
 
-	// Get a batch of bulk messages to process
-	rows, err := tx.Query(`
-		SELECT id, sender, bulk, dt_store 
+	// Claims older than the timeout belong to a replica that died mid-expansion.
+	// Expansion runs in a single transaction, so nothing of theirs was committed.
+	staleBefore := time.Now().Add(-p.cfg.BulkClaimTimeout)
+
+	rows, err := p.db.Query(`
+		SELECT id 
 		FROM message_bulk 
-		WHERE status = ? 
-		LIMIT 5
-	`, models.BulkStatusProcess)
+		WHERE status = ? OR (status = ? AND dt_claim < ?) 
+		ORDER BY id ASC 
+		LIMIT ?
+	`, models.BulkStatusProcess, models.BulkStatusExpanding, staleBefore, free)
 
 	if err != nil {
-		tx.Rollback()
 		log.Printf("Error querying bulk messages: %v", err)
 		return
 	}
-	defer rows.Close()
 
-	bulksToProcess := make([]models.MessageBulk, 0)
+	candidates := make([]int, 0, free)
 	for rows.Next() {
-		var bulk models.MessageBulk
-		if err := rows.Scan(&bulk.ID, &bulk.Sender, &bulk.Bulk, &bulk.DTStore); err != nil {
+		var id int
+		if err := rows.Scan(&id); err != nil {
 			log.Printf("Error scanning bulk message row: %v", err)
 			continue
 		}
-		bulksToProcess = append(bulksToProcess, bulk)
+		candidates = append(candidates, id)
 	}
+	rows.Close()
 
 	if err := rows.Err(); err != nil {
-		tx.Rollback()
 		log.Printf("Error iterating bulk message rows: %v", err)
 		return
 	}
 
-	// If no bulk messages to process, commit empty transaction and return
-	if len(bulksToProcess) == 0 {
-		if err := tx.Commit(); err != nil {
-			log.Printf("Error committing transaction: %v", err)
+	for _, id := range candidates {
+		bulk, ok := p.claimBulk(id, staleBefore)
+		if !ok {
+			continue // Another replica got there first
 		}
-		return
+
+		p.sem <- struct{}{}
+		p.wg.Add(1)
+		go func(bulk models.MessageBulk) {
+			defer p.wg.Done()
+			defer func() { <-p.sem }()
+			p.processBulkMessage(bulk)
+		}(bulk)
 	}
+}
 
-	// Commit transaction to release locks before processing
-	if err := tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		return
+// claimBulk marks a bulk message as being expanded by this replica.
+// It returns false when the row was claimed by someone else in the meantime.
+func (p *BulkProcessor) claimBulk(bulkID int, staleBefore time.Time) (models.MessageBulk, bool) {
+	var bulk models.MessageBulk
+
+	res, err := p.db.Exec(`
+		UPDATE message_bulk 
+		SET status = ?, 
+			claimed_by = ?, 
+			dt_claim = ? 
+		WHERE id = ? AND (status = ? OR (status = ? AND dt_claim < ?))
+	`, models.BulkStatusExpanding, p.cfg.InstanceID, time.Now(), bulkID,
+		models.BulkStatusProcess, models.BulkStatusExpanding, staleBefore)
+
+	if err != nil {
+		log.Printf("Error claiming bulk message (ID: %d): %v", bulkID, err)
+		return bulk, false
 	}
 
-	// Process each bulk message
-	for _, bulk := range bulksToProcess {
-		go p.processBulkMessage(bulk) // Process in goroutine for non-blocking operation
+	if affected, err := res.RowsAffected(); err != nil || affected != 1 {
+		return bulk, false
+	}
+
+	err = p.db.QueryRow(`
+		SELECT id, sender, bulk, hygiene, dt_store 
+		FROM message_bulk 
+		WHERE id = ?
+	`, bulkID).Scan(&bulk.ID, &bulk.Sender, &bulk.Bulk, &bulk.Hygiene, &bulk.DTStore)
+
+	if err != nil {
+		log.Printf("Error loading claimed bulk message (ID: %d): %v", bulkID, err)
+		p.releaseBulk(bulkID)
+		return bulk, false
+	}
+
+	return bulk, true
+}
+
+// releaseBulk hands a claimed bulk message back so it can be picked up again
+func (p *BulkProcessor) releaseBulk(bulkID int) {
+	_, err := p.db.Exec(`
+		UPDATE message_bulk 
+		SET status = ?, 
+			claimed_by = NULL, 
+			dt_claim = NULL 
+		WHERE id = ? AND claimed_by = ?
+	`, models.BulkStatusProcess, bulkID, p.cfg.InstanceID)
+
+	if err != nil {
+		log.Printf("Error releasing bulk message (ID: %d): %v", bulkID, err)
 	}
 }
 
@@ -126,90 +189,349 @@ func (p *BulkProcessor) processBulkMessages() {
 func (p *BulkProcessor) processBulkMessage(bulk models.MessageBulk) {
 	// Parse the bulk message data
 	var bulkData struct {
-		Recipients []string `json:"recipients"`
-		Message    string   `json:"message"`
+		Recipients      []models.Recipient     `json:"recipients"`
+		GroupIDs        []int                  `json:"group_ids"`
+		SegmentIDs      []int                  `json:"segment_ids"`
+		Message         string                 `json:"message"`
+		Variables       map[string]string      `json:"variables"`
+		TemplateID      *int                   `json:"template_id"`
+		TemplateVersion int                    `json:"template_version"`
+		ContentType     models.ContentType     `json:"type"`
+		Payload         *models.MessagePayload `json:"payload"`
+		Category        models.MessageCategory `json:"category"`
+		Pacing          *models.PacingOptions  `json:"pacing"`
 	}
 
 	if err := json.Unmarshal(bulk.Bulk, &bulkData); err != nil {
 		log.Printf("Error unmarshalling bulk data (ID: %d): %v", bulk.ID, err)
-		p.updateBulkStatus(bulk.ID, models.BulkStatusFailed)
+		p.failBulk(bulk.ID, fmt.Sprintf("Invalid bulk data: %v", err))
 		return
 	}
 
-	// Insert individual messages for each recipient
-	// Calculate time window based on bulk size and max rate to make it look natural
-	totalRecipients := len(bulkData.Recipients)
-	maxRatePerMinute := 100 // Maximum allowed rate
-	
-	// Determine minimum total time needed for all messages (in seconds)
-	// If recipients count is under max rate, we'll still spread over at least 30 seconds
-	minTotalTimeSeconds := 30
-	if totalRecipients > maxRatePerMinute {
-		minTotalTimeSeconds = (totalRecipients * 60) / maxRatePerMinute
-	}
-	
-	// Base delay for each message
-	baseDelay := time.Duration(minTotalTimeSeconds) * time.Second / time.Duration(totalRecipients)
-	
-	// Store time for the first message
-	baseTime := bulk.DTStore
-	
+	// Contact groups and segments are resolved now, so the broadcast reaches their current members.
+	// The members left out are added to the hygiene report of the broadcast.
+	hygieneJSON := []byte(bulk.Hygiene)
+	if len(bulkData.GroupIDs) > 0 || len(bulkData.SegmentIDs) > 0 {
+		report := &models.HygieneReport{}
+		if len(bulk.Hygiene) > 0 {
+			if err := json.Unmarshal(bulk.Hygiene, report); err != nil {
+				log.Printf("Error unmarshalling hygiene report (ID: %d): %v", bulk.ID, err)
+				p.failBulk(bulk.ID, fmt.Sprintf("Invalid hygiene report: %v", err))
+				return
+			}
+		}
+		members, err := p.groupMembers(bulk, bulkData.GroupIDs, bulkData.SegmentIDs, bulkData.Message, bulkData.Variables, bulkData.Category, report)
+		if errors.Is(err, contacts.ErrInvalidSegment) || errors.Is(err, contacts.ErrInvalidAttributes) {
+			// Resolving again would fail the same way
+			log.Printf("Error resolving contact groups and segments (Bulk ID: %d): %v", bulk.ID, err)
+			p.failBulk(bulk.ID, err.Error())
+			return
+		} else if err != nil {
+			log.Printf("Error resolving contact groups and segments (Bulk ID: %d): %v", bulk.ID, err)
+			p.releaseBulk(bulk.ID)
+			return
+		}
+		bulkData.Recipients = contacts.Merge(bulkData.Recipients, members)
+		if hygieneJSON, err = json.Marshal(report); err != nil {
+			log.Printf("Error marshalling hygiene report (ID: %d): %v", bulk.ID, err)
+			p.releaseBulk(bulk.ID)
+			return
+		}
+	}
+
+	// Build the pacing strategy chosen for this broadcast
+	strategy, err := schedule.FromOptions(bulkData.Pacing)
+	if err != nil {
+		log.Printf("Invalid pacing options (Bulk ID: %d): %v", bulk.ID, err)
+		p.failBulk(bulk.ID, fmt.Sprintf("Invalid pacing options: %v", err))
+		return
+	}
+
+	planner := schedule.Planner{Strategy: strategy, Clock: p.clock}
+	if bulkData.Pacing != nil {
+		planner.Seed = bulkData.Pacing.Seed
+	}
+
+	// Spread the broadcast over several days when it exceeds the sender's daily cap
+	start := bulk.DTStore
+	if start.IsZero() {
+		start = p.clock.Now()
+	}
+	// Insert all children in one transaction so a crash never leaves a half-expanded broadcast
+	tx, err := p.db.Begin()
+	if err != nil {
+		log.Printf("Error beginning transaction (Bulk ID: %d): %v", bulk.ID, err)
+		p.releaseBulk(bulk.ID)
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the sender so broadcasts of the same sender expanded at the same
+	// time plan one after the other, each seeing the messages of the other
+	var locked string
+	if err := tx.QueryRow("SELECT username FROM user WHERE username = ? FOR UPDATE", bulk.Sender).Scan(&locked); err != nil {
+		log.Printf("Error locking sender %s (Bulk ID: %d): %v", bulk.Sender, bulk.ID, err)
+		p.releaseBulk(bulk.ID)
+		return
+	}
+
+	limit, err := schedule.LoadDailyLimit(tx, bulk.Sender, start, p.limit)
+	if err != nil {
+		log.Printf("Error loading daily limit (Bulk ID: %d): %v", bulk.ID, err)
+		p.releaseBulk(bulk.ID)
+		return
+	}
+
+	// Every child carries the same content type, payload and category
+	if bulkData.ContentType == "" {
+		bulkData.ContentType = models.ContentText
+	}
+	if bulkData.Category == "" {
+		bulkData.Category = models.CategoryTransactional
+	}
+
+	// Personalize the message for every recipient. Messages that cannot be
+	// rendered, or come out too long for the gateway, are stored as FAILED
+	// and take no slot of the daily plan.
+	contents := make([]string, len(bulkData.Recipients))
+	renderErrors := make([]error, len(bulkData.Recipients))
+	sendable := 0
 	for i, recipient := range bulkData.Recipients {
-		// Add random variance to queue time (±50% of base delay)
-		randomFactor := 0.5 + rand.Float64()
-		messageDelay := time.Duration(float64(baseDelay) * randomFactor)
-		
-		// Calculate queue time by adding progressive delay to base time
-		queueTime := baseTime.Add(time.Duration(i) * baseDelay + messageDelay)
-		
-		// For first few messages, apply smaller delays to appear natural
-		if i < 3 {
-			// First message: 1-3 seconds delay
-			// Second message: 2-5 seconds delay
-			// Third message: 3-8 seconds delay
-			randomSeconds := rand.Intn(3) + i + 1
-			queueTime = baseTime.Add(time.Duration(randomSeconds) * time.Second)
+		content, err := msgtemplate.Render(bulkData.Message, msgtemplate.Merge(bulkData.Variables, recipient.Vars))
+		if err == nil {
+			err = bulkData.ContentType.CheckText(content)
+		}
+		if err != nil {
+			log.Printf("Error rendering message for recipient %s (Bulk ID: %d): %v", 
+				recipient.Phone, bulk.ID, err)
+			renderErrors[i] = err
+			continue
+		}
+		contents[i] = content
+		sendable++
+	}
+	queueTimes := planner.PlanDaily(start, sendable, limit)
+
+	// Record the template version on every child for auditing
+	var templateID, templateVersion sql.NullInt64
+	if bulkData.TemplateID != nil {
+		templateID = sql.NullInt64{Int64: int64(*bulkData.TemplateID), Valid: true}
+		templateVersion = sql.NullInt64{Int64: int64(bulkData.TemplateVersion), Valid: true}
+	}
+	var payloadJSON []byte
+	if bulkData.Payload != nil {
+		if payloadJSON, err = json.Marshal(bulkData.Payload); err != nil {
+			log.Printf("Error marshalling payload (Bulk ID: %d): %v", bulk.ID, err)
+			p.failBulk(bulk.ID, fmt.Sprintf("Invalid payload: %v", err))
+			return
+		}
+	}
+
+	// An uploaded attachment must outlive the last message of the broadcast
+	if bulkData.Payload != nil && bulkData.Payload.Media != nil && bulkData.Payload.Media.AttachmentID != "" {
+		if err := p.keepAttachment(tx, bulk, bulkData.Payload.Media.AttachmentID, queueTimes); err != nil {
+			log.Printf("Error extending attachment (Bulk ID: %d): %v", bulk.ID, err)
+			if err == errAttachmentGone {
+				p.failBulk(bulk.ID, err.Error())
+			} else {
+				p.releaseBulk(bulk.ID)
+			}
+			return
+		}
+	}
+
+	// Recipients who opted out since the broadcast was submitted are kept as SUPPRESSED
+	phones := make([]string, len(bulkData.Recipients))
+	for i, recipient := range bulkData.Recipients {
+		phones[i] = recipient.Phone
+	}
+	blocked, err := suppression.Blocked(p.db, bulk.Sender, phones)
+	if err != nil {
+		log.Printf("Error checking suppression list (Bulk ID: %d): %v", bulk.ID, err)
+		p.releaseBulk(bulk.ID)
+		return
+	}
+
+	slot := 0
+	for i, recipient := range bulkData.Recipients {
+		status := models.StatusPending
+		content := contents[i]
+		dtQueue := start
+		var failureReason sql.NullString
+		if renderErrors[i] != nil {
+			status = models.StatusFailed
+			content = bulkData.Message // Kept unrendered for reference
+			failureReason = sql.NullString{
+				String: truncate(fmt.Sprintf("Error rendering message: %v", renderErrors[i]), maxFailureReasonLength),
+				Valid:  true,
+			}
+		} else {
+			dtQueue = queueTimes[slot]
+			slot++
+			if blocked[recipient.Phone] {
+				status = models.StatusSuppressed
+				failureReason = sql.NullString{String: suppressedReason, Valid: true}
+			}
 		}
 		
-		_, err := p.db.Exec(`
+		_, err = tx.Exec(`
 			INSERT INTO message (
-				sender, recipient, status, type, dt_store, dt_queue, message
+				sender, recipient, status, type, dt_store, dt_queue, message, template_id, template_version, 
+				content_type, payload, category, failure_reason
 			) VALUES (
-				?, ?, ?, ?, ?, ?, ?
+				?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 			)
 		`,
 			bulk.Sender,
-			recipient,
-			models.StatusPending,
+			recipient.Phone,
+			status,
 			fmt.Sprintf("%d", bulk.ID), // Store bulk ID as type
 			bulk.DTStore,               // Use the same dt_store as the bulk message
-			queueTime,                  // Set calculated queue time with natural delay
-			bulkData.Message,
+			dtQueue,                    // Set queue time from the pacing strategy
+			content,
+			templateID,
+			templateVersion,
+			bulkData.ContentType,
+			payloadJSON,
+			bulkData.Category,
+			failureReason,
 		)
 
 		if err != nil {
 			log.Printf("Error inserting individual message for recipient %s (Bulk ID: %d): %v", 
-				recipient, bulk.ID, err)
+				recipient.Phone, bulk.ID, err)
 			// Continue with other recipients
 		}
 	}
 
-	// Update bulk message status to DONE
-	p.updateBulkStatus(bulk.ID, models.BulkStatusDone)
+	// Update bulk message status to DONE, unless our claim was taken over meanwhile
+	res, err := tx.Exec(`
+		UPDATE message_bulk 
+		SET status = ?, 
+			dt_convert = ?, 
+			hygiene = ? 
+		WHERE id = ? AND claimed_by = ?
+	`, models.BulkStatusDone, time.Now(), hygieneJSON, bulk.ID, p.cfg.InstanceID)
+
+	if err != nil {
+		log.Printf("Error updating bulk message status (ID: %d): %v", bulk.ID, err)
+		tx.Rollback()
+		p.releaseBulk(bulk.ID)
+		return
+	}
+
+	if affected, err := res.RowsAffected(); err != nil || affected != 1 {
+		log.Printf("Lost claim on bulk message (ID: %d), discarding expansion", bulk.ID)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing bulk expansion (ID: %d): %v", bulk.ID, err)
+		p.releaseBulk(bulk.ID)
+		return
+	}
+
 	log.Printf("Bulk message processed successfully (ID: %d), created %d individual messages", 
 		bulk.ID, len(bulkData.Recipients))
 }
 
-// updateBulkStatus updates the status of a bulk message
-func (p *BulkProcessor) updateBulkStatus(bulkID int, status models.BulkMessageStatus) {
-	_, err := p.db.Exec(`
+// groupMembers returns the members of the contact groups and the contacts
+// matching the segments of a broadcast. The explicit recipients were checked when the broadcast was submitted; members
+// get the same checks here: those missing a template variable are left out,
+// and so are those without consent when the broadcast is marketing. Both are
+// counted and listed in report.
+func (p *BulkProcessor) groupMembers(bulk models.MessageBulk, groupIDs, segmentIDs []int, message string, variables map[string]string, category models.MessageCategory, report *models.HygieneReport) ([]models.Recipient, error) {
+	members, err := contacts.Recipients(p.db, bulk.Sender, groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	matched, err := contacts.SegmentRecipients(p.db, bulk.Sender, segmentIDs, p.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	members = contacts.Merge(members, matched)
+
+	var consented map[string]bool
+	if category == models.CategoryMarketing {
+		phones := make([]string, len(members))
+		for i, member := range members {
+			phones[i] = member.Phone
+		}
+		if consented, err = consent.Consented(p.db, bulk.Sender, phones, p.clock.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	kept := members[:0]
+	var missingVars, noConsent int
+	for _, member := range members {
+		if len(msgtemplate.Missing(message, msgtemplate.Merge(variables, member.Vars))) > 0 {
+			missingVars++
+			report.MissingVariables++
+			report.MissingVarsList = append(report.MissingVarsList, member.Phone)
+			continue
+		}
+		if consented != nil && !consented[member.Phone] {
+			noConsent++
+			report.NoConsent++
+			report.NoConsentList = append(report.NoConsentList, member.Phone)
+			continue
+		}
+		kept = append(kept, member)
+	}
+
+	if missingVars > 0 || noConsent > 0 {
+		log.Printf("Left out %d group or segment member(s) missing template variables and %d without consent (Bulk ID: %d)",
+			missingVars, noConsent, bulk.ID)
+	}
+	return kept, nil
+}
+
+// keepAttachment extends the expiry of an attachment to a day past the last
+// queue time of the broadcast, so children queued late still find it
+func (p *BulkProcessor) keepAttachment(tx *sql.Tx, bulk models.MessageBulk, attachmentID string, queueTimes []time.Time) error {
+	last := p.clock.Now()
+	for _, t := range queueTimes {
+		if t.After(last) {
+			last = t
+		}
+	}
+
+	// Locked, so the cleaner cannot remove it between the check and the update
+	var dtExpire time.Time
+	err := tx.QueryRow("SELECT dt_expire FROM attachment WHERE id = ? AND owner = ? FOR UPDATE",
+		attachmentID, bulk.Sender).Scan(&dtExpire)
+	if err == sql.ErrNoRows || (err == nil && !dtExpire.After(p.clock.Now())) {
+		return errAttachmentGone
+	} else if err != nil {
+		return err
+	}
+
+	keepUntil := last.Add(attachmentGrace)
+	if !keepUntil.After(dtExpire) {
+		return nil
+	}
+	_, err = tx.Exec("UPDATE attachment SET dt_expire = ? WHERE id = ?", keepUntil, attachmentID)
+	return err
+}
+
+// failBulk marks a bulk message FAILED with the reason, unless our claim
+// was taken over meanwhile
+func (p *BulkProcessor) failBulk(bulkID int, reason string) {
+	res, err := p.db.Exec(`
 		UPDATE message_bulk 
 		SET status = ?, 
-			dt_convert = ? 
-		WHERE id = ?
-	`, status, time.Now(), bulkID)
+			dt_convert = ?, 
+			failure_reason = ? 
+		WHERE id = ? AND claimed_by = ?
+	`, models.BulkStatusFailed, time.Now(), truncate(reason, maxFailureReasonLength), bulkID, p.cfg.InstanceID)
 
 	if err != nil {
 		log.Printf("Error updating bulk message status (ID: %d): %v", bulkID, err)
+		return
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected != 1 {
+		log.Printf("Lost claim on bulk message (ID: %d), failure not recorded", bulkID)
 	}
 }
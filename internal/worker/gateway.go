@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"fmt"
+
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// gatewayTextRequest is the body sent to the external API for text messages
+type gatewayTextRequest struct {
+	Recipient string `json:"recipient"`
+	Message   string `json:"message"`
+}
+
+// gatewayMediaRequest is the body sent for image, document, audio and video messages
+type gatewayMediaRequest struct {
+	Recipient string `json:"recipient"`
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+	Caption   string `json:"caption,omitempty"`
+	Filename  string `json:"filename,omitempty"`
+	MimeType  string `json:"mimetype,omitempty"`
+}
+
+// gatewayLocationRequest is the body sent for location messages
+type gatewayLocationRequest struct {
+	Recipient string  `json:"recipient"`
+	Type      string  `json:"type"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
+}
+
+// gatewayInteractiveRequest is the body sent for button and list messages
+type gatewayInteractiveRequest struct {
+	Recipient   string             `json:"recipient"`
+	Type        string             `json:"type"`
+	Interactive gatewayInteractive `json:"interactive"`
+}
+
+// gatewayInteractive is the interactive part of a gateway request
+type gatewayInteractive struct {
+	Type     string               `json:"type"` // button or list
+	Header   string               `json:"header,omitempty"`
+	Body     string               `json:"body"`
+	Footer   string               `json:"footer,omitempty"`
+	Buttons  []models.ReplyButton `json:"buttons,omitempty"`
+	Button   string               `json:"button,omitempty"`
+	Sections []models.ListSection `json:"sections,omitempty"`
+}
+
+// buildGatewayRequest maps a queued message to the request body of the external API
+func buildGatewayRequest(msg models.Message) (interface{}, error) {
+	switch msg.ContentType {
+	case "", models.ContentText:
+		return gatewayTextRequest{
+			Recipient: msg.Recipient,
+			Message:   msg.MessageContent,
+		}, nil
+
+	case models.ContentImage, models.ContentDocument, models.ContentAudio, models.ContentVideo:
+		if msg.Payload == nil || msg.Payload.Media == nil {
+			return nil, fmt.Errorf("%s message without media", msg.ContentType)
+		}
+		media := msg.Payload.Media
+		return gatewayMediaRequest{
+			Recipient: msg.Recipient,
+			Type:      string(msg.ContentType),
+			URL:       media.URL,
+			Caption:   msg.MessageContent,
+			Filename:  media.Filename,
+			MimeType:  media.MimeType,
+		}, nil
+
+	case models.ContentLocation:
+		if msg.Payload == nil || msg.Payload.Location == nil {
+			return nil, fmt.Errorf("location message without coordinates")
+		}
+		location := msg.Payload.Location
+		return gatewayLocationRequest{
+			Recipient: msg.Recipient,
+			Type:      string(msg.ContentType),
+			Latitude:  location.Latitude,
+			Longitude: location.Longitude,
+			Name:      location.Name,
+			Address:   location.Address,
+		}, nil
+
+	case models.ContentInteractive:
+		if msg.Payload == nil || msg.Payload.Interactive == nil {
+			return nil, fmt.Errorf("interactive message without buttons or list")
+		}
+		interactive := msg.Payload.Interactive
+		kind := "button"
+		if interactive.Kind == models.InteractiveList {
+			kind = "list"
+		}
+		return gatewayInteractiveRequest{
+			Recipient: msg.Recipient,
+			Type:      string(msg.ContentType),
+			Interactive: gatewayInteractive{
+				Type:     kind,
+				Header:   interactive.Header,
+				Body:     msg.MessageContent,
+				Footer:   interactive.Footer,
+				Buttons:  interactive.Buttons,
+				Button:   interactive.ButtonText,
+				Sections: interactive.Sections,
+			},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported message type %q", msg.ContentType)
+}
@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// IdempotencyCleaner removes stored responses whose replay window has passed
+type IdempotencyCleaner struct {
+	db   *sql.DB
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewIdempotencyCleaner creates a new idempotency key cleaner
+func NewIdempotencyCleaner(db *sql.DB) *IdempotencyCleaner {
+	return &IdempotencyCleaner{
+		db:   db,
+		done: make(chan struct{}),
+	}
+}
+
+// Run starts the idempotency key cleaner
+func (c *IdempotencyCleaner) Run() {
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.removeExpired()
+		case <-c.done:
+			log.Println("Idempotency cleaner is shutting down...")
+			return
+		}
+	}
+}
+
+// Stop signals the cleaner to stop
+func (c *IdempotencyCleaner) Stop() {
+	close(c.done)
+	c.wg.Wait()
+	log.Println("Idempotency cleaner stopped")
+}
+
+// removeExpired deletes expired idempotency keys in batches
+func (c *IdempotencyCleaner) removeExpired() {
+	var removed int64
+	for {
+		res, err := c.db.Exec(`
+			DELETE FROM idempotency_key 
+			WHERE dt_expire <= ? 
+			LIMIT 1000
+		`, time.Now())
+		if err != nil {
+			log.Printf("Error deleting expired idempotency keys: %v", err)
+			return
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil || affected == 0 {
+			break
+		}
+		removed += affected
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d expired idempotency key(s)", removed)
+	}
+}
@@ -0,0 +1,169 @@
+package worker
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/jobs"
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// jobInterruptedError is recorded for jobs whose replica stopped without queueing them again
+const jobInterruptedError = "Job was interrupted, please submit it again"
+
+// JobCleaner removes expired jobs and their result files, and fails jobs
+// whose replica died while running them. Result files whose job is gone, for
+// example with a deleted user, and abandoned temp files are removed as well.
+type JobCleaner struct {
+	db    *sql.DB
+	store *jobs.Store
+	ttl   time.Duration // How long a failed job is kept
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewJobCleaner creates a new job cleaner
+func NewJobCleaner(db *sql.DB, store *jobs.Store, ttl time.Duration) *JobCleaner {
+	return &JobCleaner{
+		db:    db,
+		store: store,
+		ttl:   ttl,
+		done:  make(chan struct{}),
+	}
+}
+
+// Run starts the job cleaner
+func (c *JobCleaner) Run() {
+	c.wg.Add(1)
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.failStale()
+			c.removeExpired()
+			c.removeOrphans()
+		case <-c.done:
+			log.Println("Job cleaner is shutting down...")
+			return
+		}
+	}
+}
+
+// Stop signals the cleaner to stop
+func (c *JobCleaner) Stop() {
+	close(c.done)
+	c.wg.Wait()
+	log.Println("Job cleaner stopped")
+}
+
+// failStale fails running jobs that stopped sending heartbeats
+func (c *JobCleaner) failStale() {
+	now := time.Now()
+	res, err := c.db.Exec(`
+		UPDATE job
+		SET status = ?,
+			error = ?,
+			dt_finish = ?,
+			dt_expire = ?
+		WHERE status = ? AND dt_update < ?
+	`, models.JobStatusFailed, jobInterruptedError, now, now.Add(c.ttl),
+		models.JobStatusRunning, now.Add(-jobStaleAfter))
+	if err != nil {
+		log.Printf("Error failing stale jobs: %v", err)
+		return
+	}
+
+	if affected, _ := res.RowsAffected(); affected > 0 {
+		log.Printf("Failed %d stale job(s)", affected)
+	}
+}
+
+// removeExpired deletes expired job rows and their result files
+func (c *JobCleaner) removeExpired() {
+	rows, err := c.db.Query(`
+		SELECT id, format
+		FROM job
+		WHERE dt_expire <= ?
+		LIMIT 500
+	`, time.Now())
+	if err != nil {
+		log.Printf("Error querying expired jobs: %v", err)
+		return
+	}
+
+	expired := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var format string
+		if err := rows.Scan(&id, &format); err != nil {
+			log.Printf("Error scanning job row: %v", err)
+			continue
+		}
+		expired[id] = format
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating job rows: %v", err)
+		return
+	}
+
+	removed := 0
+	for id, format := range expired {
+		// The file goes first, so a job row never outlives the cleanup of its file
+		if err := c.store.Remove(id, format); err != nil {
+			log.Printf("Error removing result of job %d: %v", id, err)
+			continue
+		}
+		if _, err := c.db.Exec("DELETE FROM job WHERE id = ?", id); err != nil {
+			log.Printf("Error deleting job (ID: %d): %v", id, err)
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d expired job(s)", removed)
+	}
+}
+
+// removeOrphans deletes result files without a job row and temp files, once
+// they are older than the result TTL. A temp file that old was left by a
+// replica that stopped while writing it.
+func (c *JobCleaner) removeOrphans() {
+	old, err := c.store.OldFiles(time.Now().Add(-c.ttl))
+	if err != nil {
+		log.Printf("Error listing job files: %v", err)
+		return
+	}
+
+	removed := 0
+	for _, file := range old {
+		if file.JobID != 0 {
+			var exists int
+			err := c.db.QueryRow("SELECT COUNT(*) FROM job WHERE id = ?", file.JobID).Scan(&exists)
+			if err != nil {
+				log.Printf("Error checking job of %s: %v", file.Name, err)
+				continue
+			}
+			if exists > 0 {
+				continue // Removed with its job once it expires
+			}
+		}
+		if err := c.store.RemoveFile(file.Name); err != nil {
+			log.Printf("Error removing job file %s: %v", file.Name, err)
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log.Printf("Removed %d orphaned job file(s)", removed)
+	}
+}
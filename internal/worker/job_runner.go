@@ -0,0 +1,323 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/partadox/wags_queue/internal/config"
+	"github.com/partadox/wags_queue/internal/jobs"
+	"github.com/partadox/wags_queue/internal/models"
+)
+
+// jobHeartbeat is how often a running job records its progress and checks
+// whether it was cancelled
+const jobHeartbeat = 10 * time.Second
+
+// jobStaleAfter is how long a running job may go without a heartbeat before
+// its replica is considered dead
+const jobStaleAfter = 2 * time.Minute
+
+// JobRunner runs queued export and report jobs and writes their results to the job store
+type JobRunner struct {
+	db         *sql.DB
+	cfg        config.JobConfig
+	instanceID string
+	store      *jobs.Store
+	sem        chan struct{} // Limits the number of jobs run at the same time
+	ctx        context.Context
+	cancel     context.CancelFunc // Interrupts running jobs on shutdown
+	done       chan struct{}
+	wg         sync.WaitGroup // Tracks Run and every running job
+}
+
+// queuedJob is a claimed job as read from the job table
+type queuedJob struct {
+	id     int
+	kind   string
+	params jobs.Params
+}
+
+// NewJobRunner creates a new job runner
+func NewJobRunner(db *sql.DB, cfg config.JobConfig, workerCfg config.WorkerConfig, store *jobs.Store) *JobRunner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobRunner{
+		db:         db,
+		cfg:        cfg,
+		instanceID: workerCfg.InstanceID,
+		store:      store,
+		sem:        make(chan struct{}, cfg.Concurrency),
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+}
+
+// Run starts the job runner
+func (j *JobRunner) Run() {
+	j.wg.Add(1)
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.startJobs()
+		case <-j.done:
+			log.Println("Job runner is shutting down...")
+			return
+		}
+	}
+}
+
+// Stop signals the runner to stop. Running jobs are interrupted and queued
+// again, so they start over after the restart.
+func (j *JobRunner) Stop() {
+	close(j.done)
+	j.cancel()
+	j.wg.Wait()
+	log.Println("Job runner stopped")
+}
+
+// startJobs claims queued jobs one at a time until this replica is busy or no
+// owner has a free slot
+func (j *JobRunner) startJobs() {
+	for len(j.sem) < cap(j.sem) {
+		job, ok := j.claimJob()
+		if !ok {
+			return
+		}
+
+		j.sem <- struct{}{}
+		j.wg.Add(1)
+		go func(job queuedJob) {
+			defer j.wg.Done()
+			defer func() { <-j.sem }()
+			j.runJob(job)
+		}(job)
+	}
+}
+
+// claimJob marks the oldest queued job whose owner is below the per-user
+// limit as running on this replica
+func (j *JobRunner) claimJob() (queuedJob, bool) {
+	var job queuedJob
+
+	for {
+		var owner string
+		var rawParams []byte
+		err := j.db.QueryRow(`
+			SELECT j.id, j.owner, j.kind, j.params
+			FROM job j
+			WHERE j.status = ?
+				AND (SELECT COUNT(*) FROM job r WHERE r.owner = j.owner AND r.status = ?) < ?
+			ORDER BY j.id ASC
+			LIMIT 1
+		`, models.JobStatusQueued, models.JobStatusRunning, j.cfg.MaxPerUser).Scan(&job.id, &owner, &job.kind, &rawParams)
+
+		if err == sql.ErrNoRows {
+			return job, false
+		} else if err != nil {
+			log.Printf("Error querying queued jobs: %v", err)
+			return job, false
+		}
+
+		claimed, err := j.claimQueued(job.id, owner)
+		if err != nil {
+			log.Printf("Error claiming job (ID: %d): %v", job.id, err)
+			return job, false
+		}
+		if !claimed {
+			continue // Another replica got there first, the job was cancelled or the owner hit the limit
+		}
+
+		if err := json.Unmarshal(rawParams, &job.params); err != nil {
+			j.failJob(job.id, fmt.Errorf("invalid job parameters: %w", err))
+			continue
+		}
+
+		return job, true
+	}
+}
+
+// claimQueued marks a queued job as running when its owner is still below
+// the per-user limit. The owner's user row is locked first, so replicas
+// claiming jobs of the same owner check the limit one at a time.
+func (j *JobRunner) claimQueued(id int, owner string) (bool, error) {
+	tx, err := j.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var locked string
+	err = tx.QueryRow("SELECT username FROM user WHERE username = ? FOR UPDATE", owner).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return false, nil // Owner deleted, its jobs go with it
+	} else if err != nil {
+		return false, err
+	}
+
+	// A locking read sees the jobs claimed by transactions committed meanwhile
+	var running int
+	err = tx.QueryRow("SELECT COUNT(*) FROM job WHERE owner = ? AND status = ? FOR UPDATE",
+		owner, models.JobStatusRunning).Scan(&running)
+	if err != nil {
+		return false, err
+	}
+	if running >= j.cfg.MaxPerUser {
+		return false, nil
+	}
+
+	now := time.Now()
+	res, err := tx.Exec(`
+		UPDATE job
+		SET status = ?,
+			claimed_by = ?,
+			dt_start = ?,
+			dt_update = ?
+		WHERE id = ? AND status = ?
+	`, models.JobStatusRunning, j.instanceID, now, now, id, models.JobStatusQueued)
+	if err != nil {
+		return false, err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return false, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// runJob writes the result of a job while a heartbeat records its progress.
+// The heartbeat stops the job when it is no longer running, which is how a
+// cancellation reaches the replica that runs it.
+func (j *JobRunner) runJob(job queuedJob) {
+	ctx, cancel := context.WithCancel(j.ctx)
+	defer cancel()
+
+	var rows atomic.Int64
+	var cancelled atomic.Bool
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		ticker := time.NewTicker(jobHeartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				res, err := j.db.Exec("UPDATE job SET row_count = ?, dt_update = ? WHERE id = ? AND status = ?",
+					rows.Load(), time.Now(), job.id, models.JobStatusRunning)
+				if err != nil {
+					log.Printf("Error updating job progress (ID: %d): %v", job.id, err)
+					continue
+				}
+				if affected, _ := res.RowsAffected(); affected == 0 {
+					cancelled.Store(true)
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var count int
+	size, err := j.store.Write(job.id, job.params.Options.Format, func(w io.Writer) error {
+		var err error
+		count, err = jobs.Run(ctx, j.db, job.kind, job.params, w, func(n int) error {
+			rows.Store(int64(n))
+			return ctx.Err()
+		})
+		return err
+	})
+
+	cancel()
+	<-heartbeatDone
+
+	switch {
+	case cancelled.Load():
+		log.Printf("Job %d was cancelled", job.id)
+		return
+	case j.ctx.Err() != nil:
+		j.requeueJob(job.id)
+		return
+	case err != nil:
+		j.failJob(job.id, err)
+		return
+	}
+
+	now := time.Now()
+	res, err := j.db.Exec(`
+		UPDATE job
+		SET status = ?,
+			row_count = ?,
+			result_size = ?,
+			dt_update = ?,
+			dt_finish = ?,
+			dt_expire = ?
+		WHERE id = ? AND status = ?
+	`, models.JobStatusDone, count, size, now, now, now.Add(j.cfg.ResultTTL), job.id, models.JobStatusRunning)
+	if err != nil {
+		log.Printf("Error finishing job (ID: %d): %v", job.id, err)
+		return
+	}
+
+	// Cancelled between the last heartbeat and now
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		if err := j.store.Remove(job.id, job.params.Options.Format); err != nil {
+			log.Printf("Error removing result of cancelled job (ID: %d): %v", job.id, err)
+		}
+		return
+	}
+
+	log.Printf("Job %d finished with %d row(s)", job.id, count)
+}
+
+// failJob records why a job failed; the job is removed with the other expired jobs
+func (j *JobRunner) failJob(jobID int, cause error) {
+	log.Printf("Job %d failed: %v", jobID, cause)
+
+	now := time.Now()
+	_, err := j.db.Exec(`
+		UPDATE job
+		SET status = ?,
+			error = ?,
+			dt_finish = ?,
+			dt_expire = ?
+		WHERE id = ? AND status = ?
+	`, models.JobStatusFailed, truncate(cause.Error(), 500), now, now.Add(j.cfg.ResultTTL), jobID, models.JobStatusRunning)
+	if err != nil {
+		log.Printf("Error marking job as failed (ID: %d): %v", jobID, err)
+	}
+}
+
+// requeueJob puts a job interrupted by shutdown back in the queue
+func (j *JobRunner) requeueJob(jobID int) {
+	_, err := j.db.Exec(`
+		UPDATE job
+		SET status = ?,
+			row_count = 0,
+			claimed_by = NULL,
+			dt_start = NULL,
+			dt_update = NULL
+		WHERE id = ? AND status = ?
+	`, models.JobStatusQueued, jobID, models.JobStatusRunning)
+	if err != nil {
+		log.Printf("Error requeueing job (ID: %d): %v", jobID, err)
+		return
+	}
+	log.Printf("Job %d was interrupted and queued again", jobID)
+}
@@ -9,25 +9,36 @@ import (
 	"net/http"
 	"sync"
 	"time"
+	"unicode/utf8"
 
+	"github.com/partadox/wags_queue/internal/attachment"
 	"github.com/partadox/wags_queue/internal/config"
 	"github.com/partadox/wags_queue/internal/models"
+	"github.com/partadox/wags_queue/internal/suppression"
 )
 
+// maxFailureReasonLength matches the failure_reason columns of message and message_bulk
+const maxFailureReasonLength = 500
+
+// suppressedReason is the failure reason of messages to suppressed recipients
+const suppressedReason = "Recipient is on the suppression list"
+
 // MessageWorker handles the processing of queued messages
 type MessageWorker struct {
 	db          *sql.DB
 	externalAPI config.ExternalAPIConfig
+	signer      *attachment.Signer
 	client      *http.Client
 	done        chan struct{}
 	wg          sync.WaitGroup
 }
 
 // NewMessageWorker creates a new message worker
-func NewMessageWorker(db *sql.DB, externalAPI config.ExternalAPIConfig) *MessageWorker {
+func NewMessageWorker(db *sql.DB, externalAPI config.ExternalAPIConfig, signer *attachment.Signer) *MessageWorker {
 	return &MessageWorker{
 		db:          db,
 		externalAPI: externalAPI,
+		signer:      signer,
 		client:      &http.Client{Timeout: 30 * time.Second},
 		done:        make(chan struct{}),
 	}
@@ -74,14 +85,14 @@ func (w *MessageWorker) processMessages() {
 		}
 	}()
 
-	// Get a batch of messages to process
+	// Get a batch of messages that are due, urgent ones (such as auto-replies) first
 	rows, err := tx.Query(`
-		SELECT id, sender, recipient, message 
+		SELECT id, sender, recipient, message, content_type, payload 
 		FROM message 
-		WHERE status = ? 
-		ORDER BY dt_queue ASC 
+		WHERE status = ? AND dt_queue <= ? 
+		ORDER BY priority DESC, dt_queue ASC 
 		LIMIT 10
-	`, models.StatusPending)
+	`, models.StatusPending, time.Now())
 
 	if err != nil {
 		tx.Rollback()
@@ -93,10 +104,16 @@ func (w *MessageWorker) processMessages() {
 	messagesToProcess := make([]models.Message, 0)
 	for rows.Next() {
 		var msg models.Message
-		if err := rows.Scan(&msg.ID, &msg.Sender, &msg.Recipient, &msg.MessageContent); err != nil {
+		var payloadJSON []byte
+		if err := rows.Scan(&msg.ID, &msg.Sender, &msg.Recipient, &msg.MessageContent, &msg.ContentType, &payloadJSON); err != nil {
 			log.Printf("Error scanning message row: %v", err)
 			continue
 		}
+		if payloadJSON != nil {
+			if err := json.Unmarshal(payloadJSON, &msg.Payload); err != nil {
+				log.Printf("Error decoding message payload (ID: %d): %v", msg.ID, err)
+			}
+		}
 		messagesToProcess = append(messagesToProcess, msg)
 	}
 
@@ -118,7 +135,8 @@ func (w *MessageWorker) processMessages() {
 	for _, msg := range messagesToProcess {
 		_, err := tx.Exec(`
 			UPDATE message 
-			SET status = ? 
+			SET status = ?, 
+				attempts = attempts + 1 
 			WHERE id = ?
 		`, models.StatusProcessing, msg.ID)
 
@@ -142,23 +160,41 @@ func (w *MessageWorker) processMessages() {
 
 // sendMessage sends a message to the external API
 func (w *MessageWorker) sendMessage(msg models.Message) {
-	// Prepare request to external API
-	type ExternalAPIRequest struct {
-		Recipient string `json:"recipient"`
-		Message   string `json:"message"`
-		// Sender    string `json:"from"`
+	// Recipients may have opted out after the message was queued
+	blocked, err := suppression.IsBlocked(w.db, msg.Sender, msg.Recipient)
+	if err != nil {
+		// Most likely a passing database problem; try again on the next tick
+		log.Printf("Error checking suppression list (ID: %d), message stays queued: %v", msg.ID, err)
+		w.requeueMessage(msg.ID)
+		return
+	}
+	if blocked {
+		w.updateMessageStatus(msg.ID, models.StatusSuppressed, "", suppressedReason)
+		log.Printf("Message suppressed (ID: %d)", msg.ID)
+		return
+	}
+
+	// Give the gateway a short-lived link to uploaded attachments
+	if msg.Payload != nil && msg.Payload.Media != nil && msg.Payload.Media.AttachmentID != "" {
+		if err := w.signAttachment(msg.Payload.Media); err != nil {
+			log.Printf("Error preparing attachment (ID: %d): %v", msg.ID, err)
+			w.updateMessageStatus(msg.ID, models.StatusFailed, "", fmt.Sprintf("Error preparing attachment: %v", err))
+			return
+		}
 	}
 
-	reqBody := ExternalAPIRequest{
-		Recipient: msg.Recipient,
-		Message:   msg.MessageContent,
-		// Sender:    msg.Sender,
+	// Prepare request to external API for the message type
+	reqBody, err := buildGatewayRequest(msg)
+	if err != nil {
+		log.Printf("Error preparing message (ID: %d): %v", msg.ID, err)
+		w.updateMessageStatus(msg.ID, models.StatusFailed, "", fmt.Sprintf("Error preparing request: %v", err))
+		return
 	}
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		log.Printf("Error marshalling message data (ID: %d): %v", msg.ID, err)
-		w.updateMessageStatus(msg.ID, models.StatusFailed, fmt.Sprintf("Error preparing request: %v", err))
+		w.updateMessageStatus(msg.ID, models.StatusFailed, "", fmt.Sprintf("Error preparing request: %v", err))
 		return
 	}
 
@@ -167,7 +203,7 @@ func (w *MessageWorker) sendMessage(msg models.Message) {
 	req, err := http.NewRequest("POST", fullURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		log.Printf("Error creating HTTP request (ID: %d): %v", msg.ID, err)
-		w.updateMessageStatus(msg.ID, models.StatusFailed, fmt.Sprintf("Error creating request: %v", err))
+		w.updateMessageStatus(msg.ID, models.StatusFailed, "", fmt.Sprintf("Error creating request: %v", err))
 		return
 	}
 
@@ -179,7 +215,7 @@ func (w *MessageWorker) sendMessage(msg models.Message) {
 	resp, err := w.client.Do(req)
 	if err != nil {
 		log.Printf("Error sending message to external API (ID: %d): %v", msg.ID, err)
-		w.updateMessageStatus(msg.ID, models.StatusFailed, fmt.Sprintf("Error sending to external API: %v", err))
+		w.updateMessageStatus(msg.ID, models.StatusFailed, "", fmt.Sprintf("Error sending to external API: %v", err))
 		return
 	}
 	defer resp.Body.Close()
@@ -189,7 +225,8 @@ func (w *MessageWorker) sendMessage(msg models.Message) {
 	err = json.NewDecoder(resp.Body).Decode(&respBody)
 	if err != nil {
 		log.Printf("Error decoding API response (ID: %d): %v", msg.ID, err)
-		w.updateMessageStatus(msg.ID, models.StatusFailed, "Error decoding API response")
+		w.updateMessageStatus(msg.ID, models.StatusFailed, "", 
+			fmt.Sprintf("Error decoding API response (HTTP %d)", resp.StatusCode))
 		return
 	}
 
@@ -199,25 +236,75 @@ func (w *MessageWorker) sendMessage(msg models.Message) {
 
 	// Update message status based on API response
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		w.updateMessageStatus(msg.ID, models.StatusSent, respStr)
+		w.updateMessageStatus(msg.ID, models.StatusSent, respStr, "")
 		log.Printf("Message sent successfully (ID: %d)", msg.ID)
 	} else {
-		w.updateMessageStatus(msg.ID, models.StatusFailed, respStr)
+		w.updateMessageStatus(msg.ID, models.StatusFailed, respStr, 
+			fmt.Sprintf("Gateway rejected the message (HTTP %d)", resp.StatusCode))
 		log.Printf("Message sending failed (ID: %d): %s", msg.ID, respStr)
 	}
 }
 
-// updateMessageStatus updates the status of a message
-func (w *MessageWorker) updateMessageStatus(messageID int, status models.MessageStatus, apiResponse string) {
+// signAttachment replaces an attachment reference with a signed download URL
+func (w *MessageWorker) signAttachment(media *models.MediaPayload) error {
+	var exists bool
+	err := w.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM attachment WHERE id = ? AND dt_expire > ?)
+	`, media.AttachmentID, time.Now()).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("attachment %s expired", media.AttachmentID)
+	}
+
+	media.URL = w.signer.URL(media.AttachmentID)
+	return nil
+}
+
+// updateMessageStatus updates the status of a message. apiResponse is what the
+// gateway answered and failureReason why the message was not sent; either may
+// be empty.
+func (w *MessageWorker) updateMessageStatus(messageID int, status models.MessageStatus, apiResponse, failureReason string) {
 	_, err := w.db.Exec(`
 		UPDATE message 
 		SET status = ?, 
 			dt_send = ?, 
-			external_api_response = ? 
+			external_api_response = ?, 
+			failure_reason = ? 
 		WHERE id = ?
-	`, status, time.Now(), apiResponse, messageID)
+	`, status, time.Now(), nullIfEmpty(apiResponse), nullIfEmpty(truncate(failureReason, maxFailureReasonLength)), messageID)
 
 	if err != nil {
 		log.Printf("Error updating message status (ID: %d): %v", messageID, err)
 	}
 }
+
+// requeueMessage hands a message picked up for sending back to the queue
+func (w *MessageWorker) requeueMessage(messageID int) {
+	_, err := w.db.Exec(`
+		UPDATE message 
+		SET status = ? 
+		WHERE id = ? AND status = ?
+	`, models.StatusPending, messageID, models.StatusProcessing)
+
+	if err != nil {
+		log.Printf("Error requeueing message (ID: %d): %v", messageID, err)
+	}
+}
+
+// nullIfEmpty stores an empty string as NULL
+func nullIfEmpty(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// truncate shortens a string to at most max bytes without splitting a UTF-8 character
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	for max > 0 && !utf8.RuneStart(value[max]) {
+		max--
+	}
+	return value[:max]
+}
@@ -0,0 +1,6 @@
+-- Klaim per baris untuk message_bulk agar hanya satu replica yang mengonversi sebuah broadcast
+ALTER TABLE `message_bulk`
+    MODIFY `status` ENUM('PROCESS', 'EXPANDING', 'DONE', 'FAILED') DEFAULT 'PROCESS',
+    ADD COLUMN `claimed_by` VARCHAR(100) NULL AFTER `dt_convert`,
+    ADD COLUMN `dt_claim` DATETIME NULL AFTER `claimed_by`,
+    ADD INDEX `idx_status_dt_claim` (`status`, `dt_claim`);
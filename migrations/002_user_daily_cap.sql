@@ -0,0 +1,3 @@
+-- Batas pesan harian per sender untuk penjadwalan broadcast multi-hari
+ALTER TABLE `user`
+    ADD COLUMN `daily_cap` INT NULL AFTER `key`;
@@ -0,0 +1,29 @@
+-- Library template pesan dengan versi
+CREATE TABLE IF NOT EXISTS `template` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `body` TEXT NOT NULL,
+    `variables` JSON NOT NULL,
+    `version` INT NOT NULL DEFAULT 1,
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    `dt_delete` DATETIME NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_owner_name` (`owner`, `name`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS `template_version` (
+    `template_id` INT NOT NULL,
+    `version` INT NOT NULL,
+    `body` TEXT NOT NULL,
+    `variables` JSON NOT NULL,
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`template_id`, `version`),
+    FOREIGN KEY (`template_id`) REFERENCES `template`(`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+ALTER TABLE `message`
+    ADD COLUMN `template_id` INT NULL AFTER `external_api_response`,
+    ADD COLUMN `template_version` INT NULL AFTER `template_id`;
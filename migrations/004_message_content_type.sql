@@ -0,0 +1,4 @@
+-- Pesan media (gambar, dokumen, audio, video) dan lokasi
+ALTER TABLE `message`
+    ADD COLUMN `content_type` ENUM('text', 'image', 'document', 'audio', 'video', 'location') NOT NULL DEFAULT 'text' AFTER `template_version`,
+    ADD COLUMN `payload` JSON NULL AFTER `content_type`;
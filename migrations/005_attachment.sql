@@ -0,0 +1,15 @@
+-- Tabel untuk file lampiran yang diunggah (disimpan di disk berdasarkan hash)
+CREATE TABLE IF NOT EXISTS `attachment` (
+    `id` CHAR(32) NOT NULL, -- ID acak yang dipakai di URL
+    `owner` VARCHAR(50) NOT NULL,
+    `sha256` CHAR(64) NOT NULL, -- Nama file di disk, file yang sama dipakai bersama
+    `size` BIGINT NOT NULL,
+    `mime_type` VARCHAR(100) NOT NULL,
+    `filename` VARCHAR(255) NOT NULL,
+    `dt_store` DATETIME NOT NULL,
+    `dt_expire` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_sha256` (`sha256`),
+    INDEX `idx_dt_expire` (`dt_expire`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
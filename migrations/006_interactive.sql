@@ -0,0 +1,19 @@
+-- Pesan interaktif (tombol dan list) beserta balasannya
+ALTER TABLE `message`
+    MODIFY `content_type` ENUM('text', 'image', 'document', 'audio', 'video', 'location', 'interactive') NOT NULL DEFAULT 'text';
+
+-- Tabel untuk balasan tombol/list dari pesan interaktif
+CREATE TABLE IF NOT EXISTS `message_reply` (
+    `id` INT AUTO_INCREMENT,
+    `message_id` INT NULL, -- Pesan interaktif yang dibalas, jika bisa ditemukan
+    `sender` VARCHAR(50) NOT NULL,
+    `recipient` VARCHAR(20) NOT NULL, -- Nomor yang membalas
+    `reply_type` VARCHAR(20) NOT NULL, -- button_reply atau list_reply
+    `reply_id` VARCHAR(256) NOT NULL,
+    `reply_title` VARCHAR(100) NULL,
+    `dt_receive` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_message_id` (`message_id`),
+    FOREIGN KEY (`sender`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (`message_id`) REFERENCES `message`(`id`) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
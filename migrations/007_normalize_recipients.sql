@@ -0,0 +1,22 @@
+-- Normalisasi nomor penerima yang sudah tersimpan ke format E.164 tanpa tanda plus
+-- (sama dengan package internal/phone). Sesuaikan '62' jika PHONE_DEFAULT_COUNTRY berbeda.
+-- Membutuhkan MySQL 8.0+ untuk REGEXP_REPLACE.
+
+-- 1. Hapus pemisah (spasi, strip, titik, kurung)
+UPDATE `message` SET `recipient` = REGEXP_REPLACE(`recipient`, '[ .()-]', '') WHERE `recipient` REGEXP '[ .()-]';
+UPDATE `message_reply` SET `recipient` = REGEXP_REPLACE(`recipient`, '[ .()-]', '') WHERE `recipient` REGEXP '[ .()-]';
+
+-- 2. Nomor internasional dengan tanda plus: +62812... -> 62812...
+UPDATE `message` SET `recipient` = SUBSTRING(`recipient`, 2) WHERE `recipient` LIKE '+%';
+UPDATE `message_reply` SET `recipient` = SUBSTRING(`recipient`, 2) WHERE `recipient` LIKE '+%';
+
+-- 3. Prefix panggilan internasional: 0062812... -> 62812...
+UPDATE `message` SET `recipient` = SUBSTRING(`recipient`, 3) WHERE `recipient` LIKE '00%';
+UPDATE `message_reply` SET `recipient` = SUBSTRING(`recipient`, 3) WHERE `recipient` LIKE '00%';
+
+-- 4. Nomor nasional: 0812... -> 62812...
+UPDATE `message` SET `recipient` = CONCAT('62', SUBSTRING(`recipient`, 2)) WHERE `recipient` LIKE '0%';
+UPDATE `message_reply` SET `recipient` = CONCAT('62', SUBSTRING(`recipient`, 2)) WHERE `recipient` LIKE '0%';
+
+-- Sisa baris yang tidak valid bisa dicek dengan:
+-- SELECT id, recipient FROM `message` WHERE `recipient` NOT REGEXP '^[1-9][0-9]{7,14}$';
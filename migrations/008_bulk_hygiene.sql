@@ -0,0 +1,15 @@
+-- Laporan pembersihan daftar penerima pada bulk message
+ALTER TABLE `message_bulk`
+    ADD COLUMN `hygiene` JSON NULL AFTER `bulk`;
+
+-- Daftar nomor yang diblokir per sender
+CREATE TABLE IF NOT EXISTS `suppression` (
+    `id` INT AUTO_INCREMENT,
+    `sender` VARCHAR(50) NOT NULL,
+    `recipient` VARCHAR(20) NOT NULL,
+    `reason` VARCHAR(255) NULL,
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_sender_recipient` (`sender`, `recipient`),
+    FOREIGN KEY (`sender`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,13 @@
+-- Response yang disimpan per user untuk header Idempotency-Key
+CREATE TABLE IF NOT EXISTS `idempotency_key` (
+    `username` VARCHAR(50) NOT NULL,
+    `idem_key` VARCHAR(255) NOT NULL, -- Nilai header Idempotency-Key
+    `request_hash` CHAR(64) NOT NULL, -- SHA-256 dari endpoint dan body request
+    `status_code` SMALLINT NOT NULL DEFAULT 0, -- 0: request pertama masih diproses
+    `response` MEDIUMBLOB NULL,
+    `dt_store` DATETIME NOT NULL,
+    `dt_expire` DATETIME NOT NULL,
+    PRIMARY KEY (`username`, `idem_key`),
+    INDEX `idx_dt_expire` (`dt_expire`),
+    FOREIGN KEY (`username`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,7 @@
+-- Status SUPPRESSED untuk pesan ke penerima yang ada di daftar suppression
+ALTER TABLE `message`
+    MODIFY COLUMN `status` ENUM('PENDING', 'SENT', 'FAILED', 'PROCESSING', 'SUPPRESSED') DEFAULT 'PENDING';
+
+-- Asal entri suppression (API, import CSV atau kata kunci opt-out dari penerima)
+ALTER TABLE `suppression`
+    ADD COLUMN `source` ENUM('api', 'import', 'keyword') NOT NULL DEFAULT 'api' AFTER `reason`;
@@ -0,0 +1,22 @@
+-- Kategori pesan: transactional (default) atau marketing
+ALTER TABLE `message`
+    ADD COLUMN `category` ENUM('transactional', 'marketing') NOT NULL DEFAULT 'transactional' AFTER `payload`;
+
+ALTER TABLE `message_bulk`
+    ADD COLUMN `category` ENUM('transactional', 'marketing') NOT NULL DEFAULT 'transactional' AFTER `hygiene`;
+
+-- Persetujuan (opt-in) penerima untuk pesan marketing
+CREATE TABLE IF NOT EXISTS `consent` (
+    `id` INT AUTO_INCREMENT,
+    `sender` VARCHAR(50) NOT NULL,
+    `recipient` VARCHAR(20) NOT NULL, -- Format sama dengan message.recipient
+    `source` VARCHAR(100) NOT NULL, -- Asal opt-in, contoh: web-form, kasir, import
+    `evidence` TEXT NULL, -- Bukti opt-in (ID form, teks persetujuan, dsb.)
+    `dt_consent` DATETIME NOT NULL, -- Waktu penerima memberi persetujuan
+    `dt_expire` DATETIME NULL,
+    `dt_revoke` DATETIME NULL, -- Diisi saat persetujuan dicabut; baris tidak dihapus sebagai bukti
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_sender_recipient` (`sender`, `recipient`),
+    FOREIGN KEY (`sender`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,21 @@
+-- Pesan masuk dari penerima
+CREATE TABLE IF NOT EXISTS `inbound_message` (
+    `id` INT AUTO_INCREMENT,
+    `sender` VARCHAR(50) NOT NULL, -- User yang nomornya menerima pesan
+    `recipient` VARCHAR(20) NOT NULL, -- Nomor yang mengirim pesan, format sama dengan message.recipient
+    `external_id` VARCHAR(128) NULL, -- ID pesan dari gateway, untuk mengabaikan event yang dikirim ulang
+    `type` VARCHAR(20) NOT NULL, -- text, button_reply, list_reply, dst.
+    `message` TEXT NULL,
+    `reply_id` VARCHAR(256) NULL,
+    `reply_title` VARCHAR(100) NULL,
+    `dt_receive` DATETIME NOT NULL,
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_sender_external_id` (`sender`, `external_id`),
+    INDEX `idx_sender_recipient_dt_receive` (`sender`, `recipient`, `dt_receive`),
+    FOREIGN KEY (`sender`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Index untuk menyusun percakapan per penerima
+ALTER TABLE `message`
+    ADD INDEX `idx_sender_recipient` (`sender`, `recipient`);
@@ -0,0 +1,40 @@
+-- Prioritas pengiriman; balasan otomatis dikirim lebih dulu
+ALTER TABLE `message`
+    ADD COLUMN `priority` TINYINT NOT NULL DEFAULT 0 AFTER `category`,
+    ADD INDEX `idx_status_priority_dt_queue` (`status`, `priority`, `dt_queue`);
+
+-- Aturan balasan otomatis dan riwayatnya
+CREATE TABLE IF NOT EXISTS `auto_reply_rule` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `match_type` ENUM('exact', 'contains', 'regex') NOT NULL,
+    `pattern` VARCHAR(500) NOT NULL,
+    `reply_message` TEXT NULL, -- Diisi jika tidak memakai template
+    `template_id` INT NULL,
+    `variables` JSON NULL, -- Nilai variabel template untuk balasan
+    `active_from` CHAR(5) NULL, -- HH:MM, NULL berarti aktif sepanjang hari
+    `active_until` CHAR(5) NULL, -- HH:MM, boleh lebih kecil dari active_from (melewati tengah malam)
+    `cooldown_seconds` INT NOT NULL DEFAULT 0, -- Jeda minimal antar balasan ke penerima yang sama
+    `position` INT NOT NULL DEFAULT 0, -- Aturan dicoba berurutan dari position terkecil
+    `enabled` BOOLEAN NOT NULL DEFAULT TRUE,
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_owner_position` (`owner`, `position`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (`template_id`) REFERENCES `template`(`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS `auto_reply_log` (
+    `id` INT AUTO_INCREMENT,
+    `rule_id` INT NOT NULL,
+    `sender` VARCHAR(50) NOT NULL,
+    `recipient` VARCHAR(20) NOT NULL,
+    `message_id` INT NULL, -- Balasan yang diantrikan
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_rule_recipient_dt_store` (`rule_id`, `recipient`, `dt_store`),
+    FOREIGN KEY (`rule_id`) REFERENCES `auto_reply_rule`(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`message_id`) REFERENCES `message`(`id`) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
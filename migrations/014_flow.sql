@@ -0,0 +1,29 @@
+-- Alur percakapan (bot menu) dan posisi setiap penerima di dalamnya
+CREATE TABLE IF NOT EXISTS `flow` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `definition` JSON NOT NULL, -- State, transisi, timeout dan variabel; lihat internal/flow
+    `position` INT NOT NULL DEFAULT 0, -- Alur dicoba berurutan dari position terkecil
+    `enabled` BOOLEAN NOT NULL DEFAULT TRUE,
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_owner_position` (`owner`, `position`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS `flow_session` (
+    `id` INT AUTO_INCREMENT,
+    `flow_id` INT NOT NULL,
+    `sender` VARCHAR(50) NOT NULL,
+    `recipient` VARCHAR(20) NOT NULL,
+    `state` VARCHAR(100) NOT NULL,
+    `vars` JSON NULL, -- Variabel yang sudah ditangkap
+    `dt_start` DATETIME NOT NULL,
+    `dt_update` DATETIME NOT NULL,
+    `dt_expire` DATETIME NOT NULL, -- Sesi dianggap selesai setelah waktu ini
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_sender_recipient` (`sender`, `recipient`), -- Satu sesi per penerima
+    FOREIGN KEY (`flow_id`) REFERENCES `flow`(`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
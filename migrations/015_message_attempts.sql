@@ -0,0 +1,4 @@
+-- Jumlah percobaan kirim dan alasan gagal, untuk API status pesan
+ALTER TABLE `message`
+    ADD COLUMN `attempts` INT NOT NULL DEFAULT 0 AFTER `priority`,
+    ADD COLUMN `failure_reason` VARCHAR(500) NULL AFTER `attempts`;
@@ -0,0 +1,7 @@
+-- Index untuk paginasi cursor (dt_store, id) dan filter bulk_id pada riwayat pesan
+ALTER TABLE `message`
+    ADD INDEX `idx_sender_dt_store_id` (`sender`, `dt_store`, `id`),
+    ADD INDEX `idx_sender_type` (`sender`, `type`);
+
+ALTER TABLE `message_bulk`
+    ADD INDEX `idx_sender_dt_store_id` (`sender`, `dt_store`, `id`);
@@ -0,0 +1,3 @@
+-- Pencarian teks penuh pada isi pesan
+ALTER TABLE `message`
+    ADD FULLTEXT INDEX `ft_message` (`message`);
@@ -0,0 +1,23 @@
+-- Job ekspor dan laporan yang dijalankan di background; hasilnya disimpan sebagai file
+CREATE TABLE IF NOT EXISTS `job` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `kind` VARCHAR(30) NOT NULL, -- export_messages, export_broadcast atau report_daily
+    `status` ENUM('QUEUED', 'RUNNING', 'DONE', 'FAILED', 'CANCELLED') NOT NULL DEFAULT 'QUEUED',
+    `params` JSON NOT NULL, -- Filter dan opsi ekspor; lihat internal/jobs
+    `format` VARCHAR(10) NOT NULL, -- csv atau xlsx
+    `row_count` INT NOT NULL DEFAULT 0, -- Jumlah baris yang sudah ditulis
+    `result_size` BIGINT NULL, -- Ukuran file hasil dalam byte
+    `error` VARCHAR(500) NULL,
+    `claimed_by` VARCHAR(100) NULL, -- Replika yang menjalankan job
+    `dt_store` DATETIME NOT NULL,
+    `dt_start` DATETIME NULL,
+    `dt_update` DATETIME NULL, -- Diperbarui selama job berjalan; job yang macet dianggap gagal
+    `dt_finish` DATETIME NULL,
+    `dt_expire` DATETIME NULL, -- Job dan file hasilnya dihapus setelah waktu ini
+    PRIMARY KEY (`id`),
+    INDEX `idx_status_owner` (`status`, `owner`),
+    INDEX `idx_owner_dt_store` (`owner`, `dt_store`),
+    INDEX `idx_dt_expire` (`dt_expire`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
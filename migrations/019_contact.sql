@@ -0,0 +1,36 @@
+-- Kontak milik setiap user beserta atributnya, untuk dipakai ulang di broadcast
+CREATE TABLE IF NOT EXISTS `contact` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `phone` VARCHAR(20) NOT NULL, -- Format sama dengan message.recipient
+    `name` VARCHAR(100) NULL,
+    `attributes` JSON NULL, -- Objek nama ke nilai teks; dipakai sebagai variabel template
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_owner_phone` (`owner`, `phone`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Grup kontak; broadcast dengan group_ids dikirim ke anggota grup saat diekspansi
+CREATE TABLE IF NOT EXISTS `contact_group` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `description` VARCHAR(255) NULL,
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_owner_name` (`owner`, `name`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS `contact_group_member` (
+    `group_id` INT NOT NULL,
+    `contact_id` INT NOT NULL,
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`group_id`, `contact_id`),
+    INDEX `idx_contact_id` (`contact_id`),
+    FOREIGN KEY (`group_id`) REFERENCES `contact_group`(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`contact_id`) REFERENCES `contact`(`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
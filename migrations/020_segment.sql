@@ -0,0 +1,13 @@
+-- Segmen audiens: ekspresi filter atas atribut kontak, dievaluasi saat broadcast diekspansi
+CREATE TABLE IF NOT EXISTS `segment` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `description` VARCHAR(255) NULL,
+    `expression` TEXT NOT NULL, -- Contoh: tier = "gold" AND city = "Bandung"; lihat internal/segment
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_owner_name` (`owner`, `name`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,4 @@
+-- Status COMPLETED: semua pesan broadcast sudah berstatus akhir (SENT, FAILED atau SUPPRESSED)
+ALTER TABLE `message_bulk`
+    MODIFY `status` ENUM('PROCESS', 'EXPANDING', 'DONE', 'COMPLETED', 'FAILED') DEFAULT 'PROCESS',
+    ADD COLUMN `dt_complete` DATETIME NULL AFTER `dt_convert`;
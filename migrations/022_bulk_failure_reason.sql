@@ -0,0 +1,3 @@
+-- Alasan broadcast berstatus FAILED, misalnya ekspresi segmen yang tidak valid
+ALTER TABLE `message_bulk`
+    ADD COLUMN `failure_reason` VARCHAR(500) NULL AFTER `dt_complete`;
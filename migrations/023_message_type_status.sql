@@ -0,0 +1,4 @@
+-- Index untuk tracker broadcast: cek pesan PENDING/PROCESSING per broadcast tanpa membaca baris tabel
+ALTER TABLE `message`
+    DROP INDEX `idx_sender_type`,
+    ADD INDEX `idx_sender_type_status` (`sender`, `type`, `status`);
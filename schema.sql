@@ -7,6 +7,7 @@ USE db_wags;
 CREATE TABLE IF NOT EXISTS `user` (
     `username` VARCHAR(50) NOT NULL,
     `key` VARCHAR(255) NOT NULL, -- Simpan hash password, bukan plain text
+    `daily_cap` INT NULL, -- Batas pesan per hari untuk sender ini, NULL = pakai SENDER_DAILY_CAP
     PRIMARY KEY (`username`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
@@ -14,11 +15,19 @@ CREATE TABLE IF NOT EXISTS `user` (
 CREATE TABLE IF NOT EXISTS `message_bulk` (
     `id` INT AUTO_INCREMENT,
     `sender` VARCHAR(50) NOT NULL,
-    `status` ENUM('PROCESS', 'DONE', 'FAILED') DEFAULT 'PROCESS',
+    `status` ENUM('PROCESS', 'EXPANDING', 'DONE', 'COMPLETED', 'FAILED') DEFAULT 'PROCESS', -- EXPANDING: sedang dikonversi oleh salah satu replica, DONE: pesan sudah dibuat, COMPLETED: semua pesan berstatus akhir
     `dt_store` DATETIME NOT NULL,
     `dt_convert` DATETIME NULL,
+    `dt_complete` DATETIME NULL, -- Waktu pesan terakhir selesai dikirim
+    `failure_reason` VARCHAR(500) NULL, -- Alasan broadcast berstatus FAILED
+    `claimed_by` VARCHAR(100) NULL, -- ID replica yang sedang mengonversi bulk ini
+    `dt_claim` DATETIME NULL,
     `bulk` JSON NOT NULL,
+    `hygiene` JSON NULL, -- Laporan pembersihan daftar penerima (duplikat, tidak valid, diblokir)
+    `category` ENUM('transactional', 'marketing') NOT NULL DEFAULT 'transactional', -- marketing: hanya ke penerima dengan consent
     PRIMARY KEY (`id`),
+    INDEX `idx_status_dt_claim` (`status`, `dt_claim`),
+    INDEX `idx_sender_dt_store_id` (`sender`, `dt_store`, `id`), -- Paginasi cursor riwayat broadcast
     FOREIGN KEY (`sender`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
@@ -27,22 +36,94 @@ CREATE TABLE IF NOT EXISTS `message` (
     `id` INT AUTO_INCREMENT,
     `sender` VARCHAR(50) NOT NULL,
     `recipient` VARCHAR(20) NOT NULL, -- Nomor telepon, contoh: 628123456789
-    `status` ENUM('PENDING', 'SENT', 'FAILED', 'PROCESSING') DEFAULT 'PENDING', -- PROCESSING ditambahkan untuk menandakan sedang dikirim
+    `status` ENUM('PENDING', 'SENT', 'FAILED', 'PROCESSING', 'SUPPRESSED') DEFAULT 'PENDING', -- PROCESSING: sedang dikirim, SUPPRESSED: penerima ada di daftar suppression
     `type` VARCHAR(50) NULL, -- Jika berasal dari bulk, simpan message_bulk.id
     `dt_store` DATETIME NOT NULL,
     `dt_queue` DATETIME NOT NULL,
     `dt_send` DATETIME NULL,
     `message` TEXT NOT NULL,
     `external_api_response` TEXT NULL, -- Untuk menyimpan response dari API eksternal
+    `template_id` INT NULL, -- Template yang dipakai (jika ada), untuk audit
+    `template_version` INT NULL,
+    `content_type` ENUM('text', 'image', 'document', 'audio', 'video', 'location', 'interactive') NOT NULL DEFAULT 'text', -- `type` sudah dipakai untuk ID bulk
+    `payload` JSON NULL, -- Data media (url, filename, mime_type), lokasi (latitude, longitude) atau tombol/list interaktif
+    `category` ENUM('transactional', 'marketing') NOT NULL DEFAULT 'transactional',
+    `priority` TINYINT NOT NULL DEFAULT 0, -- Semakin tinggi semakin dulu dikirim (auto-reply: 10)
+    `attempts` INT NOT NULL DEFAULT 0, -- Berapa kali worker mengambil pesan ini untuk dikirim
+    `failure_reason` VARCHAR(500) NULL, -- Alasan pesan FAILED atau SUPPRESSED
     PRIMARY KEY (`id`),
     FOREIGN KEY (`sender`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE,
-    INDEX `idx_status_dt_queue` (`status`, `dt_queue`) -- Index untuk membantu query worker
+    INDEX `idx_status_dt_queue` (`status`, `dt_queue`), -- Index untuk membantu query worker
+    INDEX `idx_status_priority_dt_queue` (`status`, `priority`, `dt_queue`),
+    INDEX `idx_sender_recipient` (`sender`, `recipient`), -- Index untuk percakapan per penerima
+    INDEX `idx_sender_dt_store_id` (`sender`, `dt_store`, `id`), -- Paginasi cursor riwayat pesan
+    INDEX `idx_sender_type_status` (`sender`, `type`, `status`), -- Filter pesan per broadcast dan cek selesai
+    FULLTEXT INDEX `ft_message` (`message`) -- Pencarian isi pesan
     -- Jika `type` merujuk ke `message_bulk.id`, bisa ditambahkan FOREIGN KEY constraint
     -- FOREIGN KEY (`type`) REFERENCES `message_bulk`(`id`) ON DELETE SET NULL ON UPDATE CASCADE;
     -- Namun karena `type` adalah VARCHAR untuk menyimpan ID, konversi tipe data perlu diperhatikan jika FK diterapkan.
     -- Untuk kesederhanaan awal, kita biarkan sebagai VARCHAR.
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
+-- Tabel untuk template pesan
+CREATE TABLE IF NOT EXISTS `template` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `body` TEXT NOT NULL,
+    `variables` JSON NOT NULL, -- Daftar nama variabel yang dideklarasikan
+    `version` INT NOT NULL DEFAULT 1,
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    `dt_delete` DATETIME NULL, -- Soft delete agar riwayat versi tetap bisa diaudit
+    PRIMARY KEY (`id`),
+    INDEX `idx_owner_name` (`owner`, `name`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Riwayat setiap versi template
+CREATE TABLE IF NOT EXISTS `template_version` (
+    `template_id` INT NOT NULL,
+    `version` INT NOT NULL,
+    `body` TEXT NOT NULL,
+    `variables` JSON NOT NULL,
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`template_id`, `version`),
+    FOREIGN KEY (`template_id`) REFERENCES `template`(`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Tabel untuk file lampiran yang diunggah (disimpan di disk berdasarkan hash)
+CREATE TABLE IF NOT EXISTS `attachment` (
+    `id` CHAR(32) NOT NULL, -- ID acak yang dipakai di URL
+    `owner` VARCHAR(50) NOT NULL,
+    `sha256` CHAR(64) NOT NULL, -- Nama file di disk, file yang sama dipakai bersama
+    `size` BIGINT NOT NULL,
+    `mime_type` VARCHAR(100) NOT NULL,
+    `filename` VARCHAR(255) NOT NULL,
+    `dt_store` DATETIME NOT NULL,
+    `dt_expire` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_sha256` (`sha256`),
+    INDEX `idx_dt_expire` (`dt_expire`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Tabel untuk balasan tombol/list dari pesan interaktif
+CREATE TABLE IF NOT EXISTS `message_reply` (
+    `id` INT AUTO_INCREMENT,
+    `message_id` INT NULL, -- Pesan interaktif yang dibalas, jika bisa ditemukan
+    `sender` VARCHAR(50) NOT NULL,
+    `recipient` VARCHAR(20) NOT NULL, -- Nomor yang membalas
+    `reply_type` VARCHAR(20) NOT NULL, -- button_reply atau list_reply
+    `reply_id` VARCHAR(256) NOT NULL,
+    `reply_title` VARCHAR(100) NULL,
+    `dt_receive` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_message_id` (`message_id`),
+    FOREIGN KEY (`sender`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (`message_id`) REFERENCES `message`(`id`) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
 -- Contoh data user (password harus di-hash di aplikasi)
 -- Ganti 'hashed_password_telkomsel' dengan hasil hash bcrypt atau sejenisnya
 INSERT INTO `user` (`username`, `key`) VALUES
@@ -55,3 +136,206 @@ INSERT INTO `user` (`username`, `key`) VALUES
 -- 3. `external_api_response` ditambahkan untuk logging.
 -- 4. Status 'PROCESSING' ditambahkan di tabel `message` agar worker bisa menandai pesan yang sedang diproses.
 -- 5. Index `idx_status_dt_queue` ditambahkan untuk optimasi query pengambilan antrian.
+-- 6. Perubahan skema untuk database yang sudah berjalan ada di folder `migrations/`, jalankan berurutan.
+
+-- Tabel nomor yang tidak boleh dikirimi pesan oleh sender tertentu
+CREATE TABLE IF NOT EXISTS `suppression` (
+    `id` INT AUTO_INCREMENT,
+    `sender` VARCHAR(50) NOT NULL,
+    `recipient` VARCHAR(20) NOT NULL, -- Format sama dengan message.recipient
+    `reason` VARCHAR(255) NULL,
+    `source` ENUM('api', 'import', 'keyword') NOT NULL DEFAULT 'api', -- keyword: penerima membalas kata kunci opt-out (STOP, BERHENTI)
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_sender_recipient` (`sender`, `recipient`),
+    FOREIGN KEY (`sender`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Tabel response yang disimpan untuk header Idempotency-Key
+CREATE TABLE IF NOT EXISTS `idempotency_key` (
+    `username` VARCHAR(50) NOT NULL,
+    `idem_key` VARCHAR(255) NOT NULL, -- Nilai header Idempotency-Key
+    `request_hash` CHAR(64) NOT NULL, -- SHA-256 dari endpoint dan body request
+    `status_code` SMALLINT NOT NULL DEFAULT 0, -- 0: request pertama masih diproses
+    `response` MEDIUMBLOB NULL,
+    `dt_store` DATETIME NOT NULL,
+    `dt_expire` DATETIME NOT NULL,
+    PRIMARY KEY (`username`, `idem_key`),
+    INDEX `idx_dt_expire` (`dt_expire`),
+    FOREIGN KEY (`username`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Tabel persetujuan (opt-in) penerima untuk pesan marketing
+CREATE TABLE IF NOT EXISTS `consent` (
+    `id` INT AUTO_INCREMENT,
+    `sender` VARCHAR(50) NOT NULL,
+    `recipient` VARCHAR(20) NOT NULL, -- Format sama dengan message.recipient
+    `source` VARCHAR(100) NOT NULL, -- Asal opt-in, contoh: web-form, kasir, import
+    `evidence` TEXT NULL, -- Bukti opt-in (ID form, teks persetujuan, dsb.)
+    `dt_consent` DATETIME NOT NULL, -- Waktu penerima memberi persetujuan
+    `dt_expire` DATETIME NULL,
+    `dt_revoke` DATETIME NULL, -- Diisi saat persetujuan dicabut; baris tidak dihapus sebagai bukti
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_sender_recipient` (`sender`, `recipient`),
+    FOREIGN KEY (`sender`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Tabel pesan masuk dari penerima (diterima gateway)
+CREATE TABLE IF NOT EXISTS `inbound_message` (
+    `id` INT AUTO_INCREMENT,
+    `sender` VARCHAR(50) NOT NULL, -- User yang nomornya menerima pesan
+    `recipient` VARCHAR(20) NOT NULL, -- Nomor yang mengirim pesan, format sama dengan message.recipient
+    `external_id` VARCHAR(128) NULL, -- ID pesan dari gateway, untuk mengabaikan event yang dikirim ulang
+    `type` VARCHAR(20) NOT NULL, -- text, button_reply, list_reply, dst.
+    `message` TEXT NULL,
+    `reply_id` VARCHAR(256) NULL,
+    `reply_title` VARCHAR(100) NULL,
+    `dt_receive` DATETIME NOT NULL,
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_sender_external_id` (`sender`, `external_id`),
+    INDEX `idx_sender_recipient_dt_receive` (`sender`, `recipient`, `dt_receive`),
+    FOREIGN KEY (`sender`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Tabel aturan balasan otomatis dan riwayatnya (untuk cooldown)
+CREATE TABLE IF NOT EXISTS `auto_reply_rule` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `match_type` ENUM('exact', 'contains', 'regex') NOT NULL,
+    `pattern` VARCHAR(500) NOT NULL,
+    `reply_message` TEXT NULL, -- Diisi jika tidak memakai template
+    `template_id` INT NULL,
+    `variables` JSON NULL, -- Nilai variabel template untuk balasan
+    `active_from` CHAR(5) NULL, -- HH:MM, NULL berarti aktif sepanjang hari
+    `active_until` CHAR(5) NULL, -- HH:MM, boleh lebih kecil dari active_from (melewati tengah malam)
+    `cooldown_seconds` INT NOT NULL DEFAULT 0, -- Jeda minimal antar balasan ke penerima yang sama
+    `position` INT NOT NULL DEFAULT 0, -- Aturan dicoba berurutan dari position terkecil
+    `enabled` BOOLEAN NOT NULL DEFAULT TRUE,
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_owner_position` (`owner`, `position`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (`template_id`) REFERENCES `template`(`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS `auto_reply_log` (
+    `id` INT AUTO_INCREMENT,
+    `rule_id` INT NOT NULL,
+    `sender` VARCHAR(50) NOT NULL,
+    `recipient` VARCHAR(20) NOT NULL,
+    `message_id` INT NULL, -- Balasan yang diantrikan
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_rule_recipient_dt_store` (`rule_id`, `recipient`, `dt_store`),
+    FOREIGN KEY (`rule_id`) REFERENCES `auto_reply_rule`(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`message_id`) REFERENCES `message`(`id`) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Alur percakapan (bot menu) dan posisi setiap penerima di dalamnya
+CREATE TABLE IF NOT EXISTS `flow` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `definition` JSON NOT NULL, -- State, transisi, timeout dan variabel; lihat internal/flow
+    `position` INT NOT NULL DEFAULT 0, -- Alur dicoba berurutan dari position terkecil
+    `enabled` BOOLEAN NOT NULL DEFAULT TRUE,
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_owner_position` (`owner`, `position`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS `flow_session` (
+    `id` INT AUTO_INCREMENT,
+    `flow_id` INT NOT NULL,
+    `sender` VARCHAR(50) NOT NULL,
+    `recipient` VARCHAR(20) NOT NULL,
+    `state` VARCHAR(100) NOT NULL,
+    `vars` JSON NULL, -- Variabel yang sudah ditangkap
+    `dt_start` DATETIME NOT NULL,
+    `dt_update` DATETIME NOT NULL,
+    `dt_expire` DATETIME NOT NULL, -- Sesi dianggap selesai setelah waktu ini
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_sender_recipient` (`sender`, `recipient`), -- Satu sesi per penerima
+    FOREIGN KEY (`flow_id`) REFERENCES `flow`(`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Job ekspor dan laporan yang dijalankan di background; hasilnya disimpan sebagai file
+CREATE TABLE IF NOT EXISTS `job` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `kind` VARCHAR(30) NOT NULL, -- export_messages, export_broadcast atau report_daily
+    `status` ENUM('QUEUED', 'RUNNING', 'DONE', 'FAILED', 'CANCELLED') NOT NULL DEFAULT 'QUEUED',
+    `params` JSON NOT NULL, -- Filter dan opsi ekspor; lihat internal/jobs
+    `format` VARCHAR(10) NOT NULL, -- csv atau xlsx
+    `row_count` INT NOT NULL DEFAULT 0, -- Jumlah baris yang sudah ditulis
+    `result_size` BIGINT NULL, -- Ukuran file hasil dalam byte
+    `error` VARCHAR(500) NULL,
+    `claimed_by` VARCHAR(100) NULL, -- Replika yang menjalankan job
+    `dt_store` DATETIME NOT NULL,
+    `dt_start` DATETIME NULL,
+    `dt_update` DATETIME NULL, -- Diperbarui selama job berjalan; job yang macet dianggap gagal
+    `dt_finish` DATETIME NULL,
+    `dt_expire` DATETIME NULL, -- Job dan file hasilnya dihapus setelah waktu ini
+    PRIMARY KEY (`id`),
+    INDEX `idx_status_owner` (`status`, `owner`),
+    INDEX `idx_owner_dt_store` (`owner`, `dt_store`),
+    INDEX `idx_dt_expire` (`dt_expire`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Kontak milik setiap user beserta atributnya, untuk dipakai ulang di broadcast
+CREATE TABLE IF NOT EXISTS `contact` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `phone` VARCHAR(20) NOT NULL, -- Format sama dengan message.recipient
+    `name` VARCHAR(100) NULL,
+    `attributes` JSON NULL, -- Objek nama ke nilai teks; dipakai sebagai variabel template
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_owner_phone` (`owner`, `phone`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Grup kontak; broadcast dengan group_ids dikirim ke anggota grup saat diekspansi
+CREATE TABLE IF NOT EXISTS `contact_group` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `description` VARCHAR(255) NULL,
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_owner_name` (`owner`, `name`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS `contact_group_member` (
+    `group_id` INT NOT NULL,
+    `contact_id` INT NOT NULL,
+    `dt_store` DATETIME NOT NULL,
+    PRIMARY KEY (`group_id`, `contact_id`),
+    INDEX `idx_contact_id` (`contact_id`),
+    FOREIGN KEY (`group_id`) REFERENCES `contact_group`(`id`) ON DELETE CASCADE,
+    FOREIGN KEY (`contact_id`) REFERENCES `contact`(`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Segmen audiens: ekspresi filter atas atribut kontak, dievaluasi saat broadcast diekspansi
+CREATE TABLE IF NOT EXISTS `segment` (
+    `id` INT AUTO_INCREMENT,
+    `owner` VARCHAR(50) NOT NULL,
+    `name` VARCHAR(100) NOT NULL,
+    `description` VARCHAR(255) NULL,
+    `expression` TEXT NOT NULL, -- Contoh: tier = "gold" AND city = "Bandung"; lihat internal/segment
+    `dt_store` DATETIME NOT NULL,
+    `dt_update` DATETIME NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_owner_name` (`owner`, `name`),
+    FOREIGN KEY (`owner`) REFERENCES `user`(`username`) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
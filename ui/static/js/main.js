@@ -4,7 +4,9 @@
 const state = {
     username: '',
     apiKey: '',
-    currentPage: 'dashboard'
+    currentPage: 'dashboard',
+    messagesCursor: '',
+    broadcastsCursor: ''
 };
 
 // DOM Elements
@@ -21,6 +23,8 @@ const sendMessageForm = document.getElementById('send-message-form');
 const sendBulkForm = document.getElementById('send-bulk-form');
 const messagesFilterBtn = document.getElementById('messages-filter-btn');
 const broadcastsFilterBtn = document.getElementById('broadcasts-filter-btn');
+const messagesMoreBtn = document.getElementById('messages-more-btn');
+const broadcastsMoreBtn = document.getElementById('broadcasts-more-btn');
 
 // Initialize Bootstrap modals
 const messageDetailsModal = new bootstrap.Modal(document.getElementById('message-details-modal'));
@@ -196,18 +200,13 @@ async function loadDashboard() {
         const currentYear = now.getFullYear();
         const currentMonth = now.getMonth() + 1; // JavaScript months are 0-indexed
         
-        // Get message counts
-        const messages = await apiRequest(`/ui/messages?year=${currentYear}&month=${currentMonth}`);
+        // Get message counts; the totals cover the whole month, so one row is enough
+        const data = await apiRequest(`/ui/messages?year=${currentYear}&month=${currentMonth}&limit=1`);
         
         // Calculate statistics
-        let totalMessages = messages.length;
-        let sentMessages = 0;
-        let failedMessages = 0;
-        
-        messages.forEach(msg => {
-            if (msg.status === 'SENT') sentMessages++;
-            if (msg.status === 'FAILED') failedMessages++;
-        });
+        let totalMessages = data.total;
+        let sentMessages = data.status_counts.SENT || 0;
+        let failedMessages = data.status_counts.FAILED || 0;
         
         // Update dashboard UI
         document.getElementById('total-messages').textContent = totalMessages;
@@ -220,21 +219,40 @@ async function loadDashboard() {
     }
 }
 
-// Load Messages
-async function loadMessages() {
-    const year = document.getElementById('messages-year').value;
-    const month = document.getElementById('messages-month').value;
-    
+// Build the query string of a listing from its filters and the page cursor
+function listQuery(prefix, cursor) {
+    const params = new URLSearchParams({
+        year: document.getElementById(`${prefix}-year`).value,
+        month: document.getElementById(`${prefix}-month`).value
+    });
+    const status = document.getElementById(`${prefix}-status`).value;
+    if (status) params.set('status', status);
+    if (cursor) params.set('cursor', cursor);
+    return params.toString();
+}
+
+// Load Messages; with more set, the next page is appended
+async function loadMessages(more = false) {
     const messagesLoading = document.getElementById('messages-loading');
     const messagesTableBody = document.getElementById('messages-table-body');
+    const messagesTotal = document.getElementById('messages-total');
     
     try {
         messagesLoading.classList.remove('d-none');
-        messagesTableBody.innerHTML = '';
+        messagesMoreBtn.classList.add('d-none');
+        if (!more) {
+            state.messagesCursor = '';
+            messagesTableBody.innerHTML = '';
+        }
         
-        const messages = await apiRequest(`/ui/messages?year=${year}&month=${month}`);
+        const data = await apiRequest(`/ui/messages?${listQuery('messages', state.messagesCursor)}`);
+        const messages = data.messages;
         
-        if (messages.length === 0) {
+        state.messagesCursor = data.next_cursor || '';
+        messagesMoreBtn.classList.toggle('d-none', !state.messagesCursor);
+        messagesTotal.textContent = `${data.total} message(s)`;
+        
+        if (messages.length === 0 && !more) {
             messagesTableBody.innerHTML = `
                 <tr>
                     <td colspan="8" class="text-center">No messages found</td>
@@ -311,24 +329,31 @@ async function loadMessages() {
     }
 }
 
-// Load Broadcasts
-async function loadBroadcasts() {
-    const year = document.getElementById('broadcasts-year').value;
-    const month = document.getElementById('broadcasts-month').value;
-    
+// Load Broadcasts; with more set, the next page is appended
+async function loadBroadcasts(more = false) {
     const broadcastsLoading = document.getElementById('broadcasts-loading');
     const broadcastsTableBody = document.getElementById('broadcasts-table-body');
+    const broadcastsTotal = document.getElementById('broadcasts-total');
     
     try {
         broadcastsLoading.classList.remove('d-none');
-        broadcastsTableBody.innerHTML = '';
+        broadcastsMoreBtn.classList.add('d-none');
+        if (!more) {
+            state.broadcastsCursor = '';
+            broadcastsTableBody.innerHTML = '';
+        }
+        
+        const data = await apiRequest(`/ui/broadcasts?${listQuery('broadcasts', state.broadcastsCursor)}`);
+        const broadcasts = data.broadcasts;
         
-        const broadcasts = await apiRequest(`/ui/broadcasts?year=${year}&month=${month}`);
+        state.broadcastsCursor = data.next_cursor || '';
+        broadcastsMoreBtn.classList.toggle('d-none', !state.broadcastsCursor);
+        broadcastsTotal.textContent = `${data.total} broadcast(s)`;
         
-        if (broadcasts.length === 0) {
+        if (broadcasts.length === 0 && !more) {
             broadcastsTableBody.innerHTML = `
                 <tr>
-                    <td colspan="5" class="text-center">No broadcasts found</td>
+                    <td colspan="6" class="text-center">No broadcasts found</td>
                 </tr>
             `;
         } else {
@@ -343,6 +368,7 @@ async function loadBroadcasts() {
                         </td>
                         <td>${bulk.dt_store}</td>
                         <td>${bulk.dt_convert || '-'}</td>
+                        <td>${bulk.progress ? `${bulk.progress.percent_complete}% (${bulk.progress.finished}/${bulk.progress.total})` : '-'}</td>
                         <td>
                             <button class="btn btn-sm btn-info view-broadcast" data-id="${bulk.id}">
                                 <i class="bi bi-eye"></i> View Details
@@ -365,7 +391,7 @@ async function loadBroadcasts() {
         console.error('Error loading broadcasts:', error);
         broadcastsTableBody.innerHTML = `
             <tr>
-                <td colspan="5" class="text-center text-danger">Error loading broadcasts: ${error.message}</td>
+                <td colspan="6" class="text-center text-danger">Error loading broadcasts: ${error.message}</td>
             </tr>
         `;
     } finally {
@@ -547,6 +573,14 @@ broadcastsFilterBtn.addEventListener('click', () => {
     loadBroadcasts();
 });
 
+messagesMoreBtn.addEventListener('click', () => {
+    loadMessages(true);
+});
+
+broadcastsMoreBtn.addEventListener('click', () => {
+    loadBroadcasts(true);
+});
+
 // Initialize with current date for filters
 document.addEventListener('DOMContentLoaded', () => {
     const now = new Date();